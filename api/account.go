@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"minitube/models"
+	"minitube/store"
+	"minitube/utils"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Account deletion.
+// A deleted account is hidden and its sessions are revoked at once, logging in
+// during the grace period restores it, after that it's purged in background.
+var (
+	accountDeletionGrace = durationFromEnv("ACCOUNT_DELETION_GRACE", 7*24*time.Hour)
+	accountPurgeInterval = durationFromEnv("ACCOUNT_PURGE_INTERVAL", time.Hour)
+)
+
+// max accounts purged at once
+const accountPurgeBatch = 100
+
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+func deleteMe(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+
+	confirm := new(models.DeleteAccountModel)
+	if err := c.ShouldBind(confirm); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+
+	user, err := store.GetUserByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "User not exists",
+		})
+		return
+	}
+
+	if !confirmPassword(c, user, confirm.Password) {
+		return
+	}
+
+	at := time.Now().Add(accountDeletionGrace)
+	err = store.ScheduleUserDeletion(c.Request.Context(), user, at)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	// stop current stream, the room is hidden from now on
+	resetStreamKeyFromLive(c, user)
+	if err := store.EndLiving(c.Request.Context(), user.Username); err != nil {
+		c.Error(err)
+	}
+
+	logger(c).Infow("User scheduled account deletion", "user", user, "at", at)
+	audit(c, models.AuditAccountDeleteSchedule, user, nil, gin.H{"deletion_scheduled_at": at})
+	c.JSON(http.StatusOK, gin.H{
+		"code":                  http.StatusOK,
+		"message":               "OK",
+		"deletion_scheduled_at": at,
+	})
+}
+
+// cancelAccountDeletion - user logins in grace period, keep the account
+func cancelAccountDeletion(c *gin.Context, user *models.User) error {
+	err := store.CancelUserDeletion(c.Request.Context(), user)
+	if err != nil {
+		c.Error(err)
+		return err
+	}
+	logger(c).Infow("User canceled account deletion", "user", user)
+	event := newAuditEvent(c, models.AuditAccountDeleteCancel, user, nil, nil)
+	event.ActorID, event.ActorUsername = &user.ID, user.Username
+	saveAudit(c, event)
+	return nil
+}
+
+// RunAccountPurge - purge accounts whose grace period is over, every ACCOUNT_PURGE_INTERVAL until ctx is done.
+func RunAccountPurge(ctx context.Context) {
+	ticker := time.NewTicker(accountPurgeInterval)
+	defer ticker.Stop()
+	for {
+		n, err := purgeDeletedAccounts(ctx, time.Now())
+		if err != nil {
+			log.Warnw("Purge deleted accounts failed", "error", err)
+		} else if n > 0 {
+			log.Infow("Deleted accounts purged", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeDeletedAccounts - delete accounts scheduled to be deleted before now, with their rooms,
+// stream keys and everything in redis. Return how many accounts are deleted, accounts failed
+// to delete are kept and retried next time.
+func purgeDeletedAccounts(ctx context.Context, now time.Time) (int, error) {
+	ctx, span := utils.Tracer.Start(ctx, "purgeDeletedAccounts")
+	defer span.End()
+
+	users, err := store.GetUsersDueForDeletion(ctx, now, accountPurgeBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, user := range users {
+		if e := purgeAccount(ctx, user); e != nil {
+			log.Warnw("Purge deleted account failed", "user", user, "error", e)
+			err = e
+			continue
+		}
+		n++
+	}
+	// err is the last failure, if any
+	return n, err
+}
+
+func purgeAccount(ctx context.Context, user *models.User) error {
+	// revoke stream key first, or the room can still be published to
+	err := deleteStreamKeyFromLive(ctx, user.Username)
+	if err != nil {
+		return err
+	}
+	err = store.ResetLoginFailures(ctx, userSubject(user.ID))
+	if err != nil {
+		return err
+	}
+	err = store.DeleteUser(ctx, user)
+	if err != nil {
+		return err
+	}
+	// actor is nil, it's done by system
+	err = store.SaveAuditEvent(ctx, &models.AuditEvent{
+		Action:       models.AuditAccountDelete,
+		TargetUserID: &user.ID,
+		Target:       user.Username,
+	})
+	if err != nil {
+		log.Warnw("Save audit event of deleted account failed", "user", user, "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"errors"
+	"minitube/models"
+	"minitube/store"
+	"minitube/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zapcore"
+)
+
+// logLevelModel - change log level request model
+type logLevelModel struct {
+	Level string `form:"level" json:"level" binding:"required,oneof=debug info warn error"`
+}
+
+func getLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":  http.StatusOK,
+		"level": utils.Level.String(),
+	})
+}
+
+func setLogLevel(c *gin.Context) {
+	req := new(logLevelModel)
+	if err := c.ShouldBind(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+
+	var level zapcore.Level
+	if err := level.Set(req.Level); err != nil {
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+
+	old := utils.Level.Level()
+	utils.Level.SetLevel(level)
+	logger(c).Infow("Log level changed", "from", old.String(), "to", level.String())
+	audit(c, models.AuditAdminLogLevel, nil, gin.H{"level": old.String()}, gin.H{"level": level.String()})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":  http.StatusOK,
+		"level": utils.Level.String(),
+	})
+}
+
+// userByUsername - get user by username, response is written on failure
+func userByUsername(c *gin.Context, username string) (*models.User, bool) {
+	user, err := store.GetUserByUsername(c.Request.Context(), username)
+	if err != nil {
+		if errors.Is(err, store.ErrRedisUserNotExists) || errors.Is(err, store.ErrMySQLUserNotExists) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "User not exists.",
+			})
+			return nil, false
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return nil, false
+	}
+	return user, true
+}
+
+// targetUser - get the user of :username for admin actions, response is written on failure
+func targetUser(c *gin.Context) (*models.User, bool) {
+	return userByUsername(c, c.Param("username"))
+}
+
+// managedUser - get the user of :username for admin actions the current user must
+// outrank the target for, see models.User.CanManage, response is written on failure
+func managedUser(c *gin.Context) (*models.User, bool) {
+	user, ok := targetUser(c)
+	if !ok {
+		return nil, false
+	}
+	if !canManage(c, user) {
+		forbidManage(c)
+		return nil, false
+	}
+	return user, true
+}
+
+// canManage - whether the current user may act on user as admin
+func canManage(c *gin.Context, user *models.User) bool {
+	actor := currentUser(c)
+	return actor != nil && actor.CanManage(user)
+}
+
+func forbidManage(c *gin.Context) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"code":    http.StatusForbidden,
+		"message": "You can't manage this user.",
+	})
+}
+
+// adminResponse - respond with the changed user, or server error if err isn't nil
+func adminResponse(c *gin.Context, user *models.User, err error) {
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	admin := models.NewAdminUserFromUser(user)
+	admin.Living, _ = store.GetUserIsLiving(c.Request.Context(), user.Username)
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"user": admin,
+	})
+}
+
+// pageOf - default page and size of list requests
+func pageOf(page, size int) (int, int) {
+	if page == 0 {
+		page = 1
+	}
+	if size == 0 {
+		size = 20
+	}
+	return page, size
+}
+
+func searchUsers(c *gin.Context) {
+	req := new(models.SearchUserModel)
+	if err := c.ShouldBindQuery(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+	page, size := pageOf(req.Page, req.Size)
+
+	users, total, err := store.SearchUsers(c.Request.Context(), req.Query, page, size)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	list := make([]*models.AdminUser, len(users))
+	for i, user := range users {
+		list[i] = models.NewAdminUserFromUser(user)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":  http.StatusOK,
+		"total": total,
+		"users": list,
+	})
+}
+
+func getAdminUser(c *gin.Context) {
+	user, ok := targetUser(c)
+	if !ok {
+		return
+	}
+	adminResponse(c, user, nil)
+}
+
+func suspendUser(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+	req := new(models.SuspendModel)
+	if err := c.ShouldBind(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+	if req.Until != nil && req.Until.Before(time.Now()) {
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+	user, ok := managedUser(c)
+	if !ok {
+		return
+	}
+
+	err := suspend(c, user, req.Reason, req.Until, id)
+	adminResponse(c, user, err)
+}
+
+// suspend - suspend user and stop user's stream
+func suspend(c *gin.Context, user *models.User, reason string, until *time.Time, by uint) error {
+	err := store.SuspendUser(c.Request.Context(), user, reason, until, by)
+	if err == nil {
+		// stop current stream, and the leaked key can't be used to publish
+		resetStreamKeyFromLive(c, user)
+		err = store.EndLiving(c.Request.Context(), user.Username)
+	}
+	logger(c).Infow("User suspended by admin", "user", user, "until", until, "error", err)
+	if err == nil {
+		audit(c, models.AuditAdminSuspend, user, nil, gin.H{"reason": reason, "until": until})
+	}
+	return err
+}
+
+func unsuspendUser(c *gin.Context) {
+	user, ok := managedUser(c)
+	if !ok {
+		return
+	}
+
+	err := store.UnsuspendUser(c.Request.Context(), user)
+	logger(c).Infow("User unsuspended by admin", "user", user, "error", err)
+	if err == nil {
+		audit(c, models.AuditAdminUnsuspend, user, nil, nil)
+	}
+	adminResponse(c, user, err)
+}
+
+func requirePasswordReset(c *gin.Context) {
+	user, ok := managedUser(c)
+	if !ok {
+		return
+	}
+
+	err := store.RequirePasswordReset(c.Request.Context(), user)
+	logger(c).Infow("Password reset required by admin", "user", user, "error", err)
+	if err == nil {
+		audit(c, models.AuditAdminPasswordReset, user, nil, nil)
+	}
+	adminResponse(c, user, err)
+}
+
+func revokeSessions(c *gin.Context) {
+	user, ok := managedUser(c)
+	if !ok {
+		return
+	}
+
+	err := store.RevokeSessions(c.Request.Context(), user)
+	logger(c).Infow("Sessions revoked by admin", "user", user, "error", err)
+	if err == nil {
+		audit(c, models.AuditAdminRevokeSession, user, nil, nil)
+	}
+	adminResponse(c, user, err)
+}
+
+func endLive(c *gin.Context) {
+	user, ok := targetUser(c)
+	if !ok {
+		return
+	}
+
+	// a new stream key stops the streamer from publishing again with the old one
+	if resetStreamKeyFromLive(c, user) == "" {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"code":    http.StatusBadGateway,
+			"message": "Live service error.",
+		})
+		return
+	}
+	err := store.EndLiving(c.Request.Context(), user.Username)
+	logger(c).Infow("Live ended by admin", "user", user, "error", err)
+	if err == nil {
+		audit(c, models.AuditAdminEndLive, user, nil, nil)
+	}
+	adminResponse(c, user, err)
+}
+
+func deleteUser(c *gin.Context) {
+	user, ok := managedUser(c)
+	if !ok {
+		return
+	}
+
+	resetStreamKeyFromLive(c, user)
+	err := store.DeleteUser(c.Request.Context(), user)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	logger(c).Infow("User deleted by admin", "user", user)
+	audit(c, models.AuditAdminDelete, user, nil, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "OK",
+	})
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"minitube/middleware"
@@ -14,7 +15,12 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
 // Router - gin global router
@@ -30,9 +36,15 @@ func init() {
 	}
 
 	Router = gin.New()
+	if err := Router.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
 
-	Router.Use(middleware.Ginzap(utils.Logger, time.RFC3339, true))
+	Router.Use(middleware.Tracing(utils.TracerProvider, utils.ServiceName))
+	Router.Use(middleware.RequestID(utils.Logger))
+	Router.Use(middleware.Ginzap(utils.AccessLogger, time.RFC3339, true))
 	Router.Use(middleware.RecoveryWithZap(utils.Logger, true))
+	Router.Use(csrfMiddleware)
 
 	Router.LoadHTMLFiles("./out/index.html", "./out/live/[streamer].html", "./out/mine.html",
 		"./out/login.html", "./out/register.html", "./out/404.html")
@@ -55,8 +67,14 @@ func init() {
 		c.HTML(http.StatusOK, "mine.html", nil)
 	})
 	Router.GET("/live/:username", func(c *gin.Context) {
-		if id, ok := getUserID(c); ok {
-			go store.UpdateWatchHistory(id, c.Param("username"))
+		channel, err := store.GetUserByUsername(c.Request.Context(), c.Param("username"))
+		if err != nil {
+			if username, ok := usernameRedirect(c, c.Param("username")); ok {
+				c.Redirect(http.StatusFound, "/live/"+username)
+				return
+			}
+		} else if id, ok := getUserID(c); ok {
+			go recordWatch(context.WithoutCancel(c.Request.Context()), id, channel.ID)
 		}
 		c.HTML(http.StatusOK, "[streamer].html", nil)
 	})
@@ -64,28 +82,90 @@ func init() {
 		c.HTML(http.StatusNotFound, "404.html", nil)
 	})
 
-	Router.POST("/register", register)
-	Router.POST("/login", authMiddleware.LoginHandler)
+	// rate limits, budgets are shared by all instances through redis
+	registerLimit := rateLimit("register", perMinuteFromEnv("RATE_LIMIT_REGISTER", 5), middleware.KeyByIP)
+	loginLimit := rateLimit("login", perMinuteFromEnv("RATE_LIMIT_LOGIN", 10), middleware.KeyByIP)
+	publicLimit := rateLimit("public", perMinuteFromEnv("RATE_LIMIT_PUBLIC", 120), middleware.KeyByIP)
+	followLimit := rateLimit("follow", perMinuteFromEnv("RATE_LIMIT_FOLLOW", 30), middleware.KeyByUser(authMiddleware.IdentityKey))
+	reportLimit := rateLimit("report", perMinuteFromEnv("RATE_LIMIT_REPORT", 5), middleware.KeyByUser(authMiddleware.IdentityKey))
+	exportLimit := rateLimit("export", perMinuteFromEnv("RATE_LIMIT_EXPORT", 1), middleware.KeyByUser(authMiddleware.IdentityKey))
+
+	Router.POST("/register", registerLimit, register)
+	Router.POST("/login", loginLimit, authMiddleware.LoginHandler)
+	Router.GET("/login/challenge", loginLimit, getLoginChallenge)
+	Router.GET("/password/range/:prefix", publicLimit, getPasswordRange)
+	Router.GET("/.well-known/jwks.json", publicLimit, getJWKS)
+	Router.GET("/oauth/:provider/login", loginLimit, oidcLogin)
+	Router.GET("/oauth/:provider/callback", loginLimit, oidcCallback)
 	Router.POST("/refresh", authMiddleware.RefreshHandler)
 	Router.POST("/logout", authMiddleware.LogoutHandler)
 
 	Router.GET("/followers/:username", getFollowers)
 	Router.GET("/followings/:username", getFollowings)
-	Router.GET("/profile/:username", getPublicUser)
+	Router.GET("/profile/:username", publicLimit, getPublicUser)
 	Router.GET("/living/:num", getLivingList)
+	// anonymous users get recommendations too, so it's outside userGroup
+	Router.GET("/user/recommendations", publicLimit, getRecommendations)
+	Router.POST("/report", authMiddleware.MiddlewareFunc(), reportLimit, createReport)
+	Router.GET("/export/:id/download", publicLimit, middleware.SignedURL(exportSecret), downloadExport)
 
 	userGroup := Router.Group("/user")
 	userGroup.Use(authMiddleware.MiddlewareFunc())
 	userGroup.GET("/me", getMe)
+	userGroup.DELETE("/me", deleteMe)
 	userGroup.POST("/profile", updateUserProfile)
 	userGroup.POST("/password", changePassword)
-	userGroup.POST("/follow/:username", follow)
+	userGroup.POST("/username", changeUsername)
+	userGroup.POST("/follow/:username", followLimit, follow)
 	userGroup.POST("/unfollow/:username", unFollow)
+	userGroup.POST("/block/:username", followLimit, block)
+	userGroup.POST("/unblock/:username", unblock)
+	userGroup.GET("/blocks", getBlocks)
 	userGroup.GET("/history", getHistory)
+	userGroup.DELETE("/history", clearHistory)
+	userGroup.DELETE("/history/:username", deleteHistory)
+	userGroup.GET("/history/sessions", getWatchSessions)
+	userGroup.GET("/history/stats", getWatchStats)
+	userGroup.GET("/watch/:username", getWatchResume)
+	userGroup.POST("/watch/:username", watchHeartbeat)
+	userGroup.GET("/privacy", getPrivacy)
+	userGroup.POST("/privacy", updatePrivacy)
+	userGroup.GET("/notifications", getNotifications)
+	userGroup.GET("/security-log", getSecurityLog)
+	userGroup.POST("/export", exportLimit, createExport)
+	userGroup.GET("/export/:id", getExport)
+	userGroup.GET("/followers", getMyFollowers)
+	userGroup.GET("/following/live", getFollowingLive)
+	userGroup.GET("/tokens", listAPITokens)
+	userGroup.POST("/tokens", createAPIToken)
+	userGroup.DELETE("/tokens/:id", revokeAPIToken)
+
+	Router.GET("/chat/:username/permission", authMiddleware.MiddlewareFunc(), getChatPermission)
 
 	streamGroup := Router.Group("/stream")
 	streamGroup.Use(authMiddleware.MiddlewareFunc())
-	streamGroup.GET("/key/:username", getStreamKey)
+	streamGroup.GET("/key/:username", middleware.Authorize(middleware.Owner("username", "username")), getStreamKey)
+
+	adminGroup := Router.Group("/admin")
+	adminGroup.Use(authMiddleware.MiddlewareFunc(), requirePermissions(models.PermAdminAccess))
+	adminGroup.GET("/log/level", requirePermissions(models.PermLogLevel), getLogLevel)
+	adminGroup.POST("/log/level", requirePermissions(models.PermLogLevel), setLogLevel)
+	adminGroup.GET("/users", requirePermissions(models.PermUserRead), searchUsers)
+	adminGroup.GET("/users/:username", requirePermissions(models.PermUserRead), getAdminUser)
+	adminGroup.DELETE("/users/:username", requirePermissions(models.PermUserDelete), deleteUser)
+	adminGroup.POST("/users/:username/suspend", requirePermissions(models.PermUserSuspend), suspendUser)
+	adminGroup.POST("/users/:username/unsuspend", requirePermissions(models.PermUserSuspend), unsuspendUser)
+	adminGroup.POST("/users/:username/password/reset", requirePermissions(models.PermUserSession), requirePasswordReset)
+	adminGroup.DELETE("/users/:username/sessions", requirePermissions(models.PermUserSession), revokeSessions)
+	adminGroup.POST("/users/:username/live/end", requirePermissions(models.PermStreamEnd), endLive)
+	adminGroup.POST("/users/:username/unlock", requirePermissions(models.PermUserUnlock), unlockUser)
+	adminGroup.GET("/reports", requirePermissions(models.PermReportModerate), listReports)
+	adminGroup.GET("/reports/:id", requirePermissions(models.PermReportModerate), getReport)
+	adminGroup.POST("/reports/:id/assign", requirePermissions(models.PermReportModerate), assignReport)
+	adminGroup.POST("/reports/:id/resolve", requirePermissions(models.PermReportModerate), resolveReport)
+	adminGroup.GET("/audit", requirePermissions(models.PermAuditRead), listAuditEvents)
+	adminGroup.POST("/users/:username/roles", requirePermissions(models.PermRoleAssign), assignRole)
+	adminGroup.DELETE("/users/:username/roles/:role", requirePermissions(models.PermRoleAssign), revokeRole)
 
 }
 
@@ -99,7 +179,7 @@ func getFollowings(c *gin.Context) {
 
 func getFollows(c *gin.Context, followers bool) {
 	username := c.Param("username")
-	_, err := store.GetUserByUsername(username)
+	user, err := store.GetUserByUsername(c.Request.Context(), username)
 	if err != nil {
 		if errors.Is(err, store.ErrRedisUserNotExists) || errors.Is(err, store.ErrMySQLUserNotExists) {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -116,11 +196,19 @@ func getFollows(c *gin.Context, followers bool) {
 		return
 	}
 
+	if me, _ := getUsername(c); followsHidden(user, me, followers) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "User hides the list.",
+		})
+		return
+	}
+
 	var usernameList []string
 	if followers {
-		usernameList, err = store.GetFollowersFromRedis(username)
+		usernameList, err = store.GetFollowersFromRedis(c.Request.Context(), username)
 	} else {
-		usernameList, err = store.GetFollowingsFromRedis(username)
+		usernameList, err = store.GetFollowingsFromRedis(c.Request.Context(), username)
 	}
 	if err != nil {
 		c.Error(err)
@@ -134,7 +222,7 @@ func getFollows(c *gin.Context, followers bool) {
 	me, _ := getUsername(c)
 	userList := make([]*models.PublicUser, 0)
 	for _, username := range usernameList {
-		user, err := store.GetUserByUsername(username)
+		user, err := store.GetUserByUsername(c.Request.Context(), username)
 		if err != nil {
 			c.Error(err)
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -143,7 +231,7 @@ func getFollows(c *gin.Context, followers bool) {
 			})
 			return
 		}
-		userList = append(userList, store.NewPublicUserFromUser(me, user))
+		userList = append(userList, store.NewPublicUserFromUser(c.Request.Context(), me, user))
 	}
 
 	if followers {
@@ -182,7 +270,7 @@ func followOrNot(c *gin.Context, follow bool) {
 		return
 	}
 
-	_, err := store.GetUserByUsername(dstUsername)
+	_, err := store.GetUserByUsername(c.Request.Context(), dstUsername)
 	if err != nil {
 		if errors.Is(err, store.ErrRedisUserNotExists) || errors.Is(err, store.ErrMySQLUserNotExists) {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -200,9 +288,16 @@ func followOrNot(c *gin.Context, follow bool) {
 	}
 
 	if follow {
-		err = store.FollowUserInRedis(username, dstUsername)
+		err = store.FollowUserInRedis(c.Request.Context(), username, dstUsername)
 	} else {
-		err = store.UnFollowUserInRedis(username, dstUsername)
+		err = store.UnFollowUserInRedis(c.Request.Context(), username, dstUsername)
+	}
+	if errors.Is(err, store.ErrFollowBlocked) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "You can't interact with this user.",
+		})
+		return
 	}
 	if err != nil {
 		c.Error(err)
@@ -225,7 +320,7 @@ func getHistory(c *gin.Context) {
 		return
 	}
 
-	history, err := store.GetWatchHistory(id)
+	history, err := store.GetWatchHistory(c.Request.Context(), id)
 	if err != nil {
 		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -235,18 +330,27 @@ func getHistory(c *gin.Context) {
 		return
 	}
 
+	paused := false
+	if user := currentUser(c); user != nil {
+		paused = user.HistoryPaused
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"code":    http.StatusOK,
 		"history": history,
+		"paused":  paused,
 	})
 }
 
 func getPublicUser(c *gin.Context) {
 	username := c.Param("username")
 
-	user, err := store.GetUserByUsername(username)
+	user, err := store.GetUserByUsername(c.Request.Context(), username)
 	if err != nil {
 		if errors.Is(err, store.ErrRedisUserNotExists) || errors.Is(err, store.ErrMySQLUserNotExists) {
+			if username, ok := usernameRedirect(c, username); ok {
+				c.Redirect(http.StatusFound, "/profile/"+username)
+				return
+			}
 			c.JSON(http.StatusBadRequest, gin.H{
 				"code":    http.StatusBadRequest,
 				"message": "User not exists.",
@@ -261,10 +365,28 @@ func getPublicUser(c *gin.Context) {
 		return
 	}
 
+	// suspended channels and accounts being deleted are hidden as if not exist
+	if user.IsSuspended(time.Now()) || user.IsDeletionPending() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "User not exists.",
+		})
+		return
+	}
+
 	me, _ := getUsername(c)
+	public := store.NewPublicUserFromUser(c.Request.Context(), me, user)
+	// users see nothing of who blocks them
+	if public.Follow == store.Blocked {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "User not exists.",
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"code": http.StatusOK,
-		"user": store.NewPublicUserFromUser(me, user),
+		"user": public,
 	})
 }
 
@@ -284,7 +406,7 @@ func getLivingList(c *gin.Context) {
 		num = 24
 	}
 
-	userList, err := store.GetLivingUserList(num)
+	userList, err := store.GetLivingUserList(c.Request.Context(), num)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    http.StatusInternalServerError,
@@ -294,7 +416,7 @@ func getLivingList(c *gin.Context) {
 	}
 
 	username, _ := getUsername(c)
-	model := store.NewLivingListModelFromUserList(username, userList)
+	model := store.NewLivingListModelFromUserList(c.Request.Context(), username, userList)
 	c.JSON(http.StatusOK, gin.H{
 		"code":  http.StatusOK,
 		"total": model.Total,
@@ -308,7 +430,7 @@ func getMe(c *gin.Context) {
 		return
 	}
 
-	user, err := store.GetUserByID(id)
+	user, err := store.GetUserByID(c.Request.Context(), id)
 	if err != nil {
 		if errors.Is(err, store.ErrRedisUserNotExists) || errors.Is(err, store.ErrMySQLUserNotExists) {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -334,7 +456,7 @@ func getMe(c *gin.Context) {
 func register(c *gin.Context) {
 	user := new(models.RegisterModel)
 	if err := c.ShouldBind(user); err != nil {
-		log.Debug(err)
+		logger(c).Debug(err)
 		c.JSON(http.StatusNotAcceptable, gin.H{
 			"code":    http.StatusNotAcceptable,
 			"message": "invalid felid",
@@ -342,8 +464,11 @@ func register(c *gin.Context) {
 		return
 	}
 
-	log.Debugf("User register <%#v>", user)
-	_, err = store.GetUserByUsername(user.Username)
+	logger(c).Debugw("User register", "user", user)
+	if !checkPlainPassword(c, user.PlainPassword, user.Password) {
+		return
+	}
+	_, err = store.GetUserByUsername(c.Request.Context(), user.Username)
 	if err == nil {
 		c.JSON(http.StatusConflict, gin.H{
 			"code":    http.StatusConflict,
@@ -352,7 +477,7 @@ func register(c *gin.Context) {
 		return
 	}
 	if user.Email != "" {
-		_, err = store.GetUserByEmail(user.Email)
+		_, err = store.GetUserByEmail(c.Request.Context(), user.Email)
 		if err == nil {
 			c.JSON(http.StatusConflict, gin.H{
 				"code":    http.StatusConflict,
@@ -362,7 +487,7 @@ func register(c *gin.Context) {
 		}
 	}
 	if user.Phone != "" {
-		_, err = store.GetUserByPhone(user.Phone)
+		_, err = store.GetUserByPhone(c.Request.Context(), user.Phone)
 		if err == nil {
 			c.JSON(http.StatusConflict, gin.H{
 				"code":    http.StatusConflict,
@@ -379,8 +504,25 @@ func register(c *gin.Context) {
 		})
 		return
 	}
+	// old usernames are kept for their users for a while
+	reserved, err := store.IsUsernameReserved(c.Request.Context(), user.Username)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	if reserved {
+		c.JSON(http.StatusConflict, gin.H{
+			"code":    http.StatusConflict,
+			"message": "Username is reserved.",
+		})
+		return
+	}
 
-	passwordEncrypted, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	passwordEncrypted, err := passwords.Hash(user.Password)
 	if err != nil {
 		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -390,9 +532,8 @@ func register(c *gin.Context) {
 		return
 	}
 
-	user.Password = string(passwordEncrypted)
-	log.Debugf("User register <%#v>", user)
-	err = store.SaveUser(models.NewUserFromRegister(user))
+	user.Password = passwordEncrypted
+	err = store.SaveUser(c.Request.Context(), models.NewUserFromRegister(user))
 	if err != nil {
 		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -411,6 +552,9 @@ func register(c *gin.Context) {
 func getStreamKey(c *gin.Context) {
 	username := c.Param("username")
 	key := getStreamKeyFromLive(c, username)
+	if key != "" {
+		audit(c, models.AuditStreamKeyRead, currentUser(c), nil, nil)
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"code": http.StatusOK,
 		"key":  key,
@@ -418,22 +562,80 @@ func getStreamKey(c *gin.Context) {
 }
 
 func getStreamKeyFromLive(c *gin.Context, username string) string {
-	url := "http://" + os.Getenv("LIVE_ADDR") + "/control/get?room=" + username
-	resp, err := http.Get(url)
+	key, err := controlLive(c.Request.Context(), "get", username)
 	if err != nil {
 		c.Error(err)
-		return ""
 	}
-	resBytes, err := ioutil.ReadAll(resp.Body)
+	return key
+}
+
+// resetStreamKeyFromLive - change user's stream key, the old one can't be used to publish
+func resetStreamKeyFromLive(c *gin.Context, user *models.User) string {
+	key, err := controlLive(c.Request.Context(), "reset", user.Username)
 	if err != nil {
 		c.Error(err)
 		return ""
 	}
-	str := string(resBytes)
-	key := str[strings.LastIndexByte(str, ':')+2 : len(str)-2]
+	audit(c, models.AuditStreamKeyReset, user, nil, nil)
 	return key
 }
 
+// errLiveRoomNotFound - live backend has no room of user, e.g. user never got a stream key
+var errLiveRoomNotFound = errors.New("live room not found")
+
+// deleteStreamKeyFromLive - revoke user's stream key, nothing can be published to the room
+func deleteStreamKeyFromLive(ctx context.Context, username string) error {
+	_, err := controlLive(ctx, "delete", username)
+	if errors.Is(err, errLiveRoomNotFound) {
+		return nil
+	}
+	return err
+}
+
+// controlLive - call live backend's /control/<op> on user's room, return the response data,
+// which is the stream key for get and reset.
+func controlLive(ctx context.Context, op, username string) (string, error) {
+	ctx, span := utils.Tracer.Start(ctx, "live.control."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("live.room", username)),
+	)
+	defer span.End()
+
+	url := "http://" + os.Getenv("LIVE_ADDR") + "/control/" + op + "?room=" + username
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	resBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+	str := string(resBytes)
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errLiveRoomNotFound
+	}
+	start := strings.LastIndexByte(str, ':') + 2
+	if resp.StatusCode != http.StatusOK || start < 2 || start > len(str)-2 {
+		err = errors.New("live control " + op + " failed: " + str)
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+	key := str[start : len(str)-2]
+	return key, nil
+}
+
 func updateUserProfile(c *gin.Context) {
 	id, ok := getUserIDWithError(c)
 	if !ok {
@@ -442,7 +644,7 @@ func updateUserProfile(c *gin.Context) {
 
 	profile := new(models.ChangeProfileModel)
 	if err := c.ShouldBind(profile); err != nil {
-		log.Debug(err)
+		logger(c).Debug(err)
 		c.JSON(http.StatusNotAcceptable, gin.H{
 			"code":    http.StatusNotAcceptable,
 			"message": "invalid felid",
@@ -450,7 +652,8 @@ func updateUserProfile(c *gin.Context) {
 		return
 	}
 
-	err := store.UpdateUserProfile(id, profile)
+	before, after := profileDiff(currentUser(c), profile)
+	err := store.UpdateUserProfile(c.Request.Context(), id, profile)
 	if err != nil {
 		if errors.Is(err, store.ErrRedisUserNotExists) || errors.Is(err, store.ErrMySQLUserNotExists) {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -466,6 +669,7 @@ func updateUserProfile(c *gin.Context) {
 		})
 		return
 	}
+	audit(c, models.AuditProfileUpdate, currentUser(c), before, after)
 
 	c.JSON(http.StatusOK, gin.H{
 		"code":    http.StatusOK,
@@ -481,7 +685,7 @@ func changePassword(c *gin.Context) {
 
 	pass := new(models.ChangePasswordModel)
 	if err := c.ShouldBind(pass); err != nil {
-		log.Debug(err)
+		logger(c).Debug(err)
 		c.JSON(http.StatusNotAcceptable, gin.H{
 			"code":    http.StatusNotAcceptable,
 			"message": "invalid felid",
@@ -489,7 +693,7 @@ func changePassword(c *gin.Context) {
 		return
 	}
 
-	user, err := store.GetUserByID(id)
+	user, err := store.GetUserByID(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"code":    http.StatusBadRequest,
@@ -498,17 +702,15 @@ func changePassword(c *gin.Context) {
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(pass.OldPassword))
-	if err != nil {
-		c.Error(err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"code":    http.StatusBadRequest,
-			"message": "Password is wrong",
-		})
+	// users signed up with OIDC set their first password without an old one
+	if user.HasPassword() && !confirmPassword(c, user, pass.OldPassword) {
+		return
+	}
+	if !checkPlainPassword(c, pass.NewPlainPassword, pass.NewPassword) {
 		return
 	}
 
-	passwordEncrypted, err := bcrypt.GenerateFromPassword([]byte(pass.NewPassword), bcrypt.DefaultCost)
+	passwordEncrypted, err := passwords.Hash(pass.NewPassword)
 	if err != nil {
 		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -518,7 +720,7 @@ func changePassword(c *gin.Context) {
 		return
 	}
 
-	err = store.ChangePassword(user, string(passwordEncrypted))
+	err = store.ChangePassword(c.Request.Context(), user, passwordEncrypted)
 	if err != nil {
 		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -527,12 +729,18 @@ func changePassword(c *gin.Context) {
 		})
 		return
 	}
+	audit(c, models.AuditPasswordChange, user, nil, nil)
 	c.JSON(http.StatusOK, gin.H{
 		"code":    http.StatusOK,
 		"message": "OK",
 	})
 }
 
+// logger - get request-scoped logger carrying request id
+func logger(c *gin.Context) *zap.SugaredLogger {
+	return utils.SugarFrom(c.Request.Context())
+}
+
 func getUserIDWithError(c *gin.Context) (uint, bool) {
 	claims := middleware.ExtractClaims(c)
 	i, exists := claims[authMiddleware.IdentityKey]
@@ -1,11 +1,20 @@
 package api
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"io/ioutil"
+	"math/big"
 	"minitube/models"
 	"minitube/store"
+	"minitube/utils"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -16,6 +25,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	jwtgo "github.com/golang-jwt/jwt/v4"
 	"github.com/stretchr/testify/require"
 )
 
@@ -360,13 +370,13 @@ func TestGetHistory(t *testing.T) {
 	require.NoErrorf(err, "Json Unmarshal Error <%v>", string(body))
 	require.Empty(resp.History, "History should empty")
 
-	store.UpdateWatchHistory(31, "121")
+	store.UpdateWatchHistory(context.Background(), 31, userIDOf(t, "121"))
 	time.Sleep(time.Second)
-	store.UpdateWatchHistory(31, "122")
+	store.UpdateWatchHistory(context.Background(), 31, userIDOf(t, "122"))
 	time.Sleep(time.Second)
-	store.UpdateWatchHistory(31, "123")
+	store.UpdateWatchHistory(context.Background(), 31, userIDOf(t, "123"))
 	time.Sleep(time.Second)
-	store.UpdateWatchHistory(31, "121")
+	store.UpdateWatchHistory(context.Background(), 31, userIDOf(t, "121"))
 
 	body = get(t, "/user/history", tokens[0])
 	err = json.Unmarshal(body, &resp)
@@ -417,6 +427,1482 @@ func TestFollow(t *testing.T) {
 	check("122", 0, 0)
 }
 
+func TestLoginLockout(t *testing.T) {
+	require := require.New(t)
+
+	loginChallenge = &powChallenge{difficulty: 4}
+	admin := adminToken(t)
+
+	login := func(password string, challenge bool) tokenResponse {
+		mp := map[string]string{"username": "125", "password": password}
+		if challenge {
+			var resp struct {
+				baseResponse
+				Challenge  string
+				Difficulty int
+			}
+			body := get(t, "/login/challenge", "")
+			require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+			nonce := 0
+			for !checkProofOfWork(resp.Challenge, strconv.Itoa(nonce), resp.Difficulty) {
+				nonce++
+			}
+			mp["challenge"], mp["nonce"] = resp.Challenge, strconv.Itoa(nonce)
+		}
+		// a spoofed X-Forwarded-For from an untrusted client shouldn't change its ip
+		jsonBytes, err := json.Marshal(mp)
+		require.NoError(err)
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(string(jsonBytes)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		rec := httptest.NewRecorder()
+		Router.ServeHTTP(rec, req)
+		var resp tokenResponse
+		require.NoErrorf(json.Unmarshal(rec.Body.Bytes(), &resp), "Json Unmarshal Error <%v>", rec.Body.String())
+		return resp
+	}
+	wrong := wrongLoginUser[0].Password
+	right := validLoginUser[4].Password
+
+	for i := 0; i < challengeAfter; i++ {
+		require.Equal("incorrect Username or Password", login(wrong, false).Message)
+	}
+	require.Equal(ErrChallengeRequired.Error(), login(right, false).Message, "Challenge should be required.")
+
+	for i := challengeAfter; i < accountLockAfter; i++ {
+		require.Equal("incorrect Username or Password", login(wrong, true).Message)
+	}
+	require.Equal(ErrLoginLocked.Error(), login(right, true).Message, "Account should be locked.")
+
+	var notifications struct {
+		baseResponse
+		Notifications []*models.Notification
+	}
+	body := get(t, "/user/notifications", tokens[4])
+	require.NoErrorf(json.Unmarshal(body, &notifications), "Json Unmarshal Error <%v>", string(body))
+	require.NotEmpty(notifications.Notifications, "Owner should be notified.")
+	require.Equal(models.NotificationAccountLocked, notifications.Notifications[0].Type)
+	require.Contains(notifications.Notifications[0].Message, "192.0.2.1")
+	require.NotContains(notifications.Notifications[0].Message, "203.0.113.9", "Spoofed ip shouldn't be trusted.")
+
+	var resp baseResponse
+	body = postForm(t, "/admin/users/125/unlock", nil, admin)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+
+	require.Equal(http.StatusOK, login(right, false).Code, "Login should success after unlock.")
+}
+
+func TestCSRFCookie(t *testing.T) {
+	require := require.New(t)
+
+	jsonBytes, _ := json.Marshal(mapUser(validLoginUser[3]))
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(string(jsonBytes)))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	Router.ServeHTTP(rec, req)
+
+	cookies := make(map[string]*http.Cookie)
+	for _, cookie := range rec.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	require.Contains(cookies, sessionCookieName, "Login should set session cookie.")
+	require.Contains(cookies, csrfCookieName, "Login should set csrf cookie.")
+	require.False(cookies[csrfCookieName].HttpOnly, "Client should read csrf cookie.")
+
+	post := func(csrf string) int {
+		req := httptest.NewRequest("POST", "/user/unfollow/121", nil)
+		req.AddCookie(cookies[sessionCookieName])
+		if csrf != "" {
+			req.Header.Set("X-CSRF-Token", csrf)
+		}
+		rec := httptest.NewRecorder()
+		Router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	require.Equal(http.StatusForbidden, post(""), "Cookie auth without csrf token should be refused.")
+	require.Equal(http.StatusOK, post(cookies[csrfCookieName].Value))
+}
+
+func TestRBAC(t *testing.T) {
+	require := require.New(t)
+
+	admin := adminToken(t)
+
+	// Plain user has no role and can't access /admin.
+	var me meResponse
+	body := get(t, "/user/me", tokens[2])
+	require.NoErrorf(json.Unmarshal(body, &me), "Json Unmarshal Error <%v>", string(body))
+	require.Empty(me.User.Roles)
+	var resp baseResponse
+	body = postForm(t, "/admin/users/125/unlock", nil, tokens[2])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusForbidden, resp.Code, "User without role can't access admin.")
+
+	// Only who has role:assign can grant roles.
+	body = postForm(t, "/admin/users/123/roles", url.Values{"role": []string{models.RoleModerator}}, tokens[2])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusForbidden, resp.Code)
+	body = postForm(t, "/admin/users/123/roles", url.Values{"role": []string{"superuser"}}, admin)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusBadRequest, "Role not exists."}, resp)
+
+	var roles struct {
+		baseResponse
+		Roles []string
+	}
+	body = postForm(t, "/admin/users/123/roles", url.Values{"role": []string{models.RoleModerator}}, admin)
+	require.NoErrorf(json.Unmarshal(body, &roles), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, roles.Code)
+	require.Equal([]string{models.RoleModerator}, roles.Roles)
+
+	// Roles are carried in token, so they take effect on next login.
+	moderator := loginToken(t, validRegister[2].Username, validRegister[2].Password)
+	body = get(t, "/user/me", moderator)
+	require.NoErrorf(json.Unmarshal(body, &me), "Json Unmarshal Error <%v>", string(body))
+	require.Equal([]string{models.RoleModerator}, me.User.Roles)
+
+	body = postForm(t, "/admin/users/125/unlock", nil, moderator)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code, "Moderator can unlock user.")
+	body = postForm(t, "/admin/log/level", url.Values{"level": []string{"debug"}}, moderator)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusForbidden, resp.Code, "Moderator can't change log level.")
+
+	// Nobody acts on oneself or on who has more permissions.
+	manage := baseResponse{http.StatusForbidden, "You can't manage this user."}
+	body = postForm(t, "/admin/users/121/suspend", url.Values{"reason": []string{"spam"}}, moderator)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(manage, resp, "Moderator can't suspend admin.")
+	body = del(t, "/admin/users/121/sessions", admin)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(manage, resp, "Admin can't revoke own sessions.")
+	body = del(t, "/admin/users/121", admin)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(manage, resp, "Admin can't delete self.")
+
+	body = del(t, "/admin/users/123/roles/"+models.RoleModerator, admin)
+	require.NoErrorf(json.Unmarshal(body, &roles), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, roles.Code)
+	require.Empty(roles.Roles)
+
+	// Role changes revoke issued tokens, so a demoted user can't keep permissions by refreshing.
+	body = postJSON(t, "/refresh", nil, moderator)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusUnauthorized, ErrSessionRevoked.Error()}, resp)
+	tokens[2] = loginToken(t, validRegister[2].Username, validRegister[2].Password)
+}
+
+// adminToken - grant admin role to user 121 and login again, role is revoked after test
+// and tokens[0] is replaced since role changes revoke tokens
+func adminToken(t *testing.T) string {
+	user, err := store.GetUserByUsername(context.Background(), "121")
+	require.NoError(t, err)
+	require.NoError(t, store.AssignRole(context.Background(), user, models.RoleAdmin))
+	// password of 121 has been changed by TestChangePassword
+	password := changePass[0]["new_password"]
+	t.Cleanup(func() {
+		require.NoError(t, store.RevokeRole(context.Background(), user, models.RoleAdmin))
+		tokens[0] = loginToken(t, "121", password)
+	})
+	return loginToken(t, "121", password)
+}
+
+// userIDOf - id of user named username
+func userIDOf(t *testing.T, username string) uint {
+	user, err := store.GetUserByUsername(context.Background(), username)
+	require.NoError(t, err)
+	return user.ID
+}
+
+func loginToken(t *testing.T, username, password string) string {
+	var resp tokenResponse
+	body := postJSON(t, "/login", map[string]string{"username": username, "password": password}, "")
+	require.NoErrorf(t, json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equalf(t, http.StatusOK, resp.Code, "Login %v should success.", username)
+	return resp.Token
+}
+
+func TestAdminUsers(t *testing.T) {
+	require := require.New(t)
+
+	admin := adminToken(t)
+	password := validRegister[3].Password
+
+	type adminUserResponse struct {
+		baseResponse
+		User *models.AdminUser
+	}
+	var user adminUserResponse
+	var resp baseResponse
+
+	// Search and view.
+	var list struct {
+		baseResponse
+		Total int
+		Users []*models.AdminUser
+	}
+	body := get(t, "/admin/users?q=12&size=2", admin)
+	require.NoErrorf(json.Unmarshal(body, &list), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, list.Code)
+	require.GreaterOrEqual(list.Total, len(validRegister))
+	require.Len(list.Users, 2, "Page size should be respected.")
+	body = get(t, "/admin/users/124", admin)
+	require.NoErrorf(json.Unmarshal(body, &user), "Json Unmarshal Error <%v>", string(body))
+	require.Equal("124", user.User.Username)
+	require.NotNil(user.User.Phone, "Admin can see contact info.")
+
+	// Suspend revokes issued tokens and refuses login.
+	token := loginToken(t, "124", password)
+	body = postForm(t, "/admin/users/124/suspend", url.Values{"reason": []string{"spam"}}, admin)
+	require.NoErrorf(json.Unmarshal(body, &user), "Json Unmarshal Error <%v>", string(body))
+	require.True(user.User.Suspended)
+	require.Equal("spam", *user.User.SuspendReason)
+	body = get(t, "/user/me", token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusUnauthorized, ErrSessionRevoked.Error()}, resp)
+	body = postJSON(t, "/login", map[string]string{"username": "124", "password": password}, "")
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(ErrAccountSuspended.Error()+" permanently, reason: spam", resp.Message, "Suspended user can't login.")
+	body = get(t, "/profile/124", "")
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusBadRequest, "User not exists."}, resp, "Suspended channel should be hidden.")
+
+	// Temporary suspension tells user when it ends.
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second).Format(time.RFC3339)
+	body = postForm(t, "/admin/users/124/suspend", url.Values{"reason": []string{"spam"}, "until": []string{until}}, admin)
+	require.NoErrorf(json.Unmarshal(body, &user), "Json Unmarshal Error <%v>", string(body))
+	require.True(user.User.Suspended)
+	body = postJSON(t, "/login", map[string]string{"username": "124", "password": password}, "")
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(ErrAccountSuspended.Error()+" until "+until+", reason: spam", resp.Message)
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	body = postForm(t, "/admin/users/124/suspend", url.Values{"reason": []string{"spam"}, "until": []string{past}}, admin)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusNotAcceptable, resp.Code, "Suspension can't end in the past.")
+
+	body = postForm(t, "/admin/users/124/unsuspend", nil, admin)
+	require.NoErrorf(json.Unmarshal(body, &user), "Json Unmarshal Error <%v>", string(body))
+	require.False(user.User.Suspended)
+	body = get(t, "/profile/124", "")
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+
+	// Revoke sessions.
+	token = loginToken(t, "124", password)
+	body = del(t, "/admin/users/124/sessions", admin)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	body = get(t, "/user/me", token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusUnauthorized, resp.Code, "Token should be revoked.")
+
+	// Forced password reset only allows changing password.
+	body = postForm(t, "/admin/users/124/password/reset", nil, admin)
+	require.NoErrorf(json.Unmarshal(body, &user), "Json Unmarshal Error <%v>", string(body))
+	require.True(user.User.PasswordResetRequired)
+	token = loginToken(t, "124", password)
+	body = get(t, "/user/history", token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusForbidden, resp.Code, "Password reset should be required.")
+	body = postJSON(t, "/user/password", map[string]string{"old_password": password, "new_password": password}, token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	body = get(t, "/user/history", token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code, "Password has been reset.")
+
+	// End live changes stream key.
+	var key keyResponse
+	body = get(t, "/stream/key/124", token)
+	require.NoErrorf(json.Unmarshal(body, &key), "Json Unmarshal Error <%v>", string(body))
+	oldKey := key.Key
+	body = postForm(t, "/admin/users/124/live/end", nil, admin)
+	require.NoErrorf(json.Unmarshal(body, &user), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, user.Code)
+	require.False(user.User.Living)
+	body = get(t, "/stream/key/124", token)
+	require.NoErrorf(json.Unmarshal(body, &key), "Json Unmarshal Error <%v>", string(body))
+	require.NotEqual(oldKey, key.Key, "Old stream key should be revoked.")
+
+	// Delete.
+	body = postJSON(t, "/register", map[string]string{"username": "126", "password": password, "email": "126@minitube.com"}, "")
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	token = loginToken(t, "126", password)
+	body = del(t, "/admin/users/126", tokens[2])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusForbidden, resp.Code, "Only admin can delete user.")
+	body = del(t, "/admin/users/126", admin)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	_, err := store.GetUserByEmail(context.Background(), "126@minitube.com")
+	require.ErrorIs(err, store.ErrMySQLUserNotExists, "Email index should be removed.")
+	body = get(t, "/user/me", token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusUnauthorized, resp.Code, "Deleted user's token should be invalid.")
+
+	// sessions of 124 have been revoked
+	tokens[3] = loginToken(t, "124", password)
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	require := require.New(t)
+	defer utils.Level.SetLevel(utils.Level.Level())
+
+	type levelResponse struct {
+		baseResponse
+		Level string
+	}
+
+	// Not admin, should be forbidden.
+	var resp levelResponse
+	body := get(t, "/admin/log/level", tokens[0])
+	err := json.Unmarshal(body, &resp)
+	require.NoErrorf(err, "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusForbidden, resp.Code, "Only admin can get log level.")
+
+	admin := adminToken(t)
+
+	resp = levelResponse{}
+	body = postForm(t, "/admin/log/level", url.Values{"level": []string{"warn"}}, admin)
+	err = json.Unmarshal(body, &resp)
+	require.NoErrorf(err, "Json Unmarshal Error <%v>", string(body))
+	require.Equal(levelResponse{baseResponse{Code: http.StatusOK}, "warn"}, resp)
+
+	resp = levelResponse{}
+	body = postForm(t, "/admin/log/level", url.Values{"level": []string{"verbose"}}, admin)
+	err = json.Unmarshal(body, &resp)
+	require.NoErrorf(err, "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusNotAcceptable, resp.Code, "Unknown level should be rejected.")
+}
+
+func TestReports(t *testing.T) {
+	require := require.New(t)
+
+	admin := adminToken(t)
+
+	type reportResponse struct {
+		baseResponse
+		Report *models.Report
+	}
+	var created struct {
+		baseResponse
+		ReportID uint `json:"report_id"`
+	}
+	var report reportResponse
+	var resp baseResponse
+
+	postReport := func(mp map[string]string) {
+		created.ReportID = 0
+		body := postJSON(t, "/report", mp, tokens[2])
+		require.NoErrorf(json.Unmarshal(body, &created), "Json Unmarshal Error <%v>", string(body))
+	}
+
+	postReport(map[string]string{"target_type": "chat", "target": "125", "category": "spam"})
+	require.Equal(http.StatusNotAcceptable, created.Code, "Chat report needs message id.")
+	postReport(map[string]string{"target_type": "user", "target": "123", "category": "spam"})
+	require.Equal(baseResponse{http.StatusBadRequest, "Can't report yourself."}, created.baseResponse)
+	postReport(map[string]string{"target_type": "user", "target": "nobody", "category": "spam"})
+	require.Equal(baseResponse{http.StatusBadRequest, "User not exists."}, created.baseResponse)
+
+	// Reports are deduplicated per reporter and target.
+	postReport(map[string]string{"target_type": "user", "target": "125", "category": "spam", "detail": "ads"})
+	require.Equal(baseResponse{http.StatusOK, "OK"}, created.baseResponse)
+	userReport := created.ReportID
+	postReport(map[string]string{"target_type": "user", "target": "125", "category": "harassment"})
+	require.Equal(baseResponse{http.StatusOK, "Already reported."}, created.baseResponse)
+	require.Equal(userReport, created.ReportID)
+	postReport(map[string]string{"target_type": "chat", "target": "125", "message_id": "m1", "category": "hate"})
+	require.Equal(baseResponse{http.StatusOK, "OK"}, created.baseResponse)
+	chatReport := created.ReportID
+	require.NotEqual(userReport, chatReport)
+
+	// Queue.
+	body := get(t, "/admin/reports?status=open", tokens[2])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusForbidden, resp.Code, "Only moderators can see reports.")
+	var list struct {
+		baseResponse
+		Total   int
+		Reports []*models.Report
+	}
+	body = get(t, "/admin/reports?status=open&target=125", admin)
+	require.NoErrorf(json.Unmarshal(body, &list), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(2, list.Total)
+	require.Equal("ads", list.Reports[0].Detail)
+
+	id := strconv.Itoa(int(userReport))
+	body = postForm(t, "/admin/reports/"+id+"/assign", url.Values{"assignee": []string{"122"}}, admin)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusBadRequest, "Assignee can't moderate reports."}, resp)
+	body = postForm(t, "/admin/reports/"+id+"/assign", nil, admin)
+	require.NoErrorf(json.Unmarshal(body, &report), "Json Unmarshal Error <%v>", string(body))
+	require.NotNil(report.Report.AssigneeID)
+	body = get(t, "/admin/reports?assignee=me", admin)
+	require.NoErrorf(json.Unmarshal(body, &list), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(1, list.Total)
+	require.Equal(userReport, list.Reports[0].ID)
+
+	// Resolve.
+	chat := strconv.Itoa(int(chatReport))
+	body = postForm(t, "/admin/reports/"+chat+"/resolve", url.Values{"status": []string{"dismissed"}}, admin)
+	require.NoErrorf(json.Unmarshal(body, &report), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(models.ReportDismissed, report.Report.Status)
+	body = postForm(t, "/admin/reports/"+chat+"/resolve", url.Values{"status": []string{"actioned"}}, admin)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusBadRequest, "Report has been resolved."}, resp)
+
+	body = postForm(t, "/admin/reports/"+id+"/resolve", url.Values{
+		"status": []string{"actioned"}, "suspend": []string{"true"}, "reason": []string{"spam"}}, admin)
+	require.NoErrorf(json.Unmarshal(body, &report), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(models.ReportActioned, report.Report.Status)
+	require.NotNil(report.Report.SuspendedAt, "Report should link to the suspension.")
+	body = postJSON(t, "/login", map[string]string{"username": "125", "password": validRegister[4].Password}, "")
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Contains(resp.Message, ErrAccountSuspended.Error())
+	postForm(t, "/admin/users/125/unsuspend", nil, admin)
+	tokens[4] = loginToken(t, "125", validRegister[4].Password)
+
+	// Target can be reported again once the report is resolved.
+	postReport(map[string]string{"target_type": "user", "target": "125", "category": "spam"})
+	require.Equal(baseResponse{http.StatusOK, "OK"}, created.baseResponse)
+	require.NotEqual(userReport, created.ReportID)
+}
+
+func TestAuditLog(t *testing.T) {
+	require := require.New(t)
+
+	admin := adminToken(t)
+
+	// Users see their own security events only.
+	get(t, "/stream/key/123", tokens[2])
+	var security struct {
+		baseResponse
+		Total  int
+		Events []*models.SecurityEvent
+	}
+	body := get(t, "/user/security-log", tokens[2])
+	require.NoErrorf(json.Unmarshal(body, &security), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, security.Code)
+	actions := make(map[string]bool)
+	for _, event := range security.Events {
+		actions[event.Action] = true
+		require.Equal("192.0.2.1", event.IP)
+	}
+	require.True(actions[models.AuditLoginSuccess], "Login should be audited.")
+	require.True(actions[models.AuditStreamKeyRead], "Stream key read should be audited.")
+	require.True(actions[models.AuditProfileUpdate], "Profile update should be audited.")
+	require.False(actions[models.AuditAdminRoleAssign], "Admin's events aren't shown to user.")
+	require.Equal(models.AuditStreamKeyRead, security.Events[0].Action, "Newest event first.")
+
+	// Admins filter all events.
+	body = get(t, "/admin/audit", tokens[2])
+	var resp baseResponse
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusForbidden, resp.Code, "Only admin can read audit log.")
+
+	var audit struct {
+		baseResponse
+		Total  int
+		Events []map[string]interface{}
+	}
+	body = get(t, "/admin/audit?action=admin.role&target=123", admin)
+	require.NoErrorf(json.Unmarshal(body, &audit), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(2, audit.Total, "Role assign and revoke should be audited.")
+	require.Equal(models.AuditAdminRoleRevoke, audit.Events[0]["action"])
+	require.Equal("121", audit.Events[0]["actor_username"])
+	require.Equal(map[string]interface{}{"role": models.RoleModerator}, audit.Events[0]["after"])
+
+	body = get(t, "/admin/audit?action=login.failure&target=125", admin)
+	require.NoErrorf(json.Unmarshal(body, &audit), "Json Unmarshal Error <%v>", string(body))
+	require.GreaterOrEqual(audit.Total, accountLockAfter, "Failed logins should be audited.")
+	require.Nil(audit.Events[0]["actor_id"], "Failed login has no actor.")
+
+	body = get(t, "/admin/audit?action=profile.update&actor=123", admin)
+	require.NoErrorf(json.Unmarshal(body, &audit), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(1, audit.Total)
+	require.NotEmpty(audit.Events[0]["after"], "Profile diff should be recorded.")
+
+	from := time.Now().Add(time.Hour).Format(time.RFC3339)
+	body = get(t, "/admin/audit?from="+url.QueryEscape(from), admin)
+	require.NoErrorf(json.Unmarshal(body, &audit), "Json Unmarshal Error <%v>", string(body))
+	require.Zero(audit.Total, "No event in the future.")
+}
+
+func TestDeleteAccount(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	password := validRegister[3].Password
+	body := postJSON(t, "/register", map[string]string{"username": "127", "password": password, "email": "127@minitube.com"}, "")
+	var resp baseResponse
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	token := loginToken(t, "127", password)
+	user, err := store.GetUserByUsername(ctx, "127")
+	require.NoError(err)
+
+	// Leave something everywhere.
+	body = postForm(t, "/user/follow/127", nil, tokens[4])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	body = postForm(t, "/user/follow/125", nil, token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	require.NoError(store.UpdateWatchHistory(ctx, user.ID, userIDOf(t, "125")))
+	var key keyResponse
+	body = get(t, "/stream/key/127", token)
+	require.NoErrorf(json.Unmarshal(body, &key), "Json Unmarshal Error <%v>", string(body))
+	require.NotEmpty(key.Key)
+
+	// Password must be confirmed.
+	body = delJSON(t, "/user/me", map[string]string{"password": validRegister[4].Password}, token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusBadRequest, "Password is wrong"}, resp)
+
+	// Deletion revokes sessions and hides the account, login in grace period restores it.
+	body = delJSON(t, "/user/me", map[string]string{"password": password}, token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	body = get(t, "/user/me", token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusUnauthorized, ErrSessionRevoked.Error()}, resp)
+	body = get(t, "/profile/127", "")
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusBadRequest, "User not exists."}, resp, "Account being deleted should be hidden.")
+	n, err := purgeDeletedAccounts(ctx, time.Now())
+	require.NoError(err)
+	require.Zero(n, "Account shouldn't be purged in grace period.")
+
+	token = loginToken(t, "127", password)
+	user, err = store.GetUserByUsername(ctx, "127")
+	require.NoError(err)
+	require.False(user.IsDeletionPending(), "Login should cancel deletion.")
+
+	// Purge after grace period.
+	body = delJSON(t, "/user/me", map[string]string{"password": password}, token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	n, err = purgeDeletedAccounts(ctx, time.Now().Add(accountDeletionGrace+time.Minute))
+	require.NoError(err)
+	require.Equal(1, n)
+
+	_, err = store.GetUserByID(ctx, user.ID)
+	require.ErrorIs(err, store.ErrMySQLUserNotExists)
+	client := store.NewRedisClient()
+	defer client.Close()
+	n64, err := client.Exists(ctx,
+		"user:id:"+strconv.Itoa(int(user.ID)), "user:username:127", "user:email:127@minitube.com",
+		"user:history:"+strconv.Itoa(int(user.ID)), "user:notification:"+strconv.Itoa(int(user.ID)),
+		"user:follower:127", "user:following:127", "living:127", "watching:127",
+	).Result()
+	require.NoError(err)
+	require.Zero(n64, "No redis key of deleted user should remain.")
+	// live backend keeps room -> key and key -> room in the same redis
+	n64, err = client.Exists(ctx, key.Key).Result()
+	require.NoError(err)
+	require.Zero(n64, "Stream key should be revoked.")
+
+	followings, err := store.GetFollowingsFromRedis(ctx, "125")
+	require.NoError(err)
+	require.NotContains(followings, "127")
+	followers, err := store.GetFollowersFromRedis(ctx, "125")
+	require.NoError(err)
+	require.NotContains(followers, "127")
+}
+
+func TestExport(t *testing.T) {
+	require := require.New(t)
+
+	type exportResponse struct {
+		baseResponse
+		Export          *models.Export
+		DownloadURL     string    `json:"download_url"`
+		DownloadExpires time.Time `json:"download_expires"`
+	}
+	var export exportResponse
+	body := postForm(t, "/user/export", nil, tokens[4])
+	require.NoErrorf(json.Unmarshal(body, &export), "Json Unmarshal Error <%v>", string(body))
+	require.Contains([]int{http.StatusAccepted, http.StatusOK}, export.Code)
+	id := export.Export.ID
+
+	var resp baseResponse
+	body = get(t, "/user/export/"+id, tokens[0])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusNotFound, "Export not exists."}, resp, "Others' export should be hidden.")
+
+	// Poll until done.
+	for i := 0; i < 50; i++ {
+		body = get(t, "/user/export/"+id, tokens[4])
+		require.NoErrorf(json.Unmarshal(body, &export), "Json Unmarshal Error <%v>", string(body))
+		require.Equal(http.StatusOK, export.Code)
+		if !export.Export.InProgress() {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Equal(models.ExportDone, export.Export.Status)
+	require.NotEmpty(export.DownloadURL)
+	require.True(export.DownloadExpires.Before(time.Now().Add(exportLinkTTL + time.Minute)))
+
+	// The signed link needs no session.
+	rec := httptest.NewRecorder()
+	Router.ServeHTTP(rec, httptest.NewRequest("GET", export.DownloadURL, nil))
+	require.Equal(http.StatusOK, rec.Code)
+	require.Equal("application/zip", rec.Header().Get("Content-Type"))
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	require.NoError(err)
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		require.NoError(err)
+		files[f.Name], err = ioutil.ReadAll(r)
+		require.NoError(err)
+		r.Close()
+	}
+	for _, name := range []string{"profile.json", "room.json", "followers.json", "followings.json", "history.json", "watch_sessions.json", "broadcasts.json", "audit.json"} {
+		require.Contains(files, name)
+	}
+	var me models.Me
+	require.NoError(json.Unmarshal(files["profile.json"], &me))
+	require.Equal("125", me.Username)
+	var events []*models.SecurityEvent
+	require.NoError(json.Unmarshal(files["audit.json"], &events))
+	require.NotEmpty(events, "Security log should be exported.")
+
+	rec = httptest.NewRecorder()
+	Router.ServeHTTP(rec, httptest.NewRequest("GET", strings.Replace(export.DownloadURL, "signature=", "signature=0", 1), nil))
+	require.Equal(http.StatusForbidden, rec.Code, "Tampered link should be refused.")
+}
+
+func TestChangeUsername(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	password := validRegister[3].Password
+	body := postJSON(t, "/register", map[string]string{"username": "128", "password": password}, "")
+	var resp baseResponse
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	token := loginToken(t, "128", password)
+	fan, err := store.GetUserByUsername(ctx, "125")
+	require.NoError(err)
+
+	body = postForm(t, "/user/follow/128", nil, tokens[4])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	body = postForm(t, "/user/follow/122", nil, token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	require.NoError(store.UpdateWatchHistory(ctx, fan.ID, userIDOf(t, "128")))
+	require.NoError(store.BlockUserInRedis(ctx, "128", "123"))
+	require.NoError(store.BlockUserInRedis(ctx, "124", "128"))
+
+	// Password must be confirmed, and the name must be free.
+	body = postJSON(t, "/user/username", map[string]string{"username": "r128", "password": validRegister[4].Password}, token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusBadRequest, "Password is wrong"}, resp)
+	body = postJSON(t, "/user/username", map[string]string{"username": "122", "password": password}, token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusConflict, "username already exists"}, resp)
+
+	var tr tokenResponse
+	body = postJSON(t, "/user/username", map[string]string{"username": "r128", "password": password}, token)
+	require.NoErrorf(json.Unmarshal(body, &tr), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, tr.Code)
+	body = get(t, "/user/me", token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusUnauthorized, resp.Code, "Tokens with old username should be revoked.")
+	var me meResponse
+	body = get(t, "/user/me", tr.Token)
+	require.NoErrorf(json.Unmarshal(body, &me), "Json Unmarshal Error <%v>", string(body))
+	require.Equal("r128", me.User.Username)
+	defer func() {
+		user, err := store.GetUserByUsername(ctx, "r128")
+		require.NoError(err)
+		require.NoError(store.DeleteUser(ctx, user))
+	}()
+
+	// Follows and history are migrated.
+	followings, err := store.GetFollowingsFromRedis(ctx, "125")
+	require.NoError(err)
+	require.Contains(followings, "r128")
+	require.NotContains(followings, "128")
+	followers, err := store.GetFollowersFromRedis(ctx, "r128")
+	require.NoError(err)
+	require.Contains(followers, "125")
+	followers, err = store.GetFollowersFromRedis(ctx, "122")
+	require.NoError(err)
+	require.Contains(followers, "r128")
+	require.NotContains(followers, "128")
+	history, err := store.GetWatchHistory(ctx, fan.ID)
+	require.NoError(err)
+	watched := make([]string, len(history))
+	for i, h := range history {
+		watched[i] = h.Username
+	}
+	require.Contains(watched, "r128")
+	require.NotContains(watched, "128")
+	status, err := store.GetFollowStatusFromRedis(ctx, "r128", "123")
+	require.NoError(err)
+	require.Equal(store.Blocking, status, "Blocks are migrated.")
+	status, err = store.GetFollowStatusFromRedis(ctx, "124", "r128")
+	require.NoError(err)
+	require.Equal(store.Blocking, status)
+
+	// Old name redirects and is reserved.
+	for _, uri := range []string{"/profile/", "/live/"} {
+		rec := httptest.NewRecorder()
+		Router.ServeHTTP(rec, httptest.NewRequest("GET", uri+"128", nil))
+		require.Equal(http.StatusFound, rec.Code)
+		require.Equal(uri+"r128", rec.Header().Get("Location"))
+	}
+	body = postJSON(t, "/register", map[string]string{"username": "128", "password": password}, "")
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusConflict, "Username is reserved."}, resp)
+	body = postJSON(t, "/user/username", map[string]string{"username": "128", "password": password}, loginToken(t, "124", password))
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusConflict, "Username is reserved."}, resp)
+
+	// Cooldown.
+	body = postJSON(t, "/user/username", map[string]string{"username": "128", "password": password}, tr.Token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusTooManyRequests, resp.Code)
+}
+
+func TestRehashPassword(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	password := validRegister[4].Password
+	body := postJSON(t, "/register", map[string]string{"username": "129", "password": password}, "")
+	var resp baseResponse
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	user, err := store.GetUserByUsername(ctx, "129")
+	require.NoError(err)
+	defer func() {
+		require.NoError(store.DeleteUser(ctx, user))
+	}()
+
+	// A hash made with outdated parameters is upgraded on login.
+	old, err := (&utils.BcryptHasher{Cost: 4}).Hash(password)
+	require.NoError(err)
+	require.NoError(store.UpdatePasswordHash(ctx, user, old))
+	require.True(passwords.NeedsRehash(user.Password))
+
+	loginToken(t, "129", password)
+	user, err = store.GetUserByUsername(ctx, "129")
+	require.NoError(err)
+	require.NotEqual(old, user.Password)
+	require.False(passwords.NeedsRehash(user.Password), "Password should be rehashed on login.")
+	loginToken(t, "129", password)
+}
+
+func TestPlainPassword(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	blocklist, err := utils.ReadPasswordBlocklist(strings.NewReader(
+		"minitube123\n" + "A94A8FE5CCB19BA61C4C0873D391E987982FBBD3:42\n")) // sha1 of "test"
+	require.NoError(err)
+	saved := passwordBlocklist
+	passwordBlocklist = blocklist
+	defer func() { passwordBlocklist = saved }()
+
+	type errorResponse struct {
+		Code    int
+		Message string
+		Error   string
+	}
+	prehash := func(plain string) string {
+		sum := sha256.Sum256([]byte(plain))
+		return hex.EncodeToString(sum[:])
+	}
+	register := func(uri, password, plain string) errorResponse {
+		var resp errorResponse
+		body := postJSON(t, uri, map[string]string{"username": "130", "password": password, "plain_password": plain}, "")
+		require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+		return resp
+	}
+
+	// Plain passwords are checked over https only, a forged X-Forwarded-Proto doesn't count.
+	resp := register("/register", prehash("minitube123"), "minitube123")
+	require.Equal(http.StatusBadRequest, resp.Code)
+	require.Equal(errCodePasswordInsecure, resp.Error)
+	req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(url.Values{
+		"username": {"130"}, "password": {prehash("minitube123")}, "plain_password": {"minitube123"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rec := httptest.NewRecorder()
+	Router.ServeHTTP(rec, req)
+	require.NoErrorf(json.Unmarshal(rec.Body.Bytes(), &resp), "Json Unmarshal Error <%v>", rec.Body.String())
+	require.Equal(errCodePasswordInsecure, resp.Error, "X-Forwarded-Proto is only believed from trusted proxies.")
+
+	// Plain password must be what password is hashed from.
+	resp = register("https://minitube/register", validRegister[4].Password, "correct horse battery staple")
+	require.Equal(http.StatusBadRequest, resp.Code)
+	require.Equal(errCodePasswordMismatch, resp.Error)
+	resp = register("https://minitube/register", prehash("minitube123"), "minitube123")
+	require.Equal(http.StatusBadRequest, resp.Code)
+	require.Equal(errCodePasswordBreached, resp.Error)
+	resp = register("https://minitube/register", prehash("test"), "test")
+	require.Equal(errCodePasswordWeak, resp.Error)
+	password := prehash("correct horse battery staple")
+	resp = register("https://minitube/register", password, "correct horse battery staple")
+	require.Equal(http.StatusOK, resp.Code, resp.Message)
+	user, err := store.GetUserByUsername(ctx, "130")
+	require.NoError(err)
+	defer func() {
+		require.NoError(store.DeleteUser(ctx, user))
+	}()
+
+	token := loginToken(t, "130", password)
+	body := postJSON(t, "https://minitube/user/password", map[string]string{
+		"old_password": password, "new_password": prehash("minitube123"), "new_plain_password": "minitube123"}, token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(errCodePasswordBreached, resp.Error)
+	loginToken(t, "130", password)
+
+	// Clients can check passwords by range without sending them.
+	var suffixes struct {
+		Code     int
+		Suffixes []string
+	}
+	body = get(t, "/password/range/a94a8", "")
+	require.NoErrorf(json.Unmarshal(body, &suffixes), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, suffixes.Code)
+	require.Contains(suffixes.Suffixes, "FE5CCB19BA61C4C0873D391E987982FBBD3")
+	body = get(t, "/password/range/a94a", "")
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusBadRequest, resp.Code)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	// A local provider signing in "alice", it checks the PKCE verifier against
+	// the challenge of the last authorization request.
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+	var issuer, challenge, nonce string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": issuer + "/authorize",
+			"token_endpoint":         issuer + "/token",
+			"jwks_uri":               issuer + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if utils.PKCEChallenge(r.FormValue("code_verifier")) != challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		token := jwtgo.NewWithClaims(jwtgo.SigningMethodRS256, jwtgo.MapClaims{
+			"iss": issuer, "aud": "minitube", "sub": "alice-subject", "nonce": nonce,
+			"exp": time.Now().Add(time.Minute).Unix(), "preferred_username": "alice",
+		})
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(key)
+		require.NoError(err)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	provider := httptest.NewServer(mux)
+	defer provider.Close()
+	issuer = provider.URL
+	oidcProviders["fake"] = &utils.OIDCProvider{Name: "fake", Issuer: issuer, ClientID: "minitube",
+		RedirectURL: "http://minitube/oauth/fake/callback"}
+	defer delete(oidcProviders, "fake")
+
+	serve := func(uri string, cookies ...*http.Cookie) *http.Response {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", uri, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		Router.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+	start := func() (string, *http.Cookie) {
+		resp := serve("/oauth/fake/login")
+		require.Equal(http.StatusFound, resp.StatusCode)
+		location, err := url.Parse(resp.Header.Get("Location"))
+		require.NoError(err)
+		require.Equal("/authorize", location.Path)
+		require.Equal("S256", location.Query().Get("code_challenge_method"))
+		challenge, nonce = location.Query().Get("code_challenge"), location.Query().Get("nonce")
+		var cookie *http.Cookie
+		for _, c := range resp.Cookies() {
+			if c.Name == oidcStateCookieName {
+				cookie = c
+			}
+		}
+		require.NotNil(cookie)
+		return location.Query().Get("state"), cookie
+	}
+	callback := func(state string, cookie *http.Cookie) (int, tokenResponse) {
+		resp := serve("/oauth/fake/callback?code=code&state="+state, cookie)
+		defer resp.Body.Close()
+		var tr tokenResponse
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(err)
+		require.NoErrorf(json.Unmarshal(body, &tr), "Json Unmarshal Error <%v>", string(body))
+		return resp.StatusCode, tr
+	}
+
+	// The first sign in creates a user.
+	state, cookie := start()
+	code, tr := callback(state, cookie)
+	require.Equal(http.StatusOK, code)
+	var me meResponse
+	body := get(t, "/user/me", tr.Token)
+	require.NoErrorf(json.Unmarshal(body, &me), "Json Unmarshal Error <%v>", string(body))
+	require.True(strings.HasPrefix(me.User.Username, "alice"), me.User.Username)
+	user, err := store.GetUserByIdentity(ctx, "fake", "alice-subject")
+	require.NoError(err)
+	defer func() {
+		require.NoError(store.DeleteUser(ctx, user))
+	}()
+	require.Equal(me.User.Username, user.Username)
+
+	// The state can't be replayed, nor used by another browser.
+	code, _ = callback(state, cookie)
+	require.Equal(http.StatusBadRequest, code)
+	state, _ = start()
+	code, _ = callback(state, &http.Cookie{Name: oidcStateCookieName, Value: "attacker"})
+	require.Equal(http.StatusBadRequest, code)
+
+	// The next sign in is the same user.
+	state, cookie = start()
+	code, tr = callback(state, cookie)
+	require.Equal(http.StatusOK, code)
+	body = get(t, "/user/me", tr.Token)
+	require.NoErrorf(json.Unmarshal(body, &me), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(user.Username, me.User.Username)
+
+	// OIDC users have no password, they set one without an old one, then confirm with it.
+	var base baseResponse
+	password := validRegister[0].Password
+	body = postJSON(t, "/user/username", map[string]string{"username": "alice0", "password": password}, tr.Token)
+	require.NoErrorf(json.Unmarshal(body, &base), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusBadRequest, "Set a password first."}, base)
+	body = postJSON(t, "/login", map[string]string{"username": user.Username, "password": password}, "")
+	require.NoErrorf(json.Unmarshal(body, &base), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusUnauthorized, base.Code, "OIDC user can't login with password before setting one.")
+	body = postJSON(t, "/user/password", map[string]string{"new_password": password}, tr.Token)
+	require.NoErrorf(json.Unmarshal(body, &base), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, base.Code, base.Message)
+	loginToken(t, user.Username, password)
+	body = postJSON(t, "/user/password", map[string]string{"new_password": validRegister[1].Password}, tr.Token)
+	require.NoErrorf(json.Unmarshal(body, &base), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(baseResponse{http.StatusBadRequest, "Password is wrong"}, base, "Old password is needed once set.")
+
+	resp := serve("/oauth/none/login")
+	require.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAPITokens(t *testing.T) {
+	require := require.New(t)
+
+	type createResponse struct {
+		Code  int
+		Token string
+		Info  models.APITokenInfo
+	}
+	form := url.Values{"name": {"bot"}, "scopes": {models.ScopeProfileWrite, models.ScopeFollowersRead}, "expires_in_days": {"30"}}
+	var created createResponse
+	body := postForm(t, "/user/tokens", form, tokens[0])
+	require.NoErrorf(json.Unmarshal(body, &created), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, created.Code)
+	require.True(strings.HasPrefix(created.Token, apiTokenPrefix))
+	require.Equal([]string{models.ScopeProfileWrite, models.ScopeFollowersRead}, created.Info.Scopes)
+	require.NotNil(created.Info.ExpiresAt)
+
+	var resp baseResponse
+	body = postForm(t, "/user/tokens", url.Values{"name": {"bad"}, "scopes": {"admin"}}, tokens[0])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusNotAcceptable, resp.Code, "Unknown scopes can't be granted.")
+
+	// Token works on routes of its scopes only.
+	body = get(t, "/user/me", created.Token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	body = get(t, "/user/followers", created.Token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	body = get(t, "/stream/key/121", created.Token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusForbidden, resp.Code)
+	body = get(t, "/user/tokens", created.Token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusForbidden, resp.Code, "API tokens can't manage API tokens.")
+	// Read scope can't write.
+	form = url.Values{"name": {"reader"}, "scopes": {models.ScopeProfileRead}}
+	var reader createResponse
+	body = postForm(t, "/user/tokens", form, tokens[0])
+	require.NoErrorf(json.Unmarshal(body, &reader), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, reader.Code)
+	body = get(t, "/user/me", reader.Token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	body = postForm(t, "/user/profile", url.Values{"description": {"bot"}}, reader.Token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusForbidden, resp.Code)
+	body = del(t, "/user/tokens/"+strconv.FormatUint(uint64(reader.Info.ID), 10), tokens[0])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	body = get(t, "/user/me", apiTokenPrefix+"unknown")
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusUnauthorized, resp.Code)
+
+	var list struct {
+		Code   int
+		Tokens []models.APITokenInfo
+	}
+	body = get(t, "/user/tokens", tokens[0])
+	require.NoErrorf(json.Unmarshal(body, &list), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, list.Code)
+	require.Len(list.Tokens, 1)
+	require.NotNil(list.Tokens[0].LastUsedAt, "Last use should be recorded.")
+
+	id := strconv.FormatUint(uint64(created.Info.ID), 10)
+	body = del(t, "/user/tokens/"+id, tokens[1])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusNotFound, resp.Code, "Others' tokens can't be revoked.")
+	body = del(t, "/user/tokens/"+id, tokens[0])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	body = get(t, "/user/me", created.Token)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusUnauthorized, resp.Code, "Revoked token shouldn't work.")
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	var resp baseResponse
+	body := get(t, "/.well-known/jwks.json", "")
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusNotFound, resp.Code, "No key set with JWT_SECRET_KEY.")
+
+	saved, savedKEK := jwtSigningAlgorithm, jwtKeyEncryptionKey
+	jwtSigningAlgorithm, jwtKeyEncryptionKey = utils.AlgEdDSA, make([]byte, 32)
+	defer func() {
+		jwtSigningAlgorithm, jwtKeyEncryptionKey = saved, savedKEK
+		authMiddleware.KeyFunc, authMiddleware.SigningKeyFunc = nil, nil
+		_, err := store.DeleteRetiredJWTKeys(ctx, time.Now().Add(100*365*24*time.Hour))
+		require.NoError(err)
+	}()
+	now := time.Now()
+	require.NoError(rotateJWTKeys(ctx, now))
+	authMiddleware.KeyFunc, authMiddleware.SigningKeyFunc = jwtKeyFunc, jwtSigningKey
+
+	first, err := jwtKeys.Signing(now)
+	require.NoError(err)
+	require.Equal(utils.AlgEdDSA, first.Algorithm)
+	rows, err := store.GetJWTKeys(ctx, now)
+	require.NoError(err)
+	require.NotContains(rows[0].PrivateKey, "PRIVATE KEY", "Private keys should be encrypted in store.")
+	old := loginToken(t, "121", validRegister[0].Password)
+	body = get(t, "/user/me", old)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+
+	// The next key is published ahead, and the old one keeps verifying.
+	later := now.Add(jwtKeyRotation)
+	require.NoError(rotateJWTKeys(ctx, later))
+	keys := jwtKeys.Keys()
+	require.Len(keys, 2)
+	require.Equal(later.Add(jwtKeyLead).Unix(), keys[0].NotBefore.Unix())
+	require.Equal(later.Add(jwtKeyLead+tokenMaxRefresh).Unix(), keys[1].NotAfter.Unix())
+	// Another instance rotating with keys loaded before doesn't retire the new key.
+	stale := &models.JWTKey{ID: "stale", Algorithm: utils.AlgEdDSA, PrivateKey: "stale", NotBefore: later}
+	rotated, err := store.RotateJWTKey(ctx, func(newest *models.JWTKey) bool {
+		return newest == nil || jwtKeyDue(newest.Algorithm, newest.NotBefore, later)
+	}, stale, later)
+	require.NoError(err)
+	require.False(rotated, "Rotation should be checked again under the lock.")
+	rows, err = store.GetJWTKeys(ctx, later)
+	require.NoError(err)
+	require.Len(rows, 2)
+	body = get(t, "/user/me", old)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+
+	var jwks struct {
+		Keys []struct {
+			Kid string
+			Kty string
+			Alg string
+		}
+	}
+	body = get(t, "/.well-known/jwks.json", "")
+	require.NoErrorf(json.Unmarshal(body, &jwks), "Json Unmarshal Error <%v>", string(body))
+	require.Len(jwks.Keys, 2)
+	require.Equal(keys[0].ID, jwks.Keys[0].Kid)
+	require.Equal("OKP", jwks.Keys[0].Kty)
+	require.Equal(utils.AlgEdDSA, jwks.Keys[1].Alg)
+
+	// Once retired, tokens of the old key are rejected.
+	require.NoError(loadJWTKeys(ctx, later.Add(jwtKeyLead+tokenMaxRefresh)))
+	body = get(t, "/user/me", old)
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusUnauthorized, resp.Code)
+}
+
+func TestBlock(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	request := func(do func() []byte) int {
+		var resp baseResponse
+		body := do()
+		require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+		return resp.Code
+	}
+	post := func(uri, token string) int {
+		return request(func() []byte { return postForm(t, uri, nil, token) })
+	}
+	require.Equal(http.StatusOK, post("/user/follow/122", tokens[0]))
+	require.Equal(http.StatusOK, post("/user/follow/121", tokens[1]))
+	defer func() {
+		require.NoError(store.UnblockUserInRedis(ctx, "121", "122"))
+	}()
+
+	// Blocking removes follows both ways.
+	require.Equal(http.StatusBadRequest, post("/user/block/121", tokens[0]))
+	require.Equal(http.StatusOK, post("/user/block/122", tokens[0]))
+	followings, err := store.GetFollowingsFromRedis(ctx, "121")
+	require.NoError(err)
+	require.NotContains(followings, "122")
+	followers, err := store.GetFollowersFromRedis(ctx, "121")
+	require.NoError(err)
+	require.NotContains(followers, "122")
+
+	var pub pubResponse
+	body := get(t, "/profile/122", tokens[0])
+	require.NoErrorf(json.Unmarshal(body, &pub), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(store.Blocking, pub.User.Follow)
+	status, err := store.GetFollowStatusFromRedis(ctx, "122", "121")
+	require.NoError(err)
+	require.Equal(store.Blocked, status)
+
+	var blocks struct {
+		Code   int
+		Blocks []*models.Follow
+	}
+	body = get(t, "/user/blocks", tokens[0])
+	require.NoErrorf(json.Unmarshal(body, &blocks), "Json Unmarshal Error <%v>", string(body))
+	require.Len(blocks.Blocks, 1)
+	require.Equal("122", blocks.Blocks[0].Username)
+
+	// The blocked user can't follow, see the profile or chat, neither can the blocker follow.
+	require.Equal(http.StatusForbidden, post("/user/follow/121", tokens[1]))
+	require.Equal(http.StatusForbidden, post("/user/follow/122", tokens[0]))
+	require.Equal(http.StatusBadRequest, request(func() []byte { return get(t, "/profile/121", tokens[1]) }))
+	require.Equal(http.StatusForbidden, request(func() []byte { return get(t, "/chat/121/permission", tokens[1]) }))
+	require.Equal(http.StatusOK, request(func() []byte { return get(t, "/chat/121/permission", tokens[2]) }))
+
+	require.Equal(http.StatusOK, post("/user/unblock/122", tokens[0]))
+	require.Equal(http.StatusOK, post("/user/follow/121", tokens[1]))
+	require.Equal(http.StatusOK, post("/user/unfollow/121", tokens[1]))
+	require.Equal(http.StatusOK, request(func() []byte { return get(t, "/profile/121", tokens[1]) }))
+}
+
+func TestPrivacy(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	user, err := store.GetUserByUsername(ctx, "123")
+	require.NoError(err)
+	defer func() {
+		require.NoError(store.UpdatePrivacy(ctx, user, &models.Privacy{}))
+	}()
+
+	code := func(body []byte) int {
+		var resp baseResponse
+		require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+		return resp.Code
+	}
+	require.Equal(http.StatusNotAcceptable, code(postForm(t, "/user/privacy", url.Values{"hide_followers": {"maybe"}}, tokens[2])))
+	require.Equal(http.StatusOK, code(postForm(t, "/user/privacy", url.Values{"hide_followers": {"true"}}, tokens[2])))
+	var privacy struct {
+		Code    int
+		Privacy models.Privacy
+	}
+	body := get(t, "/user/privacy", tokens[2])
+	require.NoErrorf(json.Unmarshal(body, &privacy), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(models.Privacy{HideFollowers: true}, privacy.Privacy)
+
+	// Hidden lists are only shown to their owner.
+	require.Equal(http.StatusForbidden, code(get(t, "/followers/123", tokens[0])))
+	require.Equal(http.StatusForbidden, code(get(t, "/followers/123", "")))
+	require.Equal(http.StatusOK, code(get(t, "/followers/123", tokens[2])))
+	require.Equal(http.StatusOK, code(get(t, "/followings/123", tokens[0])))
+
+	// Paused history records nothing, entries can be deleted one by one or all.
+	require.NoError(store.ClearWatchHistory(ctx, user.ID))
+	watch := func() {
+		recordWatch(ctx, user.ID, userIDOf(t, "121"))
+	}
+	require.Equal(http.StatusOK, code(postForm(t, "/user/privacy", url.Values{"history_paused": {"true"}}, tokens[2])))
+	watch()
+	var history struct {
+		Code    int
+		History []*models.History
+		Paused  bool
+	}
+	body = get(t, "/user/history", tokens[2])
+	require.NoErrorf(json.Unmarshal(body, &history), "Json Unmarshal Error <%v>", string(body))
+	require.True(history.Paused)
+	require.Empty(history.History)
+
+	require.Equal(http.StatusOK, code(postForm(t, "/user/privacy", url.Values{"history_paused": {"false"}}, tokens[2])))
+	watch()
+	require.NoError(store.UpdateWatchHistory(ctx, user.ID, userIDOf(t, "122")))
+	body = get(t, "/user/history", tokens[2])
+	require.NoErrorf(json.Unmarshal(body, &history), "Json Unmarshal Error <%v>", string(body))
+	require.False(history.Paused)
+	require.Len(history.History, 2)
+	require.Equal(http.StatusOK, code(del(t, "/user/history/121", tokens[2])))
+	require.Equal(http.StatusNotFound, code(del(t, "/user/history/121", tokens[2])))
+	body = get(t, "/user/history", tokens[2])
+	require.NoErrorf(json.Unmarshal(body, &history), "Json Unmarshal Error <%v>", string(body))
+	require.Len(history.History, 1)
+	require.Equal(http.StatusOK, code(del(t, "/user/history", tokens[2])))
+	body = get(t, "/user/history", tokens[2])
+	require.NoErrorf(json.Unmarshal(body, &history), "Json Unmarshal Error <%v>", string(body))
+	require.Empty(history.History)
+}
+
+func TestWatchSessions(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	user, err := store.GetUserByUsername(ctx, "124")
+	require.NoError(err)
+	channel, err := store.GetUserByUsername(ctx, "125")
+	require.NoError(err)
+	_, err = store.DeleteWatchSessions(ctx, user.ID, 0)
+	require.NoError(err)
+
+	code := func(body []byte) int {
+		var resp baseResponse
+		require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+		return resp.Code
+	}
+	require.Equal(http.StatusBadRequest, code(postForm(t, "/user/watch/125", url.Values{}, tokens[3])), "Channel isn't living.")
+	require.Equal(http.StatusNotFound, code(get(t, "/user/watch/125", tokens[3])))
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	client := store.NewRedisClient()
+	defer client.Close()
+	client.Set(ctx, "living:125", start.Format(time.RFC3339), 0)
+	defer client.Del(ctx, "living:125")
+
+	var heartbeat struct {
+		Code     int
+		Recorded bool
+		Session  models.WatchSessionInfo
+	}
+	body := postForm(t, "/user/watch/125", url.Values{"position": {"3600"}}, tokens[3])
+	require.NoErrorf(json.Unmarshal(body, &heartbeat), "Json Unmarshal Error <%v>", string(body))
+	require.True(heartbeat.Recorded)
+	require.Equal("125", heartbeat.Session.Channel)
+	require.True(start.Equal(heartbeat.Session.BroadcastStart))
+	first := heartbeat.Session.ID
+
+	// Heartbeats within the gap extend the session.
+	body = postForm(t, "/user/watch/125", url.Values{"position": {"3630"}}, tokens[3])
+	require.NoErrorf(json.Unmarshal(body, &heartbeat), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(first, heartbeat.Session.ID)
+	require.Equal(uint(3630), heartbeat.Session.Position)
+
+	// A heartbeat after the gap starts a new session.
+	session, err := store.GetLastWatchSession(ctx, user.ID, channel.ID)
+	require.NoError(err)
+	session.EndedAt = session.EndedAt.Add(-watchSessionGap - time.Minute)
+	session.Duration = 600
+	require.NoError(store.UpdateWatchSession(ctx, session))
+	body = postForm(t, "/user/watch/125", url.Values{"position": {"3700"}}, tokens[3])
+	require.NoErrorf(json.Unmarshal(body, &heartbeat), "Json Unmarshal Error <%v>", string(body))
+	require.NotEqual(first, heartbeat.Session.ID)
+
+	var resume struct {
+		Code      int
+		Resumable bool
+		Session   models.WatchSessionInfo
+	}
+	body = get(t, "/user/watch/125", tokens[3])
+	require.NoErrorf(json.Unmarshal(body, &resume), "Json Unmarshal Error <%v>", string(body))
+	require.True(resume.Resumable)
+	require.Equal(uint(3700), resume.Session.Position)
+
+	var sessions struct {
+		Code     int
+		Total    int
+		Sessions []*models.WatchSessionInfo
+	}
+	body = get(t, "/user/history/sessions?size=1", tokens[3])
+	require.NoErrorf(json.Unmarshal(body, &sessions), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(2, sessions.Total)
+	require.Len(sessions.Sessions, 1)
+	require.Equal(heartbeat.Session.ID, sessions.Sessions[0].ID, "Newest session first.")
+	require.Equal(http.StatusNotAcceptable, code(get(t, "/user/history/sessions?size=1000", tokens[3])))
+
+	var stats struct {
+		Code     int
+		Duration uint
+		Days     []*models.WatchDay
+	}
+	body = get(t, "/user/history/stats?days=7", tokens[3])
+	require.NoErrorf(json.Unmarshal(body, &stats), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(uint(600), stats.Duration)
+	require.NotEmpty(stats.Days)
+
+	// Paused history records nothing, deleting history deletes the sessions.
+	require.NoError(store.UpdatePrivacy(ctx, user, &models.Privacy{HistoryPaused: true}))
+	body = postForm(t, "/user/watch/125", url.Values{}, tokens[3])
+	require.NoErrorf(json.Unmarshal(body, &heartbeat), "Json Unmarshal Error <%v>", string(body))
+	require.False(heartbeat.Recorded)
+	require.NoError(store.UpdatePrivacy(ctx, user, &models.Privacy{}))
+
+	require.Equal(http.StatusOK, code(del(t, "/user/history/125", tokens[3])))
+	require.Equal(http.StatusNotFound, code(get(t, "/user/watch/125", tokens[3])))
+}
+
+func TestRecommendations(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	require.NoError(store.FollowUserInRedis(ctx, "123", "124"))
+	require.NoError(store.FollowUserInRedis(ctx, "124", "125"))
+	defer func() {
+		require.NoError(store.UnFollowUserInRedis(ctx, "123", "124"))
+		require.NoError(store.UnFollowUserInRedis(ctx, "124", "125"))
+	}()
+	client := store.NewRedisClient()
+	defer client.Close()
+	client.SAdd(ctx, "living", "121")
+	client.Set(ctx, "living:121", time.Now().Format(time.RFC3339), 0)
+	defer func() {
+		client.SRem(ctx, "living", "121")
+		client.Del(ctx, "living:121")
+	}()
+
+	var resp struct {
+		Code            int
+		Recommendations []*models.Recommendation
+	}
+	reasons := func(username string) []string {
+		for _, r := range resp.Recommendations {
+			if r.Username == username {
+				require.Equal(username, r.User.Username)
+				return r.Reasons
+			}
+		}
+		return nil
+	}
+
+	// Anonymous users get living channels.
+	body := get(t, "/user/recommendations", "")
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	require.Contains(reasons("121"), models.ReasonLiving)
+	require.Nil(reasons("125"))
+
+	// Channels followed by followings are recommended, followed ones and yourself aren't.
+	body = get(t, "/user/recommendations", tokens[2])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal([]string{models.ReasonFollowedByFollowings}, reasons("125"))
+	require.Contains(reasons("121"), models.ReasonLiving)
+	require.Nil(reasons("124"))
+	require.Nil(reasons("123"))
+
+	// Followings of who hides them aren't looked at.
+	user, err := store.GetUserByUsername(ctx, "124")
+	require.NoError(err)
+	require.NoError(store.UpdatePrivacy(ctx, user, &models.Privacy{HideFollowings: true}))
+	defer func() {
+		require.NoError(store.UpdatePrivacy(ctx, user, &models.Privacy{}))
+	}()
+	body = get(t, "/user/recommendations", tokens[2])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Nil(reasons("125"))
+
+	body = get(t, "/user/recommendations?num=100", tokens[2])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusNotAcceptable, resp.Code)
+}
+
+func TestFollowingLive(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	client := store.NewRedisClient()
+	defer client.Close()
+	for _, username := range []string{"121", "122", "123"} {
+		require.NoError(store.FollowUserInRedis(ctx, "125", username))
+		defer store.UnFollowUserInRedis(ctx, "125", username)
+	}
+	for username, watching := range map[string]int{"121": 5, "122": 9} {
+		client.SAdd(ctx, "living", username)
+		client.Set(ctx, "living:"+username, time.Now().Format(time.RFC3339), 0)
+		client.Set(ctx, "watching:"+username, watching, 0)
+		defer store.EndLiving(ctx, username)
+		defer client.Del(ctx, "watching:"+username)
+	}
+	require.NoError(store.EndLiving(ctx, "123"))
+
+	var resp struct {
+		Code     int
+		Living   int
+		Channels []*models.FollowingChannel
+	}
+	body := get(t, "/user/following/live", tokens[4])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	require.Equal(http.StatusOK, resp.Code)
+	require.Equal(2, resp.Living)
+	require.GreaterOrEqual(len(resp.Channels), 3)
+	require.Equal("122", resp.Channels[0].Username, "The most watched first.")
+	require.Equal(9, resp.Channels[0].Watching)
+	require.NotNil(resp.Channels[0].StartTime)
+	require.Equal("121", resp.Channels[1].Username)
+	require.Equal("123", resp.Channels[2].Username)
+	require.False(resp.Channels[2].Living)
+	require.NotNil(resp.Channels[2].LastLive, "Ended broadcasts are recorded.")
+
+	// Suspended channels are hidden.
+	user, err := store.GetUserByUsername(ctx, "123")
+	require.NoError(err)
+	require.NoError(store.SuspendUser(ctx, user, "spam", nil, 0))
+	defer func() {
+		require.NoError(store.UnsuspendUser(ctx, user))
+		tokens[2] = loginToken(t, validRegister[2].Username, validRegister[2].Password)
+	}()
+	body = get(t, "/user/following/live", tokens[4])
+	require.NoErrorf(json.Unmarshal(body, &resp), "Json Unmarshal Error <%v>", string(body))
+	for _, channel := range resp.Channels {
+		require.NotEqual("123", channel.Username)
+	}
+
+	n, err := store.UpdateLastLive(ctx, time.Now())
+	require.NoError(err)
+	require.GreaterOrEqual(n, 2)
+}
+
 func postJSON(t *testing.T, uri string, mp map[string]string, token string) []byte {
 	rec := httptest.NewRecorder()
 
@@ -486,6 +1972,44 @@ func get(t *testing.T, uri string, token string) []byte {
 	return body
 }
 
+func del(t *testing.T, uri string, token string) []byte {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("DELETE", uri, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "MiniTube "+token)
+	}
+
+	Router.ServeHTTP(rec, req)
+
+	resp := rec.Result()
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoErrorf(t, err, "Request %v shouldn't has error.", uri)
+	return body
+}
+
+func delJSON(t *testing.T, uri string, mp map[string]string, token string) []byte {
+	rec := httptest.NewRecorder()
+
+	jsonBytes, err := json.Marshal(mp)
+	require.NoErrorf(t, err, "Json Marshal error <%v>", mp)
+	req := httptest.NewRequest("DELETE", uri, strings.NewReader(string(jsonBytes)))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "MiniTube "+token)
+	}
+
+	Router.ServeHTTP(rec, req)
+
+	resp := rec.Result()
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoErrorf(t, err, "Request %v shouldn't has error.", uri)
+	return body
+}
+
 func mapUser(u interface{}) map[string]string {
 	if user, ok := u.(*models.LoginModel); ok {
 		return map[string]string{
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	jwt "minitube/middleware"
+	"minitube/models"
+	"minitube/store"
+	"minitube/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Personal access tokens.
+// Users create API tokens for bots and tools, each granted some scopes. They're sent
+// like jwts, "Authorization: MiniTube mtp_...", and can only access the routes listed
+// in apiTokenScopes. They aren't revoked with sessions, only by their users or expiry.
+const (
+	// apiTokenPrefix - API tokens start with it, jwts never do
+	apiTokenPrefix = "mtp_"
+	// maxAPITokens - API tokens a user can have at most
+	maxAPITokens = 20
+	// apiTokenTouchInterval - last use of a token is recorded at most once per this
+	apiTokenTouchInterval = time.Minute
+)
+
+// ErrAPITokenInvalid - API token is unknown, revoked or its user is gone, returned as the 401 response message
+var ErrAPITokenInvalid = errors.New("invalid api token")
+
+// apiTokenScopes - scopes API tokens need for routes, other routes are session only.
+var apiTokenScopes = jwt.RouteScopes{
+	"GET /user/me":                   {models.ScopeProfileRead},
+	"POST /user/profile":             {models.ScopeProfileWrite},
+	"GET /user/followers":            {models.ScopeFollowersRead},
+	"GET /stream/key/:username":      {models.ScopeStreamKey},
+	"GET /chat/:username/permission": {models.ScopeChatWrite},
+}
+
+// hashAPIToken - sha256 hex of token, tokens are random enough for a fast hash
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticateAPIToken - claims of API token, as GinJWTMiddleware.APITokenAuthenticator.
+// Its user is checked by validateSession like a session's.
+func authenticateAPIToken(token string, c *gin.Context) (jwt.MapClaims, error) {
+	ctx := c.Request.Context()
+	apiToken, err := store.GetAPITokenByHash(ctx, hashAPIToken(token))
+	if err != nil {
+		if errors.Is(err, store.ErrAPITokenNotExists) {
+			return nil, ErrAPITokenInvalid
+		}
+		c.Error(err)
+		return nil, err
+	}
+	now := time.Now()
+	if apiToken.IsExpired(now) {
+		return nil, jwt.ErrExpiredToken
+	}
+	user, err := store.GetUserByID(ctx, apiToken.UserID)
+	if err != nil {
+		if errors.Is(err, store.ErrRedisUserNotExists) || errors.Is(err, store.ErrMySQLUserNotExists) {
+			return nil, ErrAPITokenInvalid
+		}
+		c.Error(err)
+		return nil, err
+	}
+	// a login cancels deletion, a token doesn't
+	if user.IsDeletionPending() {
+		return nil, ErrAPITokenInvalid
+	}
+
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) >= apiTokenTouchInterval {
+		err := store.TouchAPIToken(context.WithoutCancel(ctx), apiToken, now, c.ClientIP())
+		if err != nil {
+			c.Error(err)
+		}
+	}
+
+	expire := now.Add(tokenTimeout)
+	if apiToken.ExpiresAt != nil {
+		expire = *apiToken.ExpiresAt
+	}
+	return jwt.MapClaims{
+		"id":            float64(user.ID),
+		"username":      user.Username,
+		tokenVersionKey: float64(user.TokenVersion),
+		jwt.ScopesKey:   apiToken.GrantedScopes(),
+		"exp":           float64(expire.Unix()),
+	}, nil
+}
+
+func listAPITokens(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := store.ListAPITokens(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	list := make([]*models.APITokenInfo, len(tokens))
+	for i, token := range tokens {
+		list[i] = models.NewAPITokenInfoFromAPIToken(token)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":   http.StatusOK,
+		"tokens": list,
+	})
+}
+
+func createAPIToken(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "Bad Token.",
+		})
+		return
+	}
+	req := new(models.CreateAPITokenModel)
+	if err := c.ShouldBind(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+
+	n, err := store.CountAPITokens(c.Request.Context(), user.ID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	if n >= maxAPITokens {
+		c.JSON(http.StatusConflict, gin.H{
+			"code":    http.StatusConflict,
+			"message": "Too many API tokens, please revoke some first.",
+		})
+		return
+	}
+
+	secret, err := utils.RandomToken(32)
+	var token string
+	if err == nil {
+		token = apiTokenPrefix + secret
+		apiToken := &models.APIToken{
+			UserID: user.ID,
+			Name:   req.Name,
+			Hash:   hashAPIToken(token),
+			Scopes: joinScopes(req.Scopes),
+		}
+		if req.ExpiresInDays > 0 {
+			expires := time.Now().AddDate(0, 0, req.ExpiresInDays)
+			apiToken.ExpiresAt = &expires
+		}
+		err = store.SaveAPIToken(c.Request.Context(), apiToken)
+		if err == nil {
+			info := models.NewAPITokenInfoFromAPIToken(apiToken)
+			event := newAuditEvent(c, models.AuditAPITokenCreate, user, nil, info)
+			saveAudit(c, event)
+			logger(c).Infow("API token created", "user", user, "token", apiToken.ID)
+			// the only time token is shown
+			c.JSON(http.StatusOK, gin.H{
+				"code":  http.StatusOK,
+				"token": token,
+				"info":  info,
+			})
+			return
+		}
+	}
+	c.Error(err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"code":    http.StatusInternalServerError,
+		"message": "Server Error",
+	})
+}
+
+func revokeAPIToken(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "Bad Token.",
+		})
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "param not correct.",
+		})
+		return
+	}
+
+	apiToken, err := store.DeleteAPIToken(c.Request.Context(), user.ID, uint(id))
+	if err != nil {
+		if errors.Is(err, store.ErrAPITokenNotExists) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    http.StatusNotFound,
+				"message": "API token not exists.",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	event := newAuditEvent(c, models.AuditAPITokenRevoke, user, models.NewAPITokenInfoFromAPIToken(apiToken), nil)
+	saveAudit(c, event)
+	logger(c).Infow("API token revoked", "user", user, "token", apiToken.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "API token revoked.",
+	})
+}
+
+// getMyFollowers - followers of current user with when they followed, newest first
+func getMyFollowers(c *gin.Context) {
+	username, ok := getUsernameWithError(c)
+	if !ok {
+		return
+	}
+
+	followers, err := store.GetFollowsWithTimeFromRedis(c.Request.Context(), username, true)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":      http.StatusOK,
+		"count":     len(followers),
+		"followers": followers,
+	})
+}
+
+// joinScopes - scopes deduplicated and space separated, as kept in APIToken
+func joinScopes(scopes []string) string {
+	seen := make(map[string]bool)
+	joined := ""
+	for _, scope := range scopes {
+		if seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		if joined != "" {
+			joined += " "
+		}
+		joined += scope
+	}
+	return joined
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"minitube/middleware"
+	"minitube/models"
+	"minitube/store"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAuditEvent - audit event of action on target (nil if unknown) done by current user,
+// before and after are changed fields, encoded as json if not nil.
+func newAuditEvent(c *gin.Context, action string, target *models.User, before, after interface{}) *models.AuditEvent {
+	event := &models.AuditEvent{
+		Action:    action,
+		IP:        c.ClientIP(),
+		UserAgent: truncate(c.Request.UserAgent(), 255),
+	}
+	claims := middleware.ExtractClaims(c)
+	if id, ok := claims["id"].(float64); ok {
+		actor := uint(id)
+		event.ActorID = &actor
+		event.ActorUsername, _ = claims["username"].(string)
+	}
+	if target != nil {
+		event.TargetUserID = &target.ID
+		event.Target = target.Username
+	}
+	if before != nil {
+		b, _ := json.Marshal(before)
+		event.Before = string(b)
+	}
+	if after != nil {
+		b, _ := json.Marshal(after)
+		event.After = string(b)
+	}
+	return event
+}
+
+// saveAudit - append audit event, failure is logged but doesn't fail the request
+func saveAudit(c *gin.Context, event *models.AuditEvent) {
+	err := store.SaveAuditEvent(context.WithoutCancel(c.Request.Context()), event)
+	if err != nil {
+		c.Error(err)
+	}
+}
+
+// audit - record action on target done by current user
+func audit(c *gin.Context, action string, target *models.User, before, after interface{}) {
+	saveAudit(c, newAuditEvent(c, action, target, before, after))
+}
+
+// auditLogin - record login result, actor is the user logged in
+func auditLogin(c *gin.Context, user *models.User, login *models.LoginModel, err error) {
+	if err == nil {
+		event := newAuditEvent(c, models.AuditLoginSuccess, user, nil, nil)
+		event.ActorID, event.ActorUsername = &user.ID, user.Username
+		saveAudit(c, event)
+		return
+	}
+	event := newAuditEvent(c, models.AuditLoginFailure, user, nil, gin.H{"reason": err.Error()})
+	if user == nil {
+		// keep what was tried for unknown accounts
+		switch {
+		case login.Username != "":
+			event.Target = login.Username
+		case login.Email != "":
+			event.Target = truncate(login.Email, 50)
+		default:
+			event.Target = login.Phone
+		}
+	}
+	saveAudit(c, event)
+}
+
+// profileDiff - fields changed by profile, as before and after
+func profileDiff(user *models.User, profile *models.ChangeProfileModel) (before, after gin.H) {
+	before, after = gin.H{}, gin.H{}
+	diff := func(key string, old *string, new string) {
+		if (old == nil && new != "") || (old != nil && *old != new) {
+			before[key], after[key] = old, new
+		}
+	}
+	diff("email", user.Email, profile.Email)
+	diff("phone", user.Phone, profile.Phone)
+	diff("live_name", user.Room.Name, profile.LiveName)
+	diff("live_intro", user.Room.Intro, profile.LiveIntro)
+	diff("live_category", user.Room.Category, profile.LiveCategory)
+	return before, after
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+func getSecurityLog(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+	req := new(models.AuditQueryModel)
+	if err := c.ShouldBindQuery(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+	page, size := pageOf(req.Page, req.Size)
+
+	filter := &store.AuditFilter{TargetUserID: id, OwnOnly: true, Action: req.Action, From: req.From, To: req.To}
+	events, total, err := store.ListAuditEvents(c.Request.Context(), filter, page, size)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	list := make([]*models.SecurityEvent, len(events))
+	for i, event := range events {
+		list[i] = models.NewSecurityEventFromAuditEvent(event)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":   http.StatusOK,
+		"total":  total,
+		"events": list,
+	})
+}
+
+func listAuditEvents(c *gin.Context) {
+	req := new(models.AuditQueryModel)
+	if err := c.ShouldBindQuery(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+	page, size := pageOf(req.Page, req.Size)
+
+	filter := &store.AuditFilter{Action: req.Action, From: req.From, To: req.To}
+	if req.Actor != "" {
+		actor, ok := userByUsername(c, req.Actor)
+		if !ok {
+			return
+		}
+		filter.ActorID = actor.ID
+	}
+	if req.Target != "" {
+		target, ok := userByUsername(c, req.Target)
+		if !ok {
+			return
+		}
+		filter.TargetUserID = target.ID
+	}
+
+	events, total, err := store.ListAuditEvents(c.Request.Context(), filter, page, size)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":   http.StatusOK,
+		"total":  total,
+		"events": events,
+	})
+}
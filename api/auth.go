@@ -5,26 +5,39 @@ import (
 	jwt "minitube/middleware"
 	"minitube/models"
 	"minitube/store"
+	"minitube/utils"
 	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// tokenTimeout - jwt and its cookies are valid for
+const tokenTimeout = time.Hour
+
+// tokenMaxRefresh - jwt can be refreshed until this long after login
+const tokenMaxRefresh = 24 * time.Hour
+
+// passwords - hash and verify passwords, algorithm and parameters are from env,
+// hashes made with old ones are upgraded when their users login.
+var passwords = utils.NewPasswordHasherFromEnv()
+
 var authMiddleware, err = jwt.New(&jwt.GinJWTMiddleware{
 	Realm:         "MiniTube",
 	Key:           []byte(os.Getenv("JWT_SECRET_KEY")),
-	Timeout:       time.Hour,
-	MaxRefresh:    24 * time.Hour,
+	Timeout:       tokenTimeout,
+	MaxRefresh:    tokenMaxRefresh,
 	IdentityKey:   "id",
 	TokenHeadName: "MiniTube",
 
 	PayloadFunc: func(data interface{}) jwt.MapClaims {
 		if v, ok := data.(*models.User); ok {
 			return jwt.MapClaims{
-				"id": v.ID,
-				"username": v.Username,
+				"id":               v.ID,
+				"username":         v.Username,
+				tokenVersionKey:    v.TokenVersion,
+				jwt.RolesKey:       v.RoleNames(),
+				jwt.PermissionsKey: v.PermissionNames(),
 			}
 		}
 		return jwt.MapClaims{}
@@ -41,56 +54,86 @@ var authMiddleware, err = jwt.New(&jwt.GinJWTMiddleware{
 	Authenticator: func(c *gin.Context) (interface{}, error) {
 		loginUser := new(models.LoginModel)
 		if err := c.ShouldBind(loginUser); err != nil {
-			log.Debug(err)
+			logger(c).Debug(err)
 			return nil, jwt.ErrFailedAuthentication
 		}
 
-		log.Debugf("User %#v is logining in.", loginUser)
+		logger(c).Debugw("User is logining in.", "user", loginUser)
+		if err := checkLoginLock(c, ipSubject(c.ClientIP())); err != nil {
+			auditLogin(c, nil, loginUser, err)
+			return nil, err
+		}
+
 		var user *models.User
 		var err error
 		if username := loginUser.Username; username != "" {
-			user, err = store.GetUserByUsername(username)
+			user, err = store.GetUserByUsername(c.Request.Context(), username)
 		} else if email := loginUser.Email; email != "" {
-			user, err = store.GetUserByEmail(email)
+			user, err = store.GetUserByEmail(c.Request.Context(), email)
 		} else if phone := loginUser.Phone; phone != "" {
-			user, err = store.GetUserByPhone(phone)
+			user, err = store.GetUserByPhone(c.Request.Context(), phone)
 		} else {
 			err = errors.New("Login validator has some error")
 		}
 		if err != nil {
 			if errors.Is(err, store.ErrMySQLUserNotExists) {
+				recordLoginFailure(c, nil)
+				auditLogin(c, nil, loginUser, jwt.ErrFailedAuthentication)
 				return nil, jwt.ErrFailedAuthentication
 			}
 			c.Error(err)
 			return nil, err
 		}
 
+		if err := checkLoginLock(c, userSubject(user.ID)); err != nil {
+			auditLogin(c, user, loginUser, err)
+			return nil, err
+		}
+		if err := checkLoginChallenge(c, user, loginUser); err != nil {
+			auditLogin(c, user, loginUser, err)
+			return nil, err
+		}
+
 		// log.Debugf("User %#v need auth to %#v", loginUser, user)
 		password := loginUser.Password
-		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+		err = passwords.Verify(user.Password, password)
 		if err == nil {
-			log.Debugf("User %#v auth success", user)
+			logger(c).Debugw("User auth success", "user", user)
+			store.ResetLoginFailures(c.Request.Context(), userSubject(user.ID))
+			rehashPassword(c, user, password)
+			if user.IsSuspended(time.Now()) {
+				err = suspendedError(user)
+				auditLogin(c, user, loginUser, err)
+				return nil, err
+			}
+			// login in grace period restores the account
+			if user.IsDeletionPending() {
+				if err := cancelAccountDeletion(c, user); err != nil {
+					return nil, err
+				}
+			}
+			auditLogin(c, user, loginUser, nil)
 			return user, nil
 		}
-		if errors.Is(err, bcrypt.ErrHashTooShort) {
+		// users signed up with OIDC have no password to verify
+		if !errors.Is(err, utils.ErrPasswordMismatch) && user.HasPassword() {
 			c.Error(err)
 		}
+		recordLoginFailure(c, user)
+		auditLogin(c, user, loginUser, jwt.ErrFailedAuthentication)
 		return nil, jwt.ErrFailedAuthentication
 	},
+	// route specific rules are attached to routes with jwt.Authorize
 	Authorizator: func(data interface{}, c *gin.Context) bool {
 		if user, ok := data.(*models.User); ok {
-			if user.ID == 0 {
-				return false
-			}
-			if c.FullPath() == "/stream/key/:username" {
-				if user.Username != c.Param("username") {
-					return false
-				}
-			}
-			return true
+			return user.ID != 0 && !mustResetPassword(c) && apiTokenScopes.Allow(c, jwt.ExtractClaims(c))
 		}
 		return false
 	},
+	ClaimsValidator: validateSession,
+	// personal access tokens, see apitoken.go
+	APITokenPrefix:        apiTokenPrefix,
+	APITokenAuthenticator: authenticateAPIToken,
 	Unauthorized: func(c *gin.Context, code int, message string) {
 		c.JSON(code, gin.H{
 			"code":    code,
@@ -98,10 +141,32 @@ var authMiddleware, err = jwt.New(&jwt.GinJWTMiddleware{
 		})
 	},
 
-	TokenLookup: "header: Authorization, cookie: token",
+	LoginResponse:   jwtTokenResponse,
+	RefreshResponse: jwtTokenResponse,
+	LogoutResponse:  logoutResponse,
+
+	TokenLookup: "header: Authorization, cookie: " + sessionCookieName,
 
 	SendCookie:     true,
-	SecureCookie:   false,
+	SecureCookie:   cookieSecure,
 	CookieHTTPOnly: true,
-	CookieName:     "token",
+	CookieName:     sessionCookieName,
+	CookieSameSite: cookieSameSite,
 })
+
+// rehashPassword - upgrade user's password hash after login, if it's made with outdated
+// algorithm or parameters, a failure only keeps the old hash.
+func rehashPassword(c *gin.Context, user *models.User, password string) {
+	if !passwords.NeedsRehash(user.Password) {
+		return
+	}
+	hash, err := passwords.Hash(password)
+	if err == nil {
+		err = store.UpdatePasswordHash(c.Request.Context(), user, hash)
+	}
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	logger(c).Infow("User password rehashed", "user", user)
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"errors"
+	"minitube/store"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Blocking users.
+// Blocking someone removes follows between you both ways, and they can't follow you,
+// chat in your room or see your profile until you unblock them.
+
+func block(c *gin.Context) {
+	blockOrNot(c, true)
+}
+
+func unblock(c *gin.Context) {
+	blockOrNot(c, false)
+}
+
+func blockOrNot(c *gin.Context, block bool) {
+	username, ok := getUsernameWithError(c)
+	if !ok {
+		return
+	}
+
+	dstUsername := c.Param("username")
+	if username == dstUsername {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "Can't block or unblock yourself.",
+		})
+		return
+	}
+
+	_, err := store.GetUserByUsername(c.Request.Context(), dstUsername)
+	if err != nil {
+		if errors.Is(err, store.ErrRedisUserNotExists) || errors.Is(err, store.ErrMySQLUserNotExists) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "User not exists.",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	if block {
+		err = store.BlockUserInRedis(c.Request.Context(), username, dstUsername)
+	} else {
+		err = store.UnblockUserInRedis(c.Request.Context(), username, dstUsername)
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	logger(c).Infow("User block changed", "username", username, "target", dstUsername, "block", block)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "OK",
+	})
+}
+
+// getBlocks - users blocked by current user with when they're blocked, newest first
+func getBlocks(c *gin.Context) {
+	username, ok := getUsernameWithError(c)
+	if !ok {
+		return
+	}
+
+	blocks, err := store.GetBlockingsFromRedis(c.Request.Context(), username)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":   http.StatusOK,
+		"blocks": blocks,
+	})
+}
+
+// blockedWithError - respond 403 if username or dstUsername blocks the other
+func blockedWithError(c *gin.Context, username, dstUsername string) bool {
+	blocked, err := store.IsBlockedInRedis(c.Request.Context(), username, dstUsername)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return true
+	}
+	if blocked {
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "You can't interact with this user.",
+		})
+	}
+	return blocked
+}
+
+// getChatPermission - whether current user can chat in the room of username, asked by
+// the chat service with the user's token before accepting messages.
+func getChatPermission(c *gin.Context) {
+	username, ok := getUsernameWithError(c)
+	if !ok {
+		return
+	}
+	if blockedWithError(c, username, c.Param("username")) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "OK",
+	})
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"minitube/middleware"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionCookieName = "token"
+	csrfCookieName    = "csrf_token"
+)
+
+// csrfSecret - CSRF_SECRET_KEY, fallback to JWT_SECRET_KEY
+var csrfSecret = func() []byte {
+	if key := os.Getenv("CSRF_SECRET_KEY"); key != "" {
+		return []byte(key)
+	}
+	return []byte(os.Getenv("JWT_SECRET_KEY"))
+}()
+
+// cookieSecure - COOKIE_SECURE=true sends cookies over https only
+var cookieSecure = os.Getenv("COOKIE_SECURE") == "true"
+
+// cookieSameSite - COOKIE_SAMESITE is one of lax, strict or none, default lax
+var cookieSameSite = func() http.SameSite {
+	switch strings.ToLower(os.Getenv("COOKIE_SAMESITE")) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}()
+
+var csrfMiddleware = middleware.CSRF(&middleware.CSRFConfig{
+	Secret:        csrfSecret,
+	SessionCookie: sessionCookieName,
+	// they don't rely on the session cookie
+	ExemptPaths: []string{"/login", "/register"},
+})
+
+// setCSRFCookie - readable cookie for client to echo in X-CSRF-Token header
+func setCSRFCookie(c *gin.Context, token string, maxAge int) {
+	c.SetSameSite(cookieSameSite)
+	c.SetCookie(csrfCookieName, middleware.CSRFToken(csrfSecret, token), maxAge, "/", "", cookieSecure, false)
+}
+
+func jwtTokenResponse(c *gin.Context, code int, token string, expire time.Time) {
+	setCSRFCookie(c, token, int(tokenTimeout.Seconds()))
+	c.JSON(http.StatusOK, gin.H{
+		"code":       http.StatusOK,
+		"token":      token,
+		"expire":     expire.Format(time.RFC3339),
+		"csrf_token": middleware.CSRFToken(csrfSecret, token),
+	})
+}
+
+func logoutResponse(c *gin.Context, code int) {
+	setCSRFCookie(c, "", -1)
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+	})
+}
@@ -0,0 +1,328 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"minitube/middleware"
+	"minitube/models"
+	"minitube/store"
+	"minitube/utils"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Personal data export (takeout).
+// An export runs in background, client polls its status, and when it's done
+// downloads the zip through a signed link, which needs no session.
+const (
+	exportTimeout = 5 * time.Minute
+	// exportTTL - how long the zip is kept
+	exportTTL = 24 * time.Hour
+	// exportLinkTTL - how long a download link is valid
+	exportLinkTTL = 15 * time.Minute
+	// exportAuditLimit - max audit events exported
+	exportAuditLimit = 10000
+	// exportWatchSessionLimit - max watch sessions exported, newest ones
+	exportWatchSessionLimit = 10000
+)
+
+// exportSecret - EXPORT_SECRET_KEY signs download links, fallback to JWT_SECRET_KEY
+var exportSecret = func() []byte {
+	if key := os.Getenv("EXPORT_SECRET_KEY"); key != "" {
+		return []byte(key)
+	}
+	return []byte(os.Getenv("JWT_SECRET_KEY"))
+}()
+
+func createExport(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+
+	// one export at a time
+	export, err := store.GetUserExport(c.Request.Context(), id)
+	if err != nil && !errors.Is(err, store.ErrExportNotExists) {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	if err == nil && export.InProgress() && time.Since(export.CreatedAt) < exportTimeout {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "Export is in progress.",
+			"export":  export,
+		})
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	export = &models.Export{
+		ID:        hex.EncodeToString(b),
+		UserID:    id,
+		Status:    models.ExportPending,
+		CreatedAt: time.Now(),
+	}
+	if err := store.SaveExport(c.Request.Context(), export, exportTTL); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	logger(c).Infow("User requested data export", "export", export.ID)
+	audit(c, models.AuditExportRequest, currentUser(c), nil, gin.H{"export": export.ID})
+	go runExport(context.WithoutCancel(c.Request.Context()), export)
+	c.JSON(http.StatusAccepted, gin.H{
+		"code":    http.StatusAccepted,
+		"message": "Accepted",
+		"export":  export,
+	})
+}
+
+func getExport(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+
+	export, err := store.GetExport(c.Request.Context(), c.Param("id"))
+	if err == nil && export.UserID != id {
+		err = store.ErrExportNotExists
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrExportNotExists) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    http.StatusNotFound,
+				"message": "Export not exists.",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	res := gin.H{
+		"code":   http.StatusOK,
+		"export": export,
+	}
+	if export.Status == models.ExportDone {
+		expires := time.Now().Add(exportLinkTTL)
+		if export.ExpiresAt != nil && export.ExpiresAt.Before(expires) {
+			expires = *export.ExpiresAt
+		}
+		res["download_url"] = middleware.SignURL(exportSecret, "/export/"+export.ID+"/download", expires)
+		res["download_expires"] = expires
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// downloadExport - serve the zip, the signed url is checked by middleware.SignedURL
+func downloadExport(c *gin.Context) {
+	export, err := store.GetExport(c.Request.Context(), c.Param("id"))
+	var data []byte
+	if err == nil {
+		data, err = store.GetExportFile(c.Request.Context(), export.ID)
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrExportNotExists) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    http.StatusNotFound,
+				"message": "Export not exists.",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	// no session here, the owner of export is the actor
+	if user, err := store.GetUserByID(c.Request.Context(), export.UserID); err == nil {
+		event := newAuditEvent(c, models.AuditExportDownload, user, nil, gin.H{"export": export.ID})
+		event.ActorID, event.ActorUsername = &user.ID, user.Username
+		saveAudit(c, event)
+	}
+	filename := "minitube-export-" + export.CreatedAt.UTC().Format("20060102") + ".zip"
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "application/zip", data)
+}
+
+// runExport - build the zip of export, and save it with export's status
+func runExport(ctx context.Context, export *models.Export) {
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+	ctx, span := utils.Tracer.Start(ctx, "runExport")
+	defer span.End()
+
+	export.Status = models.ExportRunning
+	err := store.SaveExport(ctx, export, exportTTL)
+	if err == nil {
+		var data []byte
+		data, err = buildExport(ctx, export.UserID)
+		if err == nil {
+			err = store.SaveExportFile(ctx, export.ID, data, exportTTL)
+		}
+	}
+
+	now := time.Now()
+	export.FinishedAt = &now
+	if err != nil {
+		utils.SugarFrom(ctx).Warnw("Data export failed", "export", export.ID, "error", err)
+		export.Status = models.ExportFailed
+	} else {
+		expires := now.Add(exportTTL)
+		export.Status, export.ExpiresAt = models.ExportDone, &expires
+	}
+	// ctx may have timed out, status must be saved anyway
+	if err := store.SaveExport(context.WithoutCancel(ctx), export, exportTTL); err != nil {
+		utils.SugarFrom(ctx).Warnw("Save data export status failed", "export", export.ID, "error", err)
+	}
+}
+
+// buildExport - zip of json files of everything kept for user
+func buildExport(ctx context.Context, id uint) ([]byte, error) {
+	user, err := store.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	followers, err := store.GetFollowsWithTimeFromRedis(ctx, user.Username, true)
+	if err != nil {
+		return nil, err
+	}
+	followings, err := store.GetFollowsWithTimeFromRedis(ctx, user.Username, false)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := store.GetBlockingsFromRedis(ctx, user.Username)
+	if err != nil {
+		return nil, err
+	}
+	history, err := store.GetWatchHistory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := exportWatchSessions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	broadcasts, err := exportBroadcasts(ctx, user.Username)
+	if err != nil {
+		return nil, err
+	}
+	events, err := exportSecurityEvents(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"profile.json", models.GetMeFromUser(user)},
+		{"room.json", models.NewExportRoomFromRoom(&user.Room)},
+		{"followers.json", followers},
+		{"followings.json", followings},
+		{"blocks.json", blocks},
+		{"history.json", history},
+		{"watch_sessions.json", sessions},
+		{"broadcasts.json", broadcasts},
+		{"audit.json", events},
+	}
+
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	now := time.Now()
+	for _, file := range files {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return nil, err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.v); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// exportBroadcasts - only the current broadcast is known, past ones aren't kept
+func exportBroadcasts(ctx context.Context, username string) ([]*models.Broadcast, error) {
+	broadcasts := make([]*models.Broadcast, 0, 1)
+	start, err := store.GetLivingTime(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if start != nil {
+		broadcasts = append(broadcasts, &models.Broadcast{StartTime: *start})
+	}
+	return broadcasts, nil
+}
+
+// exportWatchSessions - user's watch sessions, as shown in /user/history/sessions
+func exportWatchSessions(ctx context.Context, id uint) ([]*models.WatchSessionInfo, error) {
+	list := make([]*models.WatchSessionInfo, 0)
+	for page := 1; len(list) < exportWatchSessionLimit; page++ {
+		sessions, total, err := store.ListWatchSessions(ctx, id, page, 100)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, sessions...)
+		if len(sessions) == 0 || len(list) >= total {
+			break
+		}
+	}
+	return list, nil
+}
+
+// exportSecurityEvents - user's security log, as shown in /user/security-log
+func exportSecurityEvents(ctx context.Context, id uint) ([]*models.SecurityEvent, error) {
+	filter := &store.AuditFilter{TargetUserID: id, OwnOnly: true}
+	list := make([]*models.SecurityEvent, 0)
+	for page := 1; len(list) < exportAuditLimit; page++ {
+		events, total, err := store.ListAuditEvents(ctx, filter, page, 100)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			list = append(list, models.NewSecurityEventFromAuditEvent(event))
+		}
+		if len(events) == 0 || len(list) >= total {
+			break
+		}
+	}
+	return list, nil
+}
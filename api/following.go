@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"minitube/models"
+	"minitube/store"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// lastLiveInterval - living channels are recorded this often, for when they were last live
+	lastLiveInterval = time.Minute
+	// followingLiveLimit - offline channels are shown of this many latest followings,
+	// living ones are always shown
+	followingLiveLimit = 500
+)
+
+// getFollowingLive - channels current user follows, all living ones first with the most viewers
+// first, then the latest offline ones with when they were last live
+func getFollowingLive(c *gin.Context) {
+	username, ok := getUsernameWithError(c)
+	if !ok {
+		return
+	}
+
+	channels, err := store.GetFollowingLive(c.Request.Context(), username, followingLiveLimit)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	models.SortFollowingChannels(channels)
+
+	living := 0
+	for _, channel := range channels {
+		if channel.Living {
+			living++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":     http.StatusOK,
+		"living":   living,
+		"channels": channels,
+	})
+}
+
+// RunLastLiveTracker - record living channels every lastLiveInterval until ctx is done
+func RunLastLiveTracker(ctx context.Context) {
+	ticker := time.NewTicker(lastLiveInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := store.UpdateLastLive(ctx, time.Now()); err != nil {
+			log.Warnw("Update last live failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
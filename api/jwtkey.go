@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	jwt "minitube/middleware"
+	"minitube/models"
+	"minitube/store"
+	"minitube/utils"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwtgo "github.com/golang-jwt/jwt/v4"
+)
+
+// JWT signing keys.
+// Tokens are signed with JWT_SECRET_KEY by default. With JWT_SIGNING_ALGORITHM set to
+// RS256 or EdDSA, they're signed with private keys shared by instances through MySQL,
+// and other services verify them with the public keys at /.well-known/jwks.json.
+// A new key is made every JWT_KEY_ROTATION and published jwtKeyLead before it signs,
+// the old one keeps verifying until its tokens can't be refreshed anymore. Private keys
+// are encrypted with JWT_KEY_ENCRYPTION_KEY in MySQL, so a leaked dump can't sign tokens.
+var (
+	jwtSigningAlgorithm = os.Getenv("JWT_SIGNING_ALGORITHM")
+	jwtKeyRotation      = durationFromEnv("JWT_KEY_ROTATION", 30*24*time.Hour)
+	jwtKeyEncryptionKey = jwtKeyEncryptionKeyFromEnv()
+)
+
+const (
+	// jwtKeyLead - a new key is published this long before it signs,
+	// longer than instances reload keys and verifiers cache the key set
+	jwtKeyLead = 15 * time.Minute
+	// jwtKeyReload - keys are reloaded this often, and at most this often for an unknown kid
+	jwtKeyReload = time.Minute
+	// jwksMaxAge - verifiers may cache the key set for, in seconds
+	jwksMaxAge = "300"
+)
+
+var (
+	jwtKeys = new(utils.KeySet)
+
+	jwtKeysMu       sync.Mutex
+	jwtKeysLoadedAt time.Time
+)
+
+func init() {
+	switch jwtSigningAlgorithm {
+	case "", "HS256":
+		return
+	case utils.AlgRS256, utils.AlgEdDSA:
+	default:
+		log.Fatal("Unsupported JWT_SIGNING_ALGORITHM: ", jwtSigningAlgorithm)
+	}
+	if jwtKeyEncryptionKey == nil {
+		log.Fatal("JWT_KEY_ENCRYPTION_KEY is required with JWT_SIGNING_ALGORITHM ", jwtSigningAlgorithm)
+	}
+	if err := rotateJWTKeys(context.Background(), time.Now()); err != nil {
+		log.Fatal("Load jwt signing keys failed: ", err)
+	}
+	authMiddleware.KeyFunc = jwtKeyFunc
+	authMiddleware.SigningKeyFunc = jwtSigningKey
+}
+
+// jwtKeyEncryptionKeyFromEnv - JWT_KEY_ENCRYPTION_KEY, 32 bytes in base64, nil if not set
+func jwtKeyEncryptionKeyFromEnv() []byte {
+	v := os.Getenv("JWT_KEY_ENCRYPTION_KEY")
+	if v == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil || len(key) != 32 {
+		log.Fatal("JWT_KEY_ENCRYPTION_KEY must be 32 bytes in base64")
+	}
+	return key
+}
+
+// asymmetricJWT - whether tokens are signed with rotated private keys
+func asymmetricJWT() bool {
+	return authMiddleware.SigningKeyFunc != nil
+}
+
+// jwtSigningKey - the key signing new tokens, as GinJWTMiddleware.SigningKeyFunc
+func jwtSigningKey() (string, jwtgo.SigningMethod, interface{}, error) {
+	key, err := jwtKeys.Signing(time.Now())
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return key.ID, key.Method(), key.Key, nil
+}
+
+// jwtKeyFunc - public key of token's kid, as GinJWTMiddleware.KeyFunc.
+// Keys are reloaded for an unknown kid, it may be made by another instance.
+func jwtKeyFunc(t *jwtgo.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, err := jwtKeys.Verifying(kid, time.Now())
+	if errors.Is(err, utils.ErrUnknownSigningKey) && reloadJWTKeys() {
+		key, err = jwtKeys.Verifying(kid, time.Now())
+	}
+	if err != nil {
+		return nil, err
+	}
+	// or a token could pick an algorithm the key isn't for
+	if t.Method != key.Method() {
+		return nil, jwt.ErrInvalidSigningAlgorithm
+	}
+	return key.Key.Public(), nil
+}
+
+// reloadJWTKeys - reload keys if they're not loaded recently, return whether they're reloaded
+func reloadJWTKeys() bool {
+	jwtKeysMu.Lock()
+	recent := time.Since(jwtKeysLoadedAt) < jwtKeyReload
+	jwtKeysMu.Unlock()
+	if recent {
+		return false
+	}
+	if err := loadJWTKeys(context.Background(), time.Now()); err != nil {
+		log.Warnw("Reload jwt signing keys failed", "error", err)
+		return false
+	}
+	return true
+}
+
+// loadJWTKeys - load keys not retired at now from store
+func loadJWTKeys(ctx context.Context, now time.Time) error {
+	jwtKeysMu.Lock()
+	jwtKeysLoadedAt = now
+	jwtKeysMu.Unlock()
+
+	rows, err := store.GetJWTKeys(ctx, now)
+	if err != nil {
+		return err
+	}
+	keys := make([]*utils.SigningKey, 0, len(rows))
+	for _, m := range rows {
+		var notAfter time.Time
+		if m.NotAfter != nil {
+			notAfter = *m.NotAfter
+		}
+		key, err := openJWTKey(m, notAfter)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	jwtKeys.Set(keys)
+	return nil
+}
+
+// openJWTKey - decrypt private key of m
+func openJWTKey(m *models.JWTKey, notAfter time.Time) (*utils.SigningKey, error) {
+	sealed, err := base64.StdEncoding.DecodeString(m.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return utils.OpenSigningKey(m.ID, m.Algorithm, sealed, jwtKeyEncryptionKey, m.NotBefore, notAfter)
+}
+
+// jwtKeyDue - whether the newest key, of algorithm and signing since notBefore, is due for rotation at now
+func jwtKeyDue(algorithm string, notBefore, now time.Time) bool {
+	return algorithm != jwtSigningAlgorithm || now.Sub(notBefore) >= jwtKeyRotation
+}
+
+// rotateJWTKeys - make a new key if the newest is due for rotation or of another
+// algorithm, then reload keys and delete retired ones. The first key signs at once.
+func rotateJWTKeys(ctx context.Context, now time.Time) error {
+	ctx, span := utils.Tracer.Start(ctx, "rotateJWTKeys")
+	defer span.End()
+
+	if err := loadJWTKeys(ctx, now); err != nil {
+		return err
+	}
+	keys := jwtKeys.Keys()
+	if len(keys) == 0 || jwtKeyDue(keys[0].Algorithm, keys[0].NotBefore, now) {
+		notBefore := now.Add(jwtKeyLead)
+		if _, err := jwtKeys.Signing(now); err != nil {
+			notBefore = now
+		}
+		key, err := utils.GenerateSigningKey(jwtSigningAlgorithm, notBefore)
+		if err != nil {
+			return err
+		}
+		sealed, err := key.SealPEM(jwtKeyEncryptionKey)
+		if err != nil {
+			return err
+		}
+		m := &models.JWTKey{
+			ID:         key.ID,
+			Algorithm:  key.Algorithm,
+			PrivateKey: base64.StdEncoding.EncodeToString(sealed),
+			NotBefore:  key.NotBefore,
+		}
+		// another instance may have rotated since keys were loaded
+		due := func(newest *models.JWTKey) bool {
+			return newest == nil || jwtKeyDue(newest.Algorithm, newest.NotBefore, now)
+		}
+		// tokens signed until the new key signs can be refreshed for tokenMaxRefresh
+		rotated, err := store.RotateJWTKey(ctx, due, m, notBefore.Add(tokenMaxRefresh))
+		if err != nil {
+			return err
+		}
+		if rotated {
+			log.Infow("JWT signing key rotated", "kid", key.ID, "algorithm", key.Algorithm, "not_before", notBefore)
+		}
+		if err := loadJWTKeys(ctx, now); err != nil {
+			return err
+		}
+	}
+
+	n, err := store.DeleteRetiredJWTKeys(ctx, now)
+	if err == nil && n > 0 {
+		log.Infow("Retired jwt signing keys deleted", "count", n)
+	}
+	return err
+}
+
+// RunJWTKeyRotation - reload and rotate jwt signing keys until ctx is done,
+// it does nothing if tokens are signed with JWT_SECRET_KEY.
+func RunJWTKeyRotation(ctx context.Context) {
+	if !asymmetricJWT() {
+		return
+	}
+	ticker := time.NewTicker(jwtKeyReload)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := rotateJWTKeys(ctx, time.Now()); err != nil {
+			log.Warnw("Rotate jwt signing keys failed", "error", err)
+		}
+	}
+}
+
+// getJWKS - public keys verifying tokens, including the next key
+func getJWKS(c *gin.Context) {
+	if !asymmetricJWT() {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "Tokens are not signed with public keys.",
+		})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age="+jwksMaxAge)
+	c.JSON(http.StatusOK, jwtKeys.JWKS(time.Now()))
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/bits"
+	"minitube/models"
+	"minitube/store"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Brute-force protection of /login.
+// Failures are counted per account and per ip. After challengeAfter account
+// failures a login challenge is required, after accountLockAfter failures the
+// account is locked, and lock time doubles with every further failure.
+const (
+	failureWindow    = 24 * time.Hour
+	challengeAfter   = 3
+	accountLockAfter = 5
+	ipLockAfter      = 20
+	baseLockTime     = time.Minute
+	maxLockTime      = time.Hour
+	challengeTTL     = 5 * time.Minute
+)
+
+// login errors, returned by Authenticator as the response message
+var (
+	ErrLoginLocked       = errors.New("too many failed logins, try again later")
+	ErrChallengeRequired = errors.New("login challenge required")
+)
+
+// LoginChallenge - extra check required after too many failed logins,
+// e.g. proof of work or captcha.
+type LoginChallenge interface {
+	// New - create a challenge for client, returned in /login/challenge response
+	New(ctx context.Context) (gin.H, error)
+	// Verify - check the answer sent with login request
+	Verify(ctx context.Context, login *models.LoginModel) bool
+}
+
+var loginChallenge LoginChallenge = &powChallenge{
+	difficulty: func() int {
+		if d, err := strconv.Atoi(os.Getenv("LOGIN_POW_DIFFICULTY")); err == nil && d >= 0 && d <= 32 {
+			return d
+		}
+		return 20
+	}(),
+}
+
+// powChallenge - hashcash like proof of work, client must find a nonce that
+// sha256(challenge + nonce) has `difficulty` leading zero bits.
+type powChallenge struct {
+	difficulty int
+}
+
+func (p *powChallenge) New(ctx context.Context) (gin.H, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	challenge := hex.EncodeToString(b)
+	if err := store.SaveLoginChallenge(ctx, challenge, challengeTTL); err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"challenge":  challenge,
+		"difficulty": p.difficulty,
+		"algorithm":  "sha256",
+	}, nil
+}
+
+func (p *powChallenge) Verify(ctx context.Context, login *models.LoginModel) bool {
+	if login.Challenge == "" || !checkProofOfWork(login.Challenge, login.Nonce, p.difficulty) {
+		return false
+	}
+	ok, err := store.TakeLoginChallenge(ctx, login.Challenge)
+	return err == nil && ok
+}
+
+// checkProofOfWork - whether sha256(challenge + nonce) has difficulty leading zero bits
+func checkProofOfWork(challenge, nonce string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(challenge + nonce))
+	zeros := 0
+	for _, b := range sum {
+		if b == 0 {
+			zeros += 8
+			continue
+		}
+		zeros += bits.LeadingZeros8(b)
+		break
+	}
+	return zeros >= difficulty
+}
+
+// lockTime - exponential backoff, doubles for each failure over threshold
+func lockTime(failures, threshold int64) time.Duration {
+	d := baseLockTime
+	for i := threshold; i < failures && d < maxLockTime; i++ {
+		d *= 2
+	}
+	if d > maxLockTime {
+		d = maxLockTime
+	}
+	return d
+}
+
+func userSubject(id uint) string {
+	return "user:" + strconv.Itoa(int(id))
+}
+
+func ipSubject(ip string) string {
+	return "ip:" + ip
+}
+
+// checkLoginLock - refuse login when ip or account is locked
+func checkLoginLock(c *gin.Context, subject string) error {
+	locked, err := store.GetLoginLock(c.Request.Context(), subject)
+	if err != nil {
+		// don't lock everyone out when redis fails
+		return nil
+	}
+	if locked > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(locked.Seconds())+1))
+		return ErrLoginLocked
+	}
+	return nil
+}
+
+// checkLoginChallenge - require challenge when account has failed too many times
+func checkLoginChallenge(c *gin.Context, user *models.User, login *models.LoginModel) error {
+	failures, err := store.GetLoginFailures(c.Request.Context(), userSubject(user.ID))
+	if err != nil || failures < challengeAfter {
+		return nil
+	}
+	if !loginChallenge.Verify(c.Request.Context(), login) {
+		return ErrChallengeRequired
+	}
+	return nil
+}
+
+// recordLoginFailure - count failure for ip and account (if known), lock them when needed.
+// The ip is only taken from X-Forwarded-For of trusted proxies, or a client could pick a new one each try.
+func recordLoginFailure(c *gin.Context, user *models.User) {
+	ctx := c.Request.Context()
+	ip := c.ClientIP()
+
+	ipFailures, err := store.IncrLoginFailure(ctx, ipSubject(ip), failureWindow)
+	if err == nil && ipFailures >= ipLockAfter {
+		store.LockLogin(ctx, ipSubject(ip), lockTime(ipFailures, ipLockAfter))
+	}
+
+	if user == nil {
+		return
+	}
+	failures, err := store.IncrLoginFailure(ctx, userSubject(user.ID), failureWindow)
+	if err != nil || failures < accountLockAfter {
+		return
+	}
+	d := lockTime(failures, accountLockAfter)
+	if err := store.LockLogin(ctx, userSubject(user.ID), d); err != nil {
+		return
+	}
+	logger(c).Warnw("Account locked", "user", user, "failures", failures, "duration", d)
+	store.PushNotification(ctx, user.ID, &models.Notification{
+		Type: models.NotificationAccountLocked,
+		Message: "Your account has been locked for " + d.String() + " after " + strconv.FormatInt(failures, 10) +
+			" failed logins from " + ip + ". If it's not you, please change your password.",
+		TimeStamp: time.Now().Unix(),
+	})
+}
+
+func getLoginChallenge(c *gin.Context) {
+	resp, err := loginChallenge.New(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	resp["code"] = http.StatusOK
+	c.JSON(http.StatusOK, resp)
+}
+
+func getNotifications(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+
+	list, err := store.GetNotifications(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":          http.StatusOK,
+		"notifications": list,
+	})
+}
+
+func unlockUser(c *gin.Context) {
+	user, ok := targetUser(c)
+	if !ok {
+		return
+	}
+
+	err := store.ResetLoginFailures(c.Request.Context(), userSubject(user.ID))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	logger(c).Infow("Account unlocked by admin", "user", user)
+	audit(c, models.AuditAdminUnlock, user, nil, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "OK",
+	})
+}
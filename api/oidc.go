@@ -0,0 +1,311 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"minitube/models"
+	"minitube/store"
+	"minitube/utils"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Social login with OpenID Connect.
+// /oauth/:provider/login redirects user to sign in at provider, the provider redirects
+// back to /oauth/:provider/callback with a code, which is exchanged for an id token.
+// The provider's subject is linked to a user through an identity, a user is created
+// on first sign in. Providers are configured by env:
+//
+//	OIDC_PROVIDERS=google,gitlab
+//	OIDC_GOOGLE_ISSUER=https://accounts.google.com
+//	OIDC_GOOGLE_CLIENT_ID=...
+//	OIDC_GOOGLE_CLIENT_SECRET=...
+//	OIDC_GOOGLE_SCOPES=openid email profile (default)
+//	OIDC_REDIRECT_BASE=https://minitube.example.com
+const (
+	// oidcLoginTTL - how long user has to sign in at provider
+	oidcLoginTTL = 10 * time.Minute
+	// oidcStateCookieName - binds a sign in to the browser started it, against login csrf
+	oidcStateCookieName = "oidc_state"
+)
+
+// oidcProviders - configured providers by name
+var oidcProviders = func() map[string]*utils.OIDCProvider {
+	providers := make(map[string]*utils.OIDCProvider)
+	base := strings.TrimSuffix(os.Getenv("OIDC_REDIRECT_BASE"), "/")
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := &utils.OIDCProvider{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  base + "/oauth/" + name + "/callback",
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+			Client:       &http.Client{Timeout: 10 * time.Second},
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			log.Warnw("OIDC provider is not configured, skipped", "provider", name)
+			continue
+		}
+		providers[name] = provider
+	}
+	return providers
+}()
+
+func oidcProviderWithError(c *gin.Context) (*utils.OIDCProvider, bool) {
+	provider, ok := oidcProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "Login provider not exists.",
+		})
+	}
+	return provider, ok
+}
+
+func oidcLogin(c *gin.Context) {
+	provider, ok := oidcProviderWithError(c)
+	if !ok {
+		return
+	}
+
+	state, err := utils.RandomToken(24)
+	var nonce, verifier, challenge, uri string
+	if err == nil {
+		nonce, err = utils.RandomToken(24)
+	}
+	if err == nil {
+		verifier, challenge, err = utils.NewPKCEVerifier()
+	}
+	if err == nil {
+		login := &models.OIDCLogin{Provider: provider.Name, Verifier: verifier, Nonce: nonce}
+		err = store.SaveOIDCLogin(c.Request.Context(), state, login, oidcLoginTTL)
+	}
+	if err == nil {
+		uri, err = provider.AuthCodeURL(c.Request.Context(), state, nonce, challenge)
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	// lax, or it's not sent when provider redirects back
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookieName, state, int(oidcLoginTTL.Seconds()), "/oauth/", "", cookieSecure, true)
+	c.Redirect(http.StatusFound, uri)
+}
+
+func oidcCallback(c *gin.Context) {
+	provider, ok := oidcProviderWithError(c)
+	if !ok {
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		logger(c).Infow("OIDC login failed at provider", "provider", provider.Name, "error", e)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"code":    http.StatusUnauthorized,
+			"message": "Login canceled or failed.",
+		})
+		return
+	}
+
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookieName)
+	c.SetCookie(oidcStateCookieName, "", -1, "/oauth/", "", cookieSecure, true)
+	var login *models.OIDCLogin
+	err := store.ErrOIDCLoginNotExists
+	if state != "" && cookie == state {
+		login, err = store.TakeOIDCLogin(c.Request.Context(), state)
+	}
+	if err == nil && login.Provider != provider.Name {
+		err = store.ErrOIDCLoginNotExists
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrOIDCLoginNotExists) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "Login expired, please try again.",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), login.Verifier, login.Nonce)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"code":    http.StatusUnauthorized,
+			"message": "Login failed.",
+		})
+		return
+	}
+
+	user, err := store.GetUserByIdentity(c.Request.Context(), provider.Name, identity.Subject)
+	if errors.Is(err, store.ErrMySQLUserNotExists) {
+		user, err = createOIDCUser(c, provider.Name, identity)
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	if user.IsSuspended(time.Now()) {
+		err = suspendedError(user)
+		event := newAuditEvent(c, models.AuditLoginFailure, user, nil, gin.H{"reason": err.Error(), "provider": provider.Name})
+		saveAudit(c, event)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"code":    http.StatusUnauthorized,
+			"message": err.Error(),
+		})
+		return
+	}
+	// login in grace period restores the account
+	if user.IsDeletionPending() {
+		if err := cancelAccountDeletion(c, user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "Server Error",
+			})
+			return
+		}
+	}
+	event := newAuditEvent(c, models.AuditLoginSuccess, user, nil, gin.H{"provider": provider.Name})
+	event.ActorID, event.ActorUsername = &user.ID, user.Username
+	saveAudit(c, event)
+	logger(c).Debugw("User auth success", "user", user, "provider", provider.Name)
+
+	token, expire, err := authMiddleware.TokenGenerator(user)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	authMiddleware.SetCookie(c, token)
+	jwtTokenResponse(c, http.StatusOK, token, expire)
+}
+
+// createOIDCUser - create user signing in at provider the first time. The user has
+// no password until setting one, see changePassword. An existing user is never linked
+// by email, the provider may not own the email domain.
+func createOIDCUser(c *gin.Context, provider string, identity *utils.OIDCIdentity) (*models.User, error) {
+	ctx := c.Request.Context()
+	username, err := chooseUsername(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	user := models.NewUser(username, "")
+	// verified email is kept if it's not used by another user
+	if email := identity.Email; identity.EmailVerified && email != "" && len(email) <= 50 {
+		_, err := store.GetUserByEmail(ctx, email)
+		if errors.Is(err, store.ErrRedisUserNotExists) || errors.Is(err, store.ErrMySQLUserNotExists) {
+			user.Email = &email
+		}
+	}
+	link := &models.Identity{Provider: provider, Subject: identity.Subject}
+	if identity.Email != "" {
+		link.Email = &identity.Email
+	}
+	err = store.SaveUserWithIdentity(ctx, user, link)
+	if err != nil {
+		return nil, err
+	}
+	logger(c).Infow("User registered with OIDC", "user", user, "provider", provider)
+	event := newAuditEvent(c, models.AuditRegisterOIDC, user, nil, gin.H{"provider": provider})
+	event.ActorID, event.ActorUsername = &user.ID, user.Username
+	saveAudit(c, event)
+	return user, nil
+}
+
+// chooseUsername - a free username for identity, from its preferred username, email or name,
+// with random digits appended if it's taken.
+func chooseUsername(ctx context.Context, identity *utils.OIDCIdentity) (string, error) {
+	local := ""
+	if i := strings.IndexByte(identity.Email, '@'); i > 0 {
+		local = identity.Email[:i]
+	}
+	candidates := []string{identity.PreferredUsername, local, identity.Name, "user"}
+	for _, candidate := range candidates {
+		base := sanitizeUsername(candidate)
+		if base == "" {
+			continue
+		}
+		for i := 0; i < 5; i++ {
+			username := base
+			if i > 0 || base == "user" {
+				suffix := strconv.Itoa(1000 + rand.Intn(9000))
+				if len(base)+len(suffix) > 20 {
+					base = base[:20-len(suffix)]
+				}
+				username = base + suffix
+			}
+			free, err := usernameFree(ctx, username)
+			if err != nil {
+				return "", err
+			}
+			if free {
+				return username, nil
+			}
+		}
+	}
+	return "", errors.New("no free username")
+}
+
+// sanitizeUsername - letters and digits of s, at most 20
+func sanitizeUsername(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			b.WriteRune(r)
+		}
+	}
+	username := b.String()
+	if len(username) > 20 {
+		username = username[:20]
+	}
+	return username
+}
+
+// usernameFree - whether username is valid, not used and not reserved
+func usernameFree(ctx context.Context, username string) (bool, error) {
+	if !utils.CheckUsername(username) {
+		return false, nil
+	}
+	_, err := store.GetUserByUsername(ctx, username)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, store.ErrRedisUserNotExists) && !errors.Is(err, store.ErrMySQLUserNotExists) {
+		return false, err
+	}
+	reserved, err := store.IsUsernameReserved(ctx, username)
+	return !reserved, err
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"minitube/models"
+	"minitube/utils"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Breached and weak passwords.
+// Clients send pre-hashed passwords, so their strength is unknown to server. A client
+// may also send the plain password over https on register and password change, it's
+// checked then thrown away, and must be what the pre-hash, hex(sha256(plain)), is made
+// from, or a client could pass the check with one password and register another.
+// Clients not willing to do that check passwords themselves with /password/range/:prefix,
+// which never sees the password nor its full hash.
+
+// minPlainPasswordLength - plain passwords shorter are too weak
+const minPlainPasswordLength = 8
+
+// error codes of rejected plain passwords, in "error" of responses
+const (
+	errCodePasswordInsecure = "password_insecure_transport"
+	errCodePasswordMismatch = "password_mismatch"
+	errCodePasswordWeak     = "password_too_weak"
+	errCodePasswordBreached = "password_breached"
+)
+
+// passwordBlocklist - breached passwords from PASSWORD_BLOCKLIST_FILE, nil if not set
+var passwordBlocklist = func() *utils.PasswordBlocklist {
+	path := os.Getenv("PASSWORD_BLOCKLIST_FILE")
+	if path == "" {
+		return nil
+	}
+	blocklist, err := utils.LoadPasswordBlocklist(path)
+	if err != nil {
+		log.Fatal("Load password blocklist failed: ", err)
+	}
+	log.Infow("Password blocklist loaded", "file", path, "count", blocklist.Len())
+	return blocklist
+}()
+
+// checkPlainPassword - reject plain password if it's weak or breached, sent over plain
+// http, or isn't what the pre-hashed password is made from. It's ok if client doesn't send one.
+func checkPlainPassword(c *gin.Context, plain, password string) bool {
+	if plain == "" {
+		return true
+	}
+	reject := func(code, message string) bool {
+		logger(c).Infow("Plain password rejected", "reason", code)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": message,
+			"error":   code,
+		})
+		return false
+	}
+	// X-Forwarded-Proto is set by the tls terminating proxy in front of us, anyone else could fake it
+	if c.Request.TLS == nil && !(fromTrustedProxy(c) && c.GetHeader("X-Forwarded-Proto") == "https") {
+		return reject(errCodePasswordInsecure, "Plain password must be sent over https.")
+	}
+	sum := sha256.Sum256([]byte(plain))
+	if hex.EncodeToString(sum[:]) != strings.ToLower(password) {
+		return reject(errCodePasswordMismatch, "Plain password doesn't match password.")
+	}
+	if len([]rune(plain)) < minPlainPasswordLength {
+		return reject(errCodePasswordWeak, "Password is too short.")
+	}
+	if passwordBlocklist != nil && passwordBlocklist.Contains(plain) {
+		return reject(errCodePasswordBreached, "Password has appeared in a data breach, please choose another one.")
+	}
+	return true
+}
+
+// confirmPassword - check password confirming a sensitive action of user, response is written
+// on failure. Users signed up with OIDC have no password, they set one first, see changePassword.
+func confirmPassword(c *gin.Context, user *models.User, password string) bool {
+	if !user.HasPassword() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "Set a password first.",
+		})
+		return false
+	}
+	if err := passwords.Verify(user.Password, password); err != nil {
+		// a wrong password is the client's mistake, only log what's not
+		if !errors.Is(err, utils.ErrPasswordMismatch) {
+			c.Error(err)
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "Password is wrong",
+		})
+		return false
+	}
+	return true
+}
+
+// getPasswordRange - suffixes of breached password sha1 hashes starting with the 5 hex chars prefix
+func getPasswordRange(c *gin.Context) {
+	if passwordBlocklist == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "Password blocklist is not enabled.",
+		})
+		return
+	}
+	suffixes := passwordBlocklist.Range(c.Param("prefix"))
+	if suffixes == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "Prefix must be 5 hex chars.",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":     http.StatusOK,
+		"suffixes": suffixes,
+	})
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"minitube/models"
+	"minitube/store"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Privacy settings.
+// Users can hide their followers or followings from others, and pause or clear watch history,
+// which clears their watch sessions too.
+
+func getPrivacy(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "Bad Token.",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"privacy": models.GetPrivacyFromUser(user),
+	})
+}
+
+func updatePrivacy(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "Bad Token.",
+		})
+		return
+	}
+	req := new(models.ChangePrivacyModel)
+	if err := c.ShouldBind(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+
+	before := models.GetPrivacyFromUser(user)
+	after := req.Apply(*before)
+	err := store.UpdatePrivacy(c.Request.Context(), user, &after)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	audit(c, models.AuditPrivacyUpdate, user, before, after)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"privacy": after,
+	})
+}
+
+func clearHistory(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+
+	err := store.ClearWatchHistory(c.Request.Context(), id)
+	if err == nil {
+		_, err = store.DeleteWatchSessions(c.Request.Context(), id, 0)
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "OK",
+	})
+}
+
+func deleteHistory(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	deleted := false
+	// history and sessions of a channel that's gone are deleted with it
+	channel, err := store.GetUserByUsername(ctx, c.Param("username"))
+	if err == nil {
+		deleted, err = store.DeleteWatchHistory(ctx, id, channel.ID)
+		if err == nil {
+			var n int64
+			n, err = store.DeleteWatchSessions(ctx, id, channel.ID)
+			deleted = deleted || n > 0
+		}
+	} else if errors.Is(err, store.ErrRedisUserNotExists) || errors.Is(err, store.ErrMySQLUserNotExists) {
+		err = nil
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "History not exists.",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "OK",
+	})
+}
+
+// recordWatch - add channel to watch history of user id, unless history is paused
+func recordWatch(ctx context.Context, id uint, channelID uint) {
+	user, err := store.GetUserByID(ctx, id)
+	if err != nil || user.HistoryPaused {
+		return
+	}
+	store.UpdateWatchHistory(ctx, id, channelID)
+}
+
+// followsHidden - whether user hides followers or followings from the viewer
+func followsHidden(user *models.User, viewer string, followers bool) bool {
+	if user.Username == viewer {
+		return false
+	}
+	if followers {
+		return user.HideFollowers
+	}
+	return user.HideFollowings
+}
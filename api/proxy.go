@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trustedProxies - TRUSTED_PROXIES, comma separated IPs or CIDRs of reverse proxies in front
+// of minitube. Only their X-Forwarded-For is believed, by default no one's, so a client can't
+// pick its own IP to dodge per-IP rate limits and login lockouts.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// trustedProxyNets - trustedProxies parsed, invalid ones are refused by Router at startup
+var trustedProxyNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, proxy := range trustedProxies() {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets
+}()
+
+// fromTrustedProxy - whether request comes right from one of TRUSTED_PROXIES,
+// so the X-Forwarded-* headers it sets can be believed
+func fromTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range trustedProxyNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
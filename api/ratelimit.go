@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"minitube/middleware"
+	"minitube/store"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redisLimiter - middleware.Limiter shared by all instances through redis
+func redisLimiter(ctx context.Context, key string, limit middleware.Limit) (bool, time.Duration, error) {
+	return store.AllowRate(ctx, key, limit.Rate, limit.Burst, limit.Period)
+}
+
+// rateLimit - limit requests of route group or handler by name
+func rateLimit(name string, limit middleware.Limit, keyFunc middleware.KeyFunc) gin.HandlerFunc {
+	if limit.Rate == 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return middleware.RateLimit(redisLimiter, &middleware.RateLimitConfig{
+		Name:    name,
+		Limit:   limit,
+		KeyFunc: keyFunc,
+	})
+}
+
+// perMinuteFromEnv - requests per minute from env, e.g. RATE_LIMIT_LOGIN=10,
+// 0 disables the limit.
+func perMinuteFromEnv(key string, def int) middleware.Limit {
+	rate, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || rate < 0 {
+		rate = def
+	}
+	return middleware.PerMinute(rate)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"minitube/middleware"
+	"minitube/models"
+	"minitube/store"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminUserIDs - ids of users granted admin role at startup, from ADMIN_USER_IDS
+// (comma separated). Used to bootstrap the first admins, roles are kept in MySQL.
+// IDs are used rather than usernames since usernames can be picked by anyone.
+var adminUserIDs = func() []uint {
+	var ids []uint
+	for _, s := range strings.Split(os.Getenv("ADMIN_USER_IDS"), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(s, 10, 0)
+		if err != nil {
+			log.Warnw("Invalid id in ADMIN_USER_IDS", "id", s)
+			continue
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids
+}()
+
+// roleModel - assign role request model
+type roleModel struct {
+	Role string `form:"role" json:"role" binding:"required,max=20"`
+}
+
+// requirePermissions - abort with 403 unless token grants all permissions, use after authMiddleware
+func requirePermissions(permissions ...string) gin.HandlerFunc {
+	return middleware.Authorize(middleware.RequirePermissions(permissions...))
+}
+
+// hasPermission - whether user's roles grant the permission
+func hasPermission(user *models.User, permission string) bool {
+	for _, perm := range user.PermissionNames() {
+		if perm == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// BootstrapAdmins - grant admin role to users listed in ADMIN_USER_IDS, run once at startup.
+func BootstrapAdmins(ctx context.Context) {
+	for _, id := range adminUserIDs {
+		if err := bootstrapAdmin(ctx, id); err != nil {
+			log.Warnw("Bootstrap admin failed", "id", id, "error", err)
+		}
+	}
+}
+
+func bootstrapAdmin(ctx context.Context, id uint) error {
+	user, err := store.GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	// skip admins so their sessions aren't revoked on every restart
+	for _, role := range user.RoleNames() {
+		if role == models.RoleAdmin {
+			return nil
+		}
+	}
+	if err := store.AssignRole(ctx, user, models.RoleAdmin); err != nil {
+		return err
+	}
+	log.Infow("Admin role granted from ADMIN_USER_IDS", "user", user)
+	return nil
+}
+
+func assignRole(c *gin.Context) {
+	req := new(roleModel)
+	if err := c.ShouldBind(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+	changeRole(c, req.Role, true)
+}
+
+func revokeRole(c *gin.Context) {
+	changeRole(c, c.Param("role"), false)
+}
+
+func changeRole(c *gin.Context, role string, grant bool) {
+	user, ok := targetUser(c)
+	if !ok {
+		return
+	}
+
+	var err error
+	if grant {
+		err = store.AssignRole(c.Request.Context(), user, role)
+	} else {
+		err = store.RevokeRole(c.Request.Context(), user, role)
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrRoleNotExists) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "Role not exists.",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	logger(c).Infow("User role changed", "user", user, "role", role, "grant", grant)
+	action := models.AuditAdminRoleAssign
+	if !grant {
+		action = models.AuditAdminRoleRevoke
+	}
+	audit(c, action, user, nil, gin.H{"role": role})
+	c.JSON(http.StatusOK, gin.H{
+		"code":  http.StatusOK,
+		"roles": user.RoleNames(),
+	})
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"errors"
+	"minitube/models"
+	"minitube/store"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recommendDefaultNum - channels recommended by default
+const recommendDefaultNum = 12
+
+// getRecommendations - channels recommended to current user, anonymous users get popular living channels
+func getRecommendations(c *gin.Context) {
+	req := new(models.RecommendQueryModel)
+	if err := c.ShouldBindQuery(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+	if req.Num == 0 {
+		req.Num = recommendDefaultNum
+	}
+
+	ctx := c.Request.Context()
+	var viewer *models.User
+	if id, ok := getUserID(c); ok {
+		user, err := store.GetUserByID(ctx, id)
+		if err == nil {
+			viewer = user
+		} else if !errors.Is(err, store.ErrRedisUserNotExists) && !errors.Is(err, store.ErrMySQLUserNotExists) {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "Server Error",
+			})
+			return
+		}
+	}
+
+	recommendations, err := store.GetRecommendations(ctx, viewer, req.Num)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":            http.StatusOK,
+		"recommendations": recommendations,
+	})
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"errors"
+	"minitube/models"
+	"minitube/store"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func createReport(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+
+	req := new(models.ReportModel)
+	if err := c.ShouldBind(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+
+	target, ok := userByUsername(c, req.Target)
+	if !ok {
+		return
+	}
+	if target.ID == id {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "Can't report yourself.",
+		})
+		return
+	}
+
+	report := &models.Report{
+		ReporterID:     id,
+		TargetUserID:   target.ID,
+		TargetUsername: target.Username,
+		TargetType:     req.TargetType,
+		Category:       req.Category,
+		Detail:         req.Detail,
+	}
+	if req.TargetType == models.ReportTargetChat {
+		report.MessageID = req.MessageID
+	}
+	report, created, err := store.CreateReport(c.Request.Context(), report)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	message := "OK"
+	if !created {
+		message = "Already reported."
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":      http.StatusOK,
+		"message":   message,
+		"report_id": report.ID,
+	})
+}
+
+// auditReport - record action on report, target is the reported user
+func auditReport(c *gin.Context, action string, report *models.Report, after gin.H) {
+	event := newAuditEvent(c, action, nil, nil, after)
+	event.TargetUserID = &report.TargetUserID
+	event.Target = "report:" + strconv.Itoa(int(report.ID))
+	saveAudit(c, event)
+}
+
+// targetReport - get the report of :id, response is written on failure
+func targetReport(c *gin.Context) (*models.Report, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "param not correct.",
+		})
+		return nil, false
+	}
+	report, err := store.GetReport(c.Request.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, store.ErrReportNotExists) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "Report not exists.",
+			})
+			return nil, false
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return nil, false
+	}
+	return report, true
+}
+
+// reportResponse - respond with the report, or server error if err isn't nil
+func reportResponse(c *gin.Context, report *models.Report, err error) {
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":   http.StatusOK,
+		"report": report,
+	})
+}
+
+func listReports(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+	req := new(models.ReportQueryModel)
+	if err := c.ShouldBindQuery(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+	page, size := pageOf(req.Page, req.Size)
+
+	filter := &store.ReportFilter{Status: req.Status}
+	if req.Assignee == "me" {
+		filter.AssigneeID = id
+	} else if req.Assignee != "" {
+		assignee, ok := userByUsername(c, req.Assignee)
+		if !ok {
+			return
+		}
+		filter.AssigneeID = assignee.ID
+	}
+	if req.Target != "" {
+		target, ok := userByUsername(c, req.Target)
+		if !ok {
+			return
+		}
+		filter.TargetUserID = target.ID
+	}
+
+	reports, total, err := store.ListReports(c.Request.Context(), filter, page, size)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"total":   total,
+		"reports": reports,
+	})
+}
+
+func getReport(c *gin.Context) {
+	report, ok := targetReport(c)
+	if !ok {
+		return
+	}
+	reportResponse(c, report, nil)
+}
+
+func assignReport(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+	req := new(models.AssignReportModel)
+	if err := c.ShouldBind(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+	report, ok := targetReport(c)
+	if !ok {
+		return
+	}
+
+	if req.Assignee != "" {
+		assignee, ok := userByUsername(c, req.Assignee)
+		if !ok {
+			return
+		}
+		if !hasPermission(assignee, models.PermReportModerate) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "Assignee can't moderate reports.",
+			})
+			return
+		}
+		id = assignee.ID
+	}
+
+	err := store.UpdateReport(c.Request.Context(), report, map[string]interface{}{"assignee_id": id})
+	logger(c).Infow("Report assigned", "report", report.ID, "assignee", id, "error", err)
+	if err == nil {
+		auditReport(c, models.AuditAdminReportAssign, report, gin.H{"assignee_id": id})
+	}
+	reportResponse(c, report, err)
+}
+
+func resolveReport(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+	req := new(models.ResolveReportModel)
+	if err := c.ShouldBind(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+	if req.Suspend && (req.Status != models.ReportActioned || (req.Until != nil && req.Until.Before(time.Now()))) {
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+	report, ok := targetReport(c)
+	if !ok {
+		return
+	}
+	if report.Status != models.ReportOpen {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "Report has been resolved.",
+		})
+		return
+	}
+
+	now := time.Now()
+	fields := map[string]interface{}{
+		"status":      req.Status,
+		"note":        req.Note,
+		"resolved_by": id,
+		"resolved_at": &now,
+	}
+	if report.AssigneeID == nil {
+		fields["assignee_id"] = id
+	}
+	if req.Suspend {
+		target, err := store.GetUserByID(c.Request.Context(), report.TargetUserID)
+		if err == nil && !canManage(c, target) {
+			forbidManage(c)
+			return
+		}
+		if err == nil {
+			err = suspend(c, target, req.Reason, req.Until, id)
+		}
+		if err != nil {
+			reportResponse(c, report, err)
+			return
+		}
+		fields["suspended_at"] = target.SuspendedAt
+	}
+
+	err := store.UpdateReport(c.Request.Context(), report, fields)
+	logger(c).Infow("Report resolved", "report", report.ID, "status", req.Status, "error", err)
+	if err == nil {
+		auditReport(c, models.AuditAdminReportResolve, report, gin.H{"status": req.Status, "suspend": req.Suspend})
+	}
+	reportResponse(c, report, err)
+}
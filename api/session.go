@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	jwt "minitube/middleware"
+	"minitube/models"
+	"minitube/store"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenVersionKey - claim of user's token version when token issued,
+// tokens are revoked by bumping the version stored with user.
+const tokenVersionKey = "tv"
+
+// currentUserKey - where validateSession keeps the user loaded from store
+const currentUserKey = "current_user"
+
+// session errors, returned as the 401 response message
+var (
+	ErrSessionRevoked   = errors.New("session has been revoked, please login again")
+	ErrAccountSuspended = errors.New("account has been suspended")
+)
+
+// passwordResetPaths - routes still allowed when user must reset password
+var passwordResetPaths = map[string]bool{
+	"/user/me":       true,
+	"/user/password": true,
+}
+
+// validateSession - reject tokens of deleted users or issued before sessions revoked
+func validateSession(claims jwt.MapClaims, c *gin.Context) error {
+	id, ok := claims["id"].(float64)
+	if !ok {
+		return ErrSessionRevoked
+	}
+	user, err := store.GetUserByID(c.Request.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, store.ErrRedisUserNotExists) || errors.Is(err, store.ErrMySQLUserNotExists) {
+			return ErrSessionRevoked
+		}
+		c.Error(err)
+		return err
+	}
+	version, _ := claims[tokenVersionKey].(float64)
+	if uint(version) != user.TokenVersion {
+		return ErrSessionRevoked
+	}
+	// suspension may be set without revoking tokens, e.g. directly in database
+	if user.IsSuspended(time.Now()) {
+		return suspendedError(user)
+	}
+	c.Set(currentUserKey, user)
+	return nil
+}
+
+// suspendedError - tell user why and until when the account is suspended
+func suspendedError(user *models.User) error {
+	until := "permanently"
+	if user.SuspendedUntil != nil {
+		until = "until " + user.SuspendedUntil.UTC().Format(time.RFC3339)
+	}
+	reason := ""
+	if user.SuspendReason != nil {
+		reason = ", reason: " + *user.SuspendReason
+	}
+	return fmt.Errorf("%w %v%v", ErrAccountSuspended, until, reason)
+}
+
+// currentUser - user of the token as loaded by validateSession, nil if not authenticated
+func currentUser(c *gin.Context) *models.User {
+	v, _ := c.Get(currentUserKey)
+	user, _ := v.(*models.User)
+	return user
+}
+
+// mustResetPassword - whether user has to reset password before accessing the route
+func mustResetPassword(c *gin.Context) bool {
+	user := currentUser(c)
+	return user != nil && user.PasswordResetRequired && !passwordResetPaths[c.FullPath()]
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"errors"
+	"minitube/models"
+	"minitube/store"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Username change.
+// Usernames can be changed once per cooldown. The old username keeps
+// redirecting to the user, and nobody else can take it, for the reserve period.
+var (
+	usernameChangeCooldown = durationFromEnv("USERNAME_CHANGE_COOLDOWN", 30*24*time.Hour)
+	usernameReservePeriod  = durationFromEnv("USERNAME_RESERVE_PERIOD", 90*24*time.Hour)
+)
+
+func changeUsername(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+
+	req := new(models.ChangeUsernameModel)
+	if err := c.ShouldBind(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+
+	user, err := store.GetUserByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "User not exists",
+		})
+		return
+	}
+
+	if !confirmPassword(c, user, req.Password) {
+		return
+	}
+
+	if req.Username == user.Username {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "Username not changed.",
+		})
+		return
+	}
+	if user.UsernameChangedAt != nil {
+		if next := user.UsernameChangedAt.Add(usernameChangeCooldown); time.Now().Before(next) {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"code":    http.StatusTooManyRequests,
+				"message": "Username can be changed again after " + next.UTC().Format(time.RFC3339) + ".",
+			})
+			return
+		}
+	}
+	// the room is named after username in live backend
+	living, err := store.GetUserIsLiving(c.Request.Context(), user.Username)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	if living {
+		c.JSON(http.StatusConflict, gin.H{
+			"code":    http.StatusConflict,
+			"message": "Can't change username while living.",
+		})
+		return
+	}
+
+	old := user.Username
+	err = store.ChangeUsername(c.Request.Context(), user, req.Username, time.Now().Add(usernameReservePeriod))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrUsernameTaken):
+			c.JSON(http.StatusConflict, gin.H{
+				"code":    http.StatusConflict,
+				"message": "username already exists",
+			})
+		case errors.Is(err, store.ErrUsernameReserved):
+			c.JSON(http.StatusConflict, gin.H{
+				"code":    http.StatusConflict,
+				"message": "Username is reserved.",
+			})
+		default:
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "Server Error",
+			})
+		}
+		return
+	}
+
+	// the old room's stream key must not publish to the new name
+	if err := deleteStreamKeyFromLive(c.Request.Context(), old); err != nil {
+		c.Error(err)
+	}
+	logger(c).Infow("User changed username", "user", user, "old", old)
+	audit(c, models.AuditUsernameChange, user, gin.H{"username": old}, gin.H{"username": user.Username})
+
+	// old tokens carry the old username and are revoked, issue a new one
+	token, expire, err := authMiddleware.TokenGenerator(user)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	authMiddleware.SetCookie(c, token)
+	jwtTokenResponse(c, http.StatusOK, token, expire)
+}
+
+// usernameRedirect - current username of user who used username before, call it
+// only when username is not in use
+func usernameRedirect(c *gin.Context, username string) (string, bool) {
+	id, err := store.GetUsernameRedirect(c.Request.Context(), username)
+	if err != nil {
+		return "", false
+	}
+	user, err := store.GetUserByID(c.Request.Context(), id)
+	if err != nil {
+		return "", false
+	}
+	return user.Username, true
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"errors"
+	"minitube/models"
+	"minitube/store"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Watch sessions.
+// The player sends a heartbeat about every 30 seconds while playing a broadcast. Heartbeats
+// of the same broadcast extend the viewer's session, and a gap longer than watchSessionGap
+// starts a new one. Sessions are kept in MySQL for long-term history and the time watched,
+// and the last position of a session lets the viewer resume the broadcast.
+const (
+	// watchSessionGap - heartbeats further apart than it are in different sessions
+	watchSessionGap = 2 * time.Minute
+	// watchStatsDays - days of time watched by default
+	watchStatsDays = 30
+)
+
+// watchHeartbeat - record current user is watching the broadcast of username
+func watchHeartbeat(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "Bad Token.",
+		})
+		return
+	}
+	req := new(models.WatchHeartbeatModel)
+	if err := c.ShouldBind(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+	channel, ok := getChannelWithError(c, user)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	start, err := store.GetLivingTime(ctx, channel.Username)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+	if start == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "User is not living.",
+		})
+		return
+	}
+	if user.HistoryPaused {
+		c.JSON(http.StatusOK, gin.H{
+			"code":     http.StatusOK,
+			"recorded": false,
+		})
+		return
+	}
+
+	now := time.Now()
+	session, err := store.GetLastWatchSession(ctx, user.ID, channel.ID)
+	if err == nil && session.Continues(*start, now, watchSessionGap) {
+		session.Heartbeat(now, req.Position)
+		err = store.UpdateWatchSession(ctx, session)
+	} else if err == nil || errors.Is(err, store.ErrWatchSessionNotExists) {
+		session = &models.WatchSession{
+			UserID:         user.ID,
+			ChannelID:      channel.ID,
+			BroadcastStart: *start,
+			StartedAt:      now,
+			EndedAt:        now,
+			Position:       req.Position,
+		}
+		err = store.SaveWatchSession(ctx, session)
+		if err == nil {
+			err = store.UpdateWatchHistory(ctx, user.ID, channel.ID)
+		}
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":     http.StatusOK,
+		"recorded": true,
+		"session":  models.NewWatchSessionInfo(session, channel.Username),
+	})
+}
+
+// getWatchResume - current user's last session on username, and whether it can be
+// resumed from its position, it can while its broadcast is still living
+func getWatchResume(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "Bad Token.",
+		})
+		return
+	}
+	channel, ok := getChannelWithError(c, user)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	session, err := store.GetLastWatchSession(ctx, user.ID, channel.ID)
+	var start *time.Time
+	if err == nil {
+		start, err = store.GetLivingTime(ctx, channel.Username)
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrWatchSessionNotExists) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    http.StatusNotFound,
+				"message": "History not exists.",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":      http.StatusOK,
+		"resumable": start != nil && start.Equal(session.BroadcastStart),
+		"session":   models.NewWatchSessionInfo(session, channel.Username),
+	})
+}
+
+// getWatchSessions - current user's watch sessions, newest first
+func getWatchSessions(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+	req := new(models.WatchSessionQueryModel)
+	if err := c.ShouldBindQuery(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+	page, size := pageOf(req.Page, req.Size)
+
+	sessions, total, err := store.ListWatchSessions(c.Request.Context(), id, page, size)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":     http.StatusOK,
+		"total":    total,
+		"page":     page,
+		"size":     size,
+		"sessions": sessions,
+	})
+}
+
+// getWatchStats - time current user watched per day in the last days, in seconds
+func getWatchStats(c *gin.Context) {
+	id, ok := getUserIDWithError(c)
+	if !ok {
+		return
+	}
+	req := new(models.WatchStatsQueryModel)
+	if err := c.ShouldBindQuery(req); err != nil {
+		logger(c).Debug(err)
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"code":    http.StatusNotAcceptable,
+			"message": "invalid felid",
+		})
+		return
+	}
+	if req.Days == 0 {
+		req.Days = watchStatsDays
+	}
+
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day()-req.Days+1, 0, 0, 0, 0, now.Location())
+	days, err := store.GetWatchStats(c.Request.Context(), id, since)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return
+	}
+
+	var duration uint
+	for _, day := range days {
+		duration += day.Duration
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":     http.StatusOK,
+		"since":    since,
+		"duration": duration,
+		"days":     days,
+	})
+}
+
+// getChannelWithError - user of the username param, respond if it's not found or blocks user
+func getChannelWithError(c *gin.Context, user *models.User) (*models.User, bool) {
+	channel, err := store.GetUserByUsername(c.Request.Context(), c.Param("username"))
+	if err != nil {
+		if errors.Is(err, store.ErrRedisUserNotExists) || errors.Is(err, store.ErrMySQLUserNotExists) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "User not exists.",
+			})
+			return nil, false
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "Server Error",
+		})
+		return nil, false
+	}
+	if blockedWithError(c, user.Username, channel.Username) {
+		return nil, false
+	}
+	return channel, true
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"minitube/api"
 	"minitube/store"
 	"minitube/utils"
@@ -11,7 +12,15 @@ var log = utils.Sugar
 func main() {
 
 	defer log.Sync()
+	defer utils.ShutdownTracer()
 	defer store.CloseAll()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api.BootstrapAdmins(ctx)
+	go api.RunAccountPurge(ctx)
+	go api.RunJWTKeyRotation(ctx)
+	go api.RunLastLiveTracker(ctx)
+
 	api.Router.Run(":80")
 }
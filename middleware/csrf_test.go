@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRF(t *testing.T) {
+	require := require.New(t)
+
+	secret := []byte("minitube")
+	router := gin.New()
+	router.Use(CSRF(&CSRFConfig{Secret: secret, SessionCookie: "token", ExemptPaths: []string{"/login"}}))
+	ok := func(c *gin.Context) { c.String(http.StatusOK, "OK") }
+	router.GET("/user/me", ok)
+	router.POST("/user/password", ok)
+	router.POST("/login", ok)
+
+	do := func(method, uri string, cookie bool, header string, auth bool) int {
+		req := httptest.NewRequest(method, uri, nil)
+		if cookie {
+			req.AddCookie(&http.Cookie{Name: "token", Value: "session"})
+		}
+		if header != "" {
+			req.Header.Set("X-CSRF-Token", header)
+		}
+		if auth {
+			req.Header.Set("Authorization", "MiniTube session")
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	valid := CSRFToken(secret, "session")
+
+	require.Equal(http.StatusForbidden, do("POST", "/user/password", true, "", false), "Cookie without csrf token should be refused.")
+	require.Equal(http.StatusForbidden, do("POST", "/user/password", true, CSRFToken(secret, "other"), false), "Token of another session should be refused.")
+	require.Equal(http.StatusOK, do("POST", "/user/password", true, valid, false))
+	require.Equal(http.StatusOK, do("POST", "/user/password", true, "", true), "Authorization header isn't checked.")
+	require.Equal(http.StatusOK, do("POST", "/user/password", false, "", false), "No session cookie, nothing to forge.")
+	require.Equal(http.StatusOK, do("GET", "/user/me", true, "", false), "Safe method isn't checked.")
+	require.Equal(http.StatusOK, do("POST", "/login", true, "", false), "Exempt path isn't checked.")
+}
@@ -39,6 +39,11 @@ type GinJWTMiddleware struct {
 	// all other key settings
 	KeyFunc func(token *jwt.Token) (interface{}, error)
 
+	// Callback to retrieve the key signing new tokens, its signing method and id, which
+	// is set as the "kid" header. Use it with KeyFunc to rotate keys.
+	// Optional, tokens are signed with Key or the private key of SigningAlgorithm if not set.
+	SigningKeyFunc func() (kid string, method jwt.SigningMethod, key interface{}, err error)
+
 	// Duration that a jwt token is valid. Optional, defaults to one hour.
 	Timeout time.Duration
 
@@ -58,6 +63,21 @@ type GinJWTMiddleware struct {
 	// Optional, default to success.
 	Authorizator func(data interface{}, c *gin.Context) bool
 
+	// Callback function that validates claims of a well formed, unexpired token, e.g. check
+	// the session has not been revoked. Called before IdentityHandler and on refresh.
+	// Must return nil on success, the error is sent as 401 message on failure.
+	// Optional, default to success.
+	ClaimsValidator func(claims MapClaims, c *gin.Context) error
+
+	// Callback function that authenticates an API token, i.e. a token found by TokenLookup
+	// starting with APITokenPrefix, and returns claims of it as if they were of a jwt. The
+	// claims go through ClaimsValidator, IdentityHandler and Authorizator as well.
+	// Optional, tokens are always parsed as jwt if not set.
+	APITokenAuthenticator func(token string, c *gin.Context) (MapClaims, error)
+
+	// APITokenPrefix tells API tokens from jwts, which never start with it, e.g. "mtp_".
+	APITokenPrefix string
+
 	// Callback function that will be called during login.
 	// Using this function it is possible to add additional payload data to the webtoken.
 	// The data is then made available during requests via c.Get("JWT_PAYLOAD").
@@ -436,6 +456,13 @@ func (mw *GinJWTMiddleware) middlewareImpl(c *gin.Context) {
 		return
 	}
 
+	if mw.ClaimsValidator != nil {
+		if err := mw.ClaimsValidator(claims, c); err != nil {
+			mw.unauthorized(c, http.StatusUnauthorized, mw.HTTPStatusMessageFunc(err, c))
+			return
+		}
+	}
+
 	c.Set("JWT_PAYLOAD", claims)
 	identity := mw.IdentityHandler(c)
 
@@ -451,8 +478,14 @@ func (mw *GinJWTMiddleware) middlewareImpl(c *gin.Context) {
 	c.Next()
 }
 
-// GetClaimsFromJWT get claims from JWT token
+// GetClaimsFromJWT get claims from JWT token, or from API token if it's one
 func (mw *GinJWTMiddleware) GetClaimsFromJWT(c *gin.Context) (MapClaims, error) {
+	if mw.APITokenAuthenticator != nil && mw.APITokenPrefix != "" {
+		if token, err := mw.lookupToken(c); err == nil && strings.HasPrefix(token, mw.APITokenPrefix) {
+			return mw.APITokenAuthenticator(token, c)
+		}
+	}
+
 	token, err := mw.ParseToken(c)
 	if err != nil {
 		return nil, err
@@ -506,7 +539,13 @@ func (mw *GinJWTMiddleware) LoginHandler(c *gin.Context) {
 		return
 	}
 
-	// set cookie
+	mw.SetCookie(c, tokenString)
+
+	mw.LoginResponse(c, http.StatusOK, tokenString, expire)
+}
+
+// SetCookie sets the jwt cookie (if SendCookie), e.g. for a token from TokenGenerator
+func (mw *GinJWTMiddleware) SetCookie(c *gin.Context, token string) {
 	if mw.SendCookie {
 		expireCookie := mw.TimeFunc().Add(mw.CookieMaxAge)
 		maxage := int(expireCookie.Unix() - mw.TimeFunc().Unix())
@@ -517,7 +556,7 @@ func (mw *GinJWTMiddleware) LoginHandler(c *gin.Context) {
 
 		c.SetCookie(
 			mw.CookieName,
-			tokenString,
+			token,
 			maxage,
 			"/",
 			mw.CookieDomain,
@@ -525,8 +564,6 @@ func (mw *GinJWTMiddleware) LoginHandler(c *gin.Context) {
 			mw.CookieHTTPOnly,
 		)
 	}
-
-	mw.LoginResponse(c, http.StatusOK, tokenString, expire)
 }
 
 // LogoutHandler can be used by clients to remove the jwt cookie (if set)
@@ -554,6 +591,16 @@ func (mw *GinJWTMiddleware) LogoutHandler(c *gin.Context) {
 func (mw *GinJWTMiddleware) signedString(token *jwt.Token) (string, error) {
 	var tokenString string
 	var err error
+	if mw.SigningKeyFunc != nil {
+		kid, method, key, err := mw.SigningKeyFunc()
+		if err != nil {
+			return "", err
+		}
+		token.Method = method
+		token.Header["alg"] = method.Alg()
+		token.Header["kid"] = kid
+		return token.SignedString(key)
+	}
 	if mw.usingPublicKeyAlgo() {
 		tokenString, err = token.SignedString(mw.privKey)
 	} else {
@@ -582,6 +629,12 @@ func (mw *GinJWTMiddleware) RefreshToken(c *gin.Context) (string, time.Time, err
 		return "", time.Now(), err
 	}
 
+	if mw.ClaimsValidator != nil {
+		if err := mw.ClaimsValidator(MapClaims(claims), c); err != nil {
+			return "", time.Now(), err
+		}
+	}
+
 	// Create the token
 	newToken := jwt.New(jwt.GetSigningMethod(mw.SigningAlgorithm))
 	newClaims := newToken.Claims.(jwt.MapClaims)
@@ -598,25 +651,7 @@ func (mw *GinJWTMiddleware) RefreshToken(c *gin.Context) (string, time.Time, err
 		return "", time.Now(), err
 	}
 
-	// set cookie
-	if mw.SendCookie {
-		expireCookie := mw.TimeFunc().Add(mw.CookieMaxAge)
-		maxage := int(expireCookie.Unix() - time.Now().Unix())
-
-		if mw.CookieSameSite != 0 {
-			c.SetSameSite(mw.CookieSameSite)
-		}
-
-		c.SetCookie(
-			mw.CookieName,
-			tokenString,
-			maxage,
-			"/",
-			mw.CookieDomain,
-			mw.SecureCookie,
-			mw.CookieHTTPOnly,
-		)
-	}
+	mw.SetCookie(c, tokenString)
 
 	return tokenString, expire, nil
 }
@@ -716,6 +751,32 @@ func (mw *GinJWTMiddleware) jwtFromParam(c *gin.Context, key string) (string, er
 
 // ParseToken parse jwt token from gin context
 func (mw *GinJWTMiddleware) ParseToken(c *gin.Context) (*jwt.Token, error) {
+	token, err := mw.lookupToken(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if mw.KeyFunc != nil {
+		return jwt.Parse(token, mw.KeyFunc)
+	}
+
+	return jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		if jwt.GetSigningMethod(mw.SigningAlgorithm) != t.Method {
+			return nil, ErrInvalidSigningAlgorithm
+		}
+		if mw.usingPublicKeyAlgo() {
+			return mw.pubKey, nil
+		}
+
+		// save token string if vaild
+		c.Set("JWT_TOKEN", token)
+
+		return mw.Key, nil
+	})
+}
+
+// lookupToken find token string in places of TokenLookup
+func (mw *GinJWTMiddleware) lookupToken(c *gin.Context) (string, error) {
 	var token string
 	var err error
 
@@ -740,26 +801,9 @@ func (mw *GinJWTMiddleware) ParseToken(c *gin.Context) (*jwt.Token, error) {
 	}
 
 	if err != nil {
-		return nil, err
-	}
-
-	if mw.KeyFunc != nil {
-		return jwt.Parse(token, mw.KeyFunc)
+		return "", err
 	}
-
-	return jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		if jwt.GetSigningMethod(mw.SigningAlgorithm) != t.Method {
-			return nil, ErrInvalidSigningAlgorithm
-		}
-		if mw.usingPublicKeyAlgo() {
-			return mw.pubKey, nil
-		}
-
-		// save token string if vaild
-		c.Set("JWT_TOKEN", token)
-
-		return mw.Key, nil
-	})
+	return token, nil
 }
 
 // ParseTokenString parse jwt token string
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigningKeyFunc(t *testing.T) {
+	require := require.New(t)
+
+	keys := make(map[string]ed25519.PrivateKey)
+	current := ""
+	rotate := func(kid string) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(err)
+		keys[kid], current = key, kid
+	}
+	rotate("k1")
+
+	mw, err := New(&GinJWTMiddleware{
+		Realm: "test",
+		SigningKeyFunc: func() (string, jwt.SigningMethod, interface{}, error) {
+			return current, jwt.SigningMethodEdDSA, keys[current], nil
+		},
+		KeyFunc: func(t *jwt.Token) (interface{}, error) {
+			key, ok := keys[t.Header["kid"].(string)]
+			if !ok || t.Method != jwt.SigningMethodEdDSA {
+				return nil, ErrInvalidSigningAlgorithm
+			}
+			return key.Public(), nil
+		},
+		Authenticator: func(c *gin.Context) (interface{}, error) { return nil, ErrFailedAuthentication },
+	})
+	require.NoError(err)
+
+	old, _, err := mw.TokenGenerator(nil)
+	require.NoError(err)
+	rotate("k2")
+	token, _, err := mw.TokenGenerator(nil)
+	require.NoError(err)
+
+	for kid, signed := range map[string]string{"k1": old, "k2": token} {
+		parsed, err := mw.ParseTokenString(signed)
+		require.NoError(err, "Tokens of old keys still verify.")
+		require.Equal(kid, parsed.Header["kid"])
+		require.Equal("EdDSA", parsed.Header["alg"])
+	}
+
+	delete(keys, "k1")
+	_, err = mw.ParseTokenString(old)
+	require.Error(err, "Tokens of removed keys don't verify.")
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// gintrace let gin create a server span for every request,
+// the span is stored in c.Request's context so handlers can pass it down.
+
+// Tracing returns a gin.HandlerFunc (middleware) that starts a span for each request.
+//
+// W3C trace context in request headers is extracted, so the span joins the caller's trace.
+func Tracing(tp trace.TracerProvider, service string) gin.HandlerFunc {
+	tracer := tp.Tracer(service)
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		name := c.FullPath()
+		if name == "" {
+			name = "NoRoute"
+		}
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", c.Request.Method),
+				attribute.String("http.route", c.FullPath()),
+				attribute.String("http.target", c.Request.URL.Path),
+				attribute.String("http.client_ip", c.ClientIP()),
+			),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.status_code", status))
+		if len(c.Errors) > 0 {
+			span.SetAttributes(attribute.String("gin.errors", c.Errors.String()))
+		}
+		if status >= 500 {
+			span.SetStatus(codes.Error, fmt.Sprint(status))
+		}
+	}
+}
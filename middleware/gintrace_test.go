@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newTracingRouter(exporter *tracetest.InMemoryExporter, logger *zap.Logger) *gin.Engine {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	router := gin.New()
+	router.Use(Tracing(tp, "test"))
+	router.Use(Ginzap(logger, time.RFC3339, true))
+	router.GET("/profile/:username", func(c *gin.Context) {
+		_, span := tp.Tracer("test").Start(c.Request.Context(), "store.GetUserByUsername")
+		span.End()
+		c.String(http.StatusOK, "OK")
+	})
+	return router
+}
+
+func TestTracingCreatesServerSpan(t *testing.T) {
+	require := require.New(t)
+
+	exporter := tracetest.NewInMemoryExporter()
+	router := newTracingRouter(exporter, zap.NewNop())
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/profile/121", nil))
+	require.Equal(http.StatusOK, rec.Code)
+
+	spans := exporter.GetSpans()
+	require.Len(spans, 2, "Should have a handler span and a server span.")
+
+	child, server := spans[0], spans[1]
+	require.Equal("GET /profile/:username", server.Name)
+	require.Equal(trace.SpanKindServer, server.SpanKind)
+	require.Equal(server.SpanContext.SpanID(), child.Parent.SpanID(), "Handler span should be child of server span.")
+}
+
+func TestTracingPropagatesTraceContext(t *testing.T) {
+	require := require.New(t)
+
+	exporter := tracetest.NewInMemoryExporter()
+	router := newTracingRouter(exporter, zap.NewNop())
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/profile/121", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	require.NotEmpty(spans)
+	for _, span := range spans {
+		require.Equal(traceID, span.SpanContext.TraceID().String(), "Span should join the incoming trace.")
+	}
+}
+
+func TestGinzapLogsTraceID(t *testing.T) {
+	require := require.New(t)
+
+	core, logs := observer.New(zap.InfoLevel)
+	exporter := tracetest.NewInMemoryExporter()
+	router := newTracingRouter(exporter, zap.New(core))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/profile/121", nil))
+
+	entries := logs.All()
+	require.Len(entries, 1)
+	spans := exporter.GetSpans()
+	require.NotEmpty(spans)
+	require.Equal(spans[0].SpanContext.TraceID().String(), entries[0].ContextMap()["trace_id"])
+}
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
@@ -1,15 +1,18 @@
 package middleware
 
 import (
+	"minitube/utils"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
 	"runtime/debug"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -36,6 +39,33 @@ func Ginzap(logger *zap.Logger, timeFormat string, utc bool) gin.HandlerFunc {
 	return GinzapWithConfig(logger, &Config{TimeFormat: timeFormat, UTC: utc})
 }
 
+// sensitiveQueryParams - query params masked in access logs besides the ones with
+// sensitive keys, i.e. signed url signatures and OAuth authorization code and state.
+var sensitiveQueryParams = map[string]bool{
+	signatureParam: true,
+	"code":         true,
+	"state":        true,
+}
+
+// redactQuery - raw query with values of sensitive params masked, order is kept
+func redactQuery(query string) string {
+	if query == "" {
+		return query
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if sensitiveQueryParams[strings.ToLower(name)] || utils.IsSensitiveKey(name) {
+			params[i] = key + "=" + utils.Redacted
+		}
+	}
+	return strings.Join(params, "&")
+}
+
 // GinzapWithConfig returns a gin.HandlerFunc using configs
 func GinzapWithConfig(logger *zap.Logger, conf *Config) gin.HandlerFunc {
 	skipPaths := make(map[string]bool, len(conf.SkipPaths))
@@ -47,7 +77,7 @@ func GinzapWithConfig(logger *zap.Logger, conf *Config) gin.HandlerFunc {
 		start := time.Now()
 		// some evil middlewares modify this values
 		path := c.Request.URL.Path
-		query := c.Request.URL.RawQuery
+		query := redactQuery(c.Request.URL.RawQuery)
 		c.Next()
 
 		if _, ok := skipPaths[path]; !ok {
@@ -57,10 +87,22 @@ func GinzapWithConfig(logger *zap.Logger, conf *Config) gin.HandlerFunc {
 				end = end.UTC()
 			}
 
+			// link log lines to the request id and the trace started by Tracing middleware
+			var traceFields []zapcore.Field
+			if id := c.GetString(RequestIDKey); id != "" {
+				traceFields = append(traceFields, zap.String(RequestIDKey, id))
+			}
+			if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+				traceFields = append(traceFields,
+					zap.String("trace_id", sc.TraceID().String()),
+					zap.String("span_id", sc.SpanID().String()),
+				)
+			}
+
 			if len(c.Errors) > 0 {
 				// Append error field if this is an erroneous request.
 				for _, e := range c.Errors.Errors() {
-					logger.Error(e)
+					logger.Error(e, traceFields...)
 				}
 			} else {
 				fields := []zapcore.Field{
@@ -75,6 +117,7 @@ func GinzapWithConfig(logger *zap.Logger, conf *Config) gin.HandlerFunc {
 				if conf.TimeFormat != "" {
 					fields = append(fields, zap.String("time", end.Format(conf.TimeFormat)))
 				}
+				fields = append(fields, traceFields...)
 				logger.Info(path, fields...)
 			}
 		}
@@ -101,7 +144,7 @@ func RecoveryWithZap(logger *zap.Logger, stack bool) gin.HandlerFunc {
 					}
 				}
 
-				httpRequest, _ := httputil.DumpRequest(c.Request, false)
+				httpRequest := dumpRequest(c.Request)
 				if brokenPipe {
 					logger.Error(c.Request.URL.Path,
 						zap.Any("error", err),
@@ -132,4 +175,16 @@ func RecoveryWithZap(logger *zap.Logger, stack bool) gin.HandlerFunc {
 		}()
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// dumpRequest - dump request header without credentials like Authorization and Cookie
+func dumpRequest(req *http.Request) []byte {
+	r := req.Clone(req.Context())
+	for name := range r.Header {
+		if utils.IsSensitiveKey(name) {
+			r.Header.Set(name, utils.Redacted)
+		}
+	}
+	httpRequest, _ := httputil.DumpRequest(r, false)
+	return httpRequest
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestGinzapRedactQuery(t *testing.T) {
+	require := require.New(t)
+
+	core, logs := observer.New(zap.InfoLevel)
+	router := gin.New()
+	router.Use(Ginzap(zap.New(core), "", false))
+	router.GET("/ping", func(c *gin.Context) {
+		c.String(http.StatusOK, "pong")
+	})
+
+	for query, want := range map[string]string{
+		// signed export download link
+		"expires=1700000000&signature=c2VjcmV0": "expires=1700000000&signature=[REDACTED]",
+		// OAuth callback
+		"code=abc123&state=xyz789":             "code=[REDACTED]&state=[REDACTED]",
+		"page=2&access_token=abc&Stream-Key=1": "page=2&access_token=[REDACTED]&Stream-Key=[REDACTED]",
+		"page=2&size=10":                       "page=2&size=10",
+		"":                                     "",
+	} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping?"+query, nil))
+		entries := logs.TakeAll()
+		require.Len(entries, 1)
+		got := entries[0].ContextMap()["query"]
+		require.Equal(want, got, query)
+		for _, secret := range []string{"c2VjcmV0", "abc123", "xyz789"} {
+			require.False(strings.Contains(got.(string), secret), query)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"minitube/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ratelimit let gin throttle requests with a shared limiter,
+// the limiter is backed by redis so all minitube instances share the budget.
+
+// Limit - allow Rate requests every Period, and at most Burst requests at once.
+type Limit struct {
+	Rate   int
+	Burst  int
+	Period time.Duration
+}
+
+// PerMinute - rate requests per minute, burst equals to rate
+func PerMinute(rate int) Limit {
+	return Limit{Rate: rate, Burst: rate, Period: time.Minute}
+}
+
+// Limiter decides whether a request identified by key is allowed.
+// When not allowed, retryAfter tells how long the client should wait.
+type Limiter func(ctx context.Context, key string, limit Limit) (allowed bool, retryAfter time.Duration, err error)
+
+// KeyFunc - extract the identity a limit applies to, return "" to skip limiting
+type KeyFunc func(c *gin.Context) string
+
+// RateLimitConfig is config setting for RateLimit
+type RateLimitConfig struct {
+	// Name separates budgets of different routes, e.g. "login".
+	Name    string
+	Limit   Limit
+	KeyFunc KeyFunc
+}
+
+// KeyByIP - limit by client ip, X-Forwarded-For only counts from the engine's trusted proxies
+func KeyByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// KeyByUser - limit by user id in jwt claims, use after jwt middleware,
+// fallback to client ip for anonymous requests.
+func KeyByUser(identityKey string) KeyFunc {
+	return func(c *gin.Context) string {
+		if id, ok := ExtractClaims(c)[identityKey].(float64); ok {
+			return "user:" + strconv.FormatUint(uint64(id), 10)
+		}
+		return KeyByIP(c)
+	}
+}
+
+// KeyByRoute - one budget for the whole route
+func KeyByRoute(c *gin.Context) string {
+	return "route:" + c.FullPath()
+}
+
+// RateLimit returns a gin.HandlerFunc (middleware) which aborts with 429 and Retry-After
+// when the limit is exceeded. If limiter fails, request is allowed and error is logged.
+func RateLimit(limiter Limiter, conf *RateLimitConfig) gin.HandlerFunc {
+	keyFunc := conf.KeyFunc
+	if keyFunc == nil {
+		keyFunc = KeyByIP
+	}
+
+	return func(c *gin.Context) {
+		key := keyFunc(c)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		allowed, retryAfter, err := limiter(c.Request.Context(), conf.Name+":"+key, conf.Limit)
+		if err != nil {
+			utils.LoggerFrom(c.Request.Context()).Sugar().Warnw("Rate limiter failed, request allowed", "limit", conf.Name, "error", err)
+			c.Next()
+			return
+		}
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"code":    http.StatusTooManyRequests,
+				"message": "Too many requests.",
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// countLimiter - in memory fixed budget limiter for test
+func countLimiter(keys map[string]int) Limiter {
+	return func(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+		keys[key]++
+		if keys[key] > limit.Burst {
+			return false, 1500 * time.Millisecond, nil
+		}
+		return true, 0, nil
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	require := require.New(t)
+
+	keys := make(map[string]int)
+	router := gin.New()
+	router.POST("/login", RateLimit(countLimiter(keys), &RateLimitConfig{Name: "login", Limit: PerMinute(2)}),
+		func(c *gin.Context) { c.String(http.StatusOK, "OK") })
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("POST", "/login", nil))
+		require.Equal(http.StatusOK, rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/login", nil))
+	require.Equal(http.StatusTooManyRequests, rec.Code)
+	require.Equal("2", rec.Header().Get("Retry-After"), "Retry-After should be rounded up.")
+
+	var resp struct {
+		Code    int
+		Message string
+	}
+	require.NoError(json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(http.StatusTooManyRequests, resp.Code)
+	require.Equal(3, keys["login:ip:192.0.2.1"], "Budget should be keyed by name and ip.")
+}
+
+func TestRateLimitFailOpen(t *testing.T) {
+	failing := func(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+		return false, 0, errors.New("redis down")
+	}
+	router := gin.New()
+	router.GET("/profile/:username", RateLimit(failing, &RateLimitConfig{Name: "public", Limit: PerMinute(1)}),
+		func(c *gin.Context) { c.String(http.StatusOK, "OK") })
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/profile/121", nil))
+	require.Equal(t, http.StatusOK, rec.Code, "Limiter failure shouldn't block requests.")
+}
+
+func TestKeyByUser(t *testing.T) {
+	require := require.New(t)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	require.Equal("ip:192.0.2.1", KeyByUser("id")(c), "Anonymous request should fallback to ip.")
+
+	c.Set("JWT_PAYLOAD", MapClaims{"id": float64(31)})
+	require.Equal("user:31", KeyByUser("id")(c))
+}
+
+func TestKeyByIPIgnoresUntrustedForwardedFor(t *testing.T) {
+	require := require.New(t)
+
+	keys := make(map[string]int)
+	router := gin.New()
+	require.NoError(router.SetTrustedProxies(nil))
+	router.POST("/login", RateLimit(countLimiter(keys), &RateLimitConfig{Name: "login", Limit: PerMinute(1)}),
+		func(c *gin.Context) { c.String(http.StatusOK, "OK") })
+
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2"} {
+		req := httptest.NewRequest("POST", "/login", nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	require.Equal(map[string]int{"login:ip:192.0.2.1": 2}, keys, "Spoofed X-Forwarded-For shouldn't get a fresh budget.")
+}
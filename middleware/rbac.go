@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Claim keys carrying user's roles and permissions, set by PayloadFunc at login.
+const (
+	RolesKey       = "roles"
+	PermissionsKey = "permissions"
+)
+
+// Policy decides whether the authenticated request (claims) may access the route.
+type Policy func(c *gin.Context, claims MapClaims) bool
+
+// ClaimStrings - get a string list claim, jwt decodes lists as []interface{}
+func ClaimStrings(claims MapClaims, key string) []string {
+	switch v := claims[key].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	default:
+		return nil
+	}
+}
+
+// HasPermission - whether claims grant the permission
+func HasPermission(claims MapClaims, permission string) bool {
+	for _, perm := range ClaimStrings(claims, PermissionsKey) {
+		if perm == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermissions - policy passes when claims grant all the permissions
+func RequirePermissions(permissions ...string) Policy {
+	return func(c *gin.Context, claims MapClaims) bool {
+		for _, perm := range permissions {
+			if !HasPermission(claims, perm) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Owner - policy passes when the route param (e.g. ":username") is the
+// token's own claimKey (e.g. "username"), i.e. user accesses own resource.
+func Owner(param, claimKey string) Policy {
+	return func(c *gin.Context, claims MapClaims) bool {
+		value, ok := claims[claimKey].(string)
+		return ok && value != "" && value == c.Param(param)
+	}
+}
+
+// AnyOf - policy passes when any of policies passes
+func AnyOf(policies ...Policy) Policy {
+	return func(c *gin.Context, claims MapClaims) bool {
+		for _, policy := range policies {
+			if policy(c, claims) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Authorize returns a gin.HandlerFunc (middleware) that aborts with 403 unless all
+// policies pass. Use it after GinJWTMiddleware.MiddlewareFunc on routes or groups, e.g.
+//
+//	adminGroup.Use(auth.MiddlewareFunc(), Authorize(RequirePermissions("admin:access")))
+func Authorize(policies ...Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := ExtractClaims(c)
+		for _, policy := range policies {
+			if !policy(c, claims) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"code":    http.StatusForbidden,
+					"message": ErrForbidden.Error(),
+				})
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// withClaims - pretend GinJWTMiddleware has authenticated the request
+func withClaims(claims MapClaims) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("JWT_PAYLOAD", claims)
+		c.Next()
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	require := require.New(t)
+
+	admin := MapClaims{"username": "121", PermissionsKey: []interface{}{"admin:access", "stream:key:read"}}
+	user := MapClaims{"username": "122", PermissionsKey: []interface{}{}}
+
+	serve := func(claims MapClaims, path string) int {
+		router := gin.New()
+		ok := func(c *gin.Context) { c.String(http.StatusOK, "OK") }
+		admins := router.Group("/admin", withClaims(claims), Authorize(RequirePermissions("admin:access")))
+		admins.GET("/log/level", ok)
+		router.GET("/stream/key/:username", withClaims(claims),
+			Authorize(AnyOf(Owner("username", "username"), RequirePermissions("stream:key:read"))), ok)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code
+	}
+
+	require.Equal(http.StatusOK, serve(admin, "/admin/log/level"))
+	require.Equal(http.StatusForbidden, serve(user, "/admin/log/level"), "Permission should be required.")
+	require.Equal(http.StatusForbidden, serve(MapClaims{}, "/admin/log/level"), "No claims, no permission.")
+
+	require.Equal(http.StatusOK, serve(user, "/stream/key/122"), "Owner should access own key.")
+	require.Equal(http.StatusForbidden, serve(user, "/stream/key/121"), "Others shouldn't access key.")
+	require.Equal(http.StatusOK, serve(admin, "/stream/key/122"), "Permission should override ownership.")
+}
+
+func TestClaimStrings(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal([]string{"admin"}, ClaimStrings(MapClaims{RolesKey: []interface{}{"admin", 1}}, RolesKey))
+	require.Equal([]string{"admin"}, ClaimStrings(MapClaims{RolesKey: []string{"admin"}}, RolesKey))
+	require.Nil(ClaimStrings(MapClaims{RolesKey: "admin"}, RolesKey))
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"minitube/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader - header used to accept and return request id
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDKey - key of request id in gin.Context
+const RequestIDKey = "request_id"
+
+// RequestID returns a gin.HandlerFunc (middleware) that makes sure every request has an id.
+//
+// A valid X-Request-ID from client or proxy is reused, otherwise a new one is generated.
+// The id is echoed in response header, and a logger with request_id (and trace_id when
+// Tracing middleware runs before it) is put in c.Request's context, get it by utils.LoggerFrom.
+func RequestID(logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+
+		fields := []zap.Field{zap.String(RequestIDKey, id)}
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			fields = append(fields, zap.String("trace_id", sc.TraceID().String()))
+		}
+		ctx := utils.WithLogger(c.Request.Context(), logger.With(fields...))
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
+
+// validRequestID - accept at most 64 visible ascii chars, so logs can't be forged.
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
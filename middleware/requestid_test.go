@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"minitube/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestID(t *testing.T) {
+	require := require.New(t)
+
+	core, logs := observer.New(zap.InfoLevel)
+	router := gin.New()
+	router.Use(RequestID(zap.New(core)))
+	router.GET("/ping", func(c *gin.Context) {
+		utils.LoggerFrom(c.Request.Context()).Info("ping")
+		c.String(http.StatusOK, "pong")
+	})
+
+	// Valid id from client is reused.
+	req := httptest.NewRequest("GET", "/ping", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal("abc-123", rec.Header().Get(RequestIDHeader))
+	require.Equal("abc-123", logs.All()[0].ContextMap()[RequestIDKey])
+
+	// Missing or invalid id is replaced.
+	for _, id := range []string{"", "bad id\n", string(make([]byte, 65))} {
+		req := httptest.NewRequest("GET", "/ping", nil)
+		req.Header.Set(RequestIDHeader, id)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		got := rec.Header().Get(RequestIDHeader)
+		require.Len(got, 32, "Request id should be generated for %q.", id)
+		require.NotEqual(id, got)
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// ScopesKey - claim of scopes granted to an API token. Session tokens have no such
+// claim, and aren't limited by scopes.
+const ScopesKey = "scopes"
+
+// IsAPIToken - whether claims are of an API token
+func IsAPIToken(claims MapClaims) bool {
+	_, ok := claims[ScopesKey]
+	return ok
+}
+
+// HasScopes - whether claims grant all the scopes, session tokens grant any scope
+func HasScopes(claims MapClaims, scopes ...string) bool {
+	if !IsAPIToken(claims) {
+		return true
+	}
+	granted := make(map[string]bool)
+	for _, scope := range ClaimStrings(claims, ScopesKey) {
+		granted[scope] = true
+	}
+	for _, scope := range scopes {
+		if !granted[scope] {
+			return false
+		}
+	}
+	return true
+}
+
+// RouteScopes - scopes an API token needs for each route, keyed by method and
+// full path, e.g. "POST /user/profile". API tokens can't access routes not listed.
+type RouteScopes map[string][]string
+
+// Allow - policy passes for session tokens, and for API tokens granted the scopes of route.
+// Use it in GinJWTMiddleware.Authorizator, so no route is accessible by API tokens by mistake.
+func (r RouteScopes) Allow(c *gin.Context, claims MapClaims) bool {
+	if !IsAPIToken(claims) {
+		return true
+	}
+	scopes, ok := r[c.Request.Method+" "+c.FullPath()]
+	return ok && HasScopes(claims, scopes...)
+}
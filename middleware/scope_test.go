@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteScopes(t *testing.T) {
+	require := require.New(t)
+
+	scopes := RouteScopes{
+		"POST /user/profile":        {"profile:write"},
+		"GET /stream/key/:username": {"stream:key"},
+	}
+	serve := func(claims MapClaims, method, path string) int {
+		router := gin.New()
+		ok := func(c *gin.Context) { c.String(http.StatusOK, "OK") }
+		user := router.Group("/", withClaims(claims), Authorize(scopes.Allow))
+		user.POST("/user/profile", ok)
+		user.POST("/user/password", ok)
+		user.GET("/stream/key/:username", ok)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Code
+	}
+
+	session := MapClaims{"username": "121"}
+	bot := MapClaims{"username": "121", ScopesKey: []interface{}{"profile:write"}}
+	require.Equal(http.StatusOK, serve(session, "POST", "/user/password"), "Session tokens aren't limited by scopes.")
+	require.Equal(http.StatusOK, serve(bot, "POST", "/user/profile"))
+	require.Equal(http.StatusForbidden, serve(bot, "GET", "/stream/key/121"), "Scope should be granted.")
+	require.Equal(http.StatusForbidden, serve(bot, "POST", "/user/password"), "Routes not listed are denied.")
+	require.Equal(http.StatusForbidden, serve(MapClaims{ScopesKey: []interface{}{}}, "POST", "/user/profile"))
+
+	require.True(HasScopes(session, "chat:write"))
+	require.True(HasScopes(bot))
+	require.False(HasScopes(bot, "profile:write", "chat:write"))
+}
+
+func TestAPITokenLookup(t *testing.T) {
+	require := require.New(t)
+
+	mw, err := New(&GinJWTMiddleware{
+		Realm:          "test",
+		Key:            []byte("secret"),
+		IdentityKey:    "id",
+		TokenHeadName:  "MiniTube",
+		APITokenPrefix: "mtp_",
+		APITokenAuthenticator: func(token string, c *gin.Context) (MapClaims, error) {
+			if token != "mtp_valid" {
+				return nil, errors.New("invalid api token")
+			}
+			return MapClaims{"id": float64(121), "exp": float64(4102444800), ScopesKey: []string{"profile:write"}}, nil
+		},
+		Authenticator: func(c *gin.Context) (interface{}, error) { return nil, ErrFailedAuthentication },
+		Unauthorized: func(c *gin.Context, code int, message string) {
+			c.String(code, message)
+		},
+	})
+	require.NoError(err)
+
+	serve := func(token string) (int, string) {
+		router := gin.New()
+		router.GET("/user/me", mw.MiddlewareFunc(), func(c *gin.Context) {
+			c.String(http.StatusOK, strings.Join(ClaimStrings(ExtractClaims(c), ScopesKey), " "))
+		})
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/user/me", nil)
+		req.Header.Set("Authorization", "MiniTube "+token)
+		router.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	code, body := serve("mtp_valid")
+	require.Equal(http.StatusOK, code)
+	require.Equal("profile:write", body)
+	code, body = serve("mtp_revoked")
+	require.Equal(http.StatusUnauthorized, code)
+	require.Equal("invalid api token", body)
+
+	jwtToken, _, err := mw.TokenGenerator(nil)
+	require.NoError(err)
+	code, body = serve(jwtToken)
+	require.Equal(http.StatusOK, code, "Jwts still work.")
+	require.Empty(body)
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedURL(t *testing.T) {
+	require := require.New(t)
+
+	secret := []byte("minitube")
+	router := gin.New()
+	router.GET("/export/:id", SignedURL(secret), func(c *gin.Context) { c.String(http.StatusOK, "OK") })
+
+	do := func(uri string) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", uri, nil))
+		return rec.Code
+	}
+	expires := time.Now().Add(time.Minute)
+
+	require.Equal(http.StatusOK, do(SignURL(secret, "/export/1", expires)))
+	require.Equal(http.StatusForbidden, do("/export/1"), "Unsigned url should be refused.")
+	require.Equal(http.StatusForbidden, do(SignURL(secret, "/export/1", time.Now().Add(-time.Second))), "Expired url should be refused.")
+	require.Equal(http.StatusForbidden, do(SignURL([]byte("other"), "/export/1", expires)), "Url signed by other secret should be refused.")
+	require.Equal(http.StatusForbidden, do(strings.Replace(SignURL(secret, "/export/1", expires), "/1", "/2", 1)), "Signature is bound to path.")
+	extended := strings.Replace(SignURL(secret, "/export/1", expires),
+		strconv.FormatInt(expires.Unix(), 10), strconv.FormatInt(expires.Add(time.Hour).Unix(), 10), 1)
+	require.Equal(http.StatusForbidden, do(extended), "Signature is bound to expiry time.")
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// API token scope
+const (
+	ScopeProfileRead   = "profile:read"
+	ScopeProfileWrite  = "profile:write"
+	ScopeFollowersRead = "followers:read"
+	ScopeStreamKey     = "stream:key"
+	ScopeChatWrite     = "chat:write"
+)
+
+// impliedScopes - scopes granted along with a scope, who can write can also read
+var impliedScopes = map[string][]string{
+	ScopeProfileWrite: {ScopeProfileRead},
+}
+
+// APIToken - personal access token of user, for bots and tools. Only its hash is kept,
+// the token itself is shown once when created.
+type APIToken struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"index;not null"`
+	Name      string `gorm:"type:varchar(50);not null"`
+	// Hash - sha256 hex of token
+	Hash string `gorm:"type:char(64);unique_index;not null"`
+	// Scopes - space separated
+	Scopes     string `gorm:"type:varchar(255);not null"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	LastUsedIP string `gorm:"type:varchar(45);not null;default:''"`
+}
+
+// ScopeList - scopes granted to token
+func (t *APIToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+// GrantedScopes - scopes granted to token and the ones they imply
+func (t *APIToken) GrantedScopes() []string {
+	scopes := t.ScopeList()
+	granted := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		granted[scope] = true
+	}
+	for _, scope := range scopes {
+		for _, implied := range impliedScopes[scope] {
+			if !granted[implied] {
+				granted[implied] = true
+				scopes = append(scopes, implied)
+			}
+		}
+	}
+	return scopes
+}
+
+// IsExpired - whether token is expired at now, tokens without expiry never expire
+func (t *APIToken) IsExpired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// APITokenInfo - API token shown to its user, without the token
+type APITokenInfo struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+}
+
+// NewAPITokenInfoFromAPIToken - new API token info from API token
+func NewAPITokenInfoFromAPIToken(t *APIToken) *APITokenInfo {
+	return &APITokenInfo{
+		ID:         t.ID,
+		Name:       t.Name,
+		Scopes:     t.ScopeList(),
+		CreatedAt:  t.CreatedAt,
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+		LastUsedIP: t.LastUsedIP,
+	}
+}
+
+// CreateAPITokenModel - create API token request model, token never expires if expires_in_days is 0
+type CreateAPITokenModel struct {
+	Name          string   `json:"name" form:"name" binding:"required,max=50"`
+	Scopes        []string `json:"scopes" form:"scopes" binding:"required,min=1,dive,oneof=profile:read profile:write followers:read stream:key chat:write"`
+	ExpiresInDays int      `json:"expires_in_days" form:"expires_in_days" binding:"omitempty,min=1,max=365"`
+}
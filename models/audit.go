@@ -0,0 +1,104 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audit action
+const (
+	AuditLoginSuccess   = "login.success"
+	AuditLoginFailure   = "login.failure"
+	AuditRegisterOIDC   = "register.oidc"
+	AuditPasswordChange = "password.change"
+	AuditProfileUpdate  = "profile.update"
+	AuditPrivacyUpdate  = "privacy.update"
+	AuditUsernameChange = "username.change"
+	AuditStreamKeyRead  = "stream_key.read"
+	AuditStreamKeyReset = "stream_key.reset"
+
+	AuditAccountDeleteSchedule = "account.delete.schedule"
+	AuditAccountDeleteCancel   = "account.delete.cancel"
+	AuditAccountDelete         = "account.delete"
+	AuditExportRequest         = "export.request"
+	AuditExportDownload        = "export.download"
+	AuditAPITokenCreate        = "api_token.create"
+	AuditAPITokenRevoke        = "api_token.revoke"
+
+	AuditAdminLogLevel      = "admin.log_level"
+	AuditAdminUnlock        = "admin.user.unlock"
+	AuditAdminSuspend       = "admin.user.suspend"
+	AuditAdminUnsuspend     = "admin.user.unsuspend"
+	AuditAdminPasswordReset = "admin.user.password_reset"
+	AuditAdminRevokeSession = "admin.user.revoke_sessions"
+	AuditAdminDelete        = "admin.user.delete"
+	AuditAdminEndLive       = "admin.live.end"
+	AuditAdminRoleAssign    = "admin.role.assign"
+	AuditAdminRoleRevoke    = "admin.role.revoke"
+	AuditAdminReportAssign  = "admin.report.assign"
+	AuditAdminReportResolve = "admin.report.resolve"
+)
+
+// AuditEvent - a security relevant or admin action, append only
+type AuditEvent struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+
+	// ActorID - who did it, nil if unauthenticated, e.g. failed login
+	ActorID       *uint  `gorm:"index" json:"actor_id"`
+	ActorUsername string `gorm:"type:varchar(20);not null;default:''" json:"actor_username"`
+	Action        string `gorm:"type:varchar(40);not null;index" json:"action"`
+	// TargetUserID - whose account is affected
+	TargetUserID *uint  `gorm:"index" json:"target_user_id"`
+	Target       string `gorm:"type:varchar(50);not null;default:''" json:"target"`
+	IP           string `gorm:"type:varchar(45);not null;default:''" json:"ip"`
+	UserAgent    string `gorm:"type:varchar(255);not null;default:''" json:"user_agent"`
+	// Before and After - json of changed fields
+	Before string `gorm:"type:text" json:"-"`
+	After  string `gorm:"type:text" json:"-"`
+}
+
+// MarshalJSON - before and after are embedded as json rather than strings
+func (e *AuditEvent) MarshalJSON() ([]byte, error) {
+	type event AuditEvent
+	raw := func(s string) json.RawMessage {
+		if s == "" {
+			return nil
+		}
+		return json.RawMessage(s)
+	}
+	return json.Marshal(&struct {
+		*event
+		Before json.RawMessage `json:"before,omitempty"`
+		After  json.RawMessage `json:"after,omitempty"`
+	}{(*event)(e), raw(e.Before), raw(e.After)})
+}
+
+// SecurityEvent - audit event shown to the user it's about
+type SecurityEvent struct {
+	Action    string    `json:"action"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Time      time.Time `json:"time"`
+}
+
+// NewSecurityEventFromAuditEvent - new security event from audit event
+func NewSecurityEventFromAuditEvent(e *AuditEvent) *SecurityEvent {
+	return &SecurityEvent{
+		Action:    e.Action,
+		IP:        e.IP,
+		UserAgent: e.UserAgent,
+		Time:      e.CreatedAt,
+	}
+}
+
+// AuditQueryModel - admin list audit events request model
+type AuditQueryModel struct {
+	Actor  string     `form:"actor" binding:"max=20"`
+	Target string     `form:"target" binding:"max=20"`
+	Action string     `form:"action" binding:"max=40"`
+	From   *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To     *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Page   int        `form:"page" binding:"omitempty,min=1"`
+	Size   int        `form:"size" binding:"omitempty,min=1,max=100"`
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Export status
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+// Export - personal data export job, the result is a zip of json files
+type Export struct {
+	ID         string     `json:"id"`
+	UserID     uint       `json:"user_id"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	// ExpiresAt - the zip can be downloaded until
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// InProgress - whether export job is not finished
+func (e *Export) InProgress() bool {
+	return e.Status == ExportPending || e.Status == ExportRunning
+}
+
+// ExportRoom - user's room in export
+type ExportRoom struct {
+	Name      *string   `json:"live_name"`
+	Intro     *string   `json:"live_intro"`
+	Category  *string   `json:"live_category"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewExportRoomFromRoom - new export room from room
+func NewExportRoomFromRoom(room *Room) *ExportRoom {
+	return &ExportRoom{
+		Name:      room.Name,
+		Intro:     room.Intro,
+		Category:  room.Category,
+		CreatedAt: room.CreatedAt,
+		UpdatedAt: room.UpdatedAt,
+	}
+}
+
+// Follow - a follower or following, and when it's followed
+type Follow struct {
+	Username  string `json:"username"`
+	TimeStamp int64  `json:"timestamp"`
+}
+
+// ZToFollow - parse redis.Z to Follow
+func ZToFollow(z *redis.Z) *Follow {
+	return &Follow{
+		Username:  z.Member.(string),
+		TimeStamp: int64(z.Score),
+	}
+}
+
+// Broadcast - a live broadcast of user
+type Broadcast struct {
+	StartTime time.Time `json:"start_time"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+// FollowingChannel - a followed channel in the following feed
+type FollowingChannel struct {
+	Username  string     `json:"username"`
+	Living    bool       `json:"living"`
+	Watching  int        `json:"watching"`
+	StartTime *time.Time `json:"start_time"`
+	// LastLive - when the channel was last seen living, nil if it's living or never seen
+	LastLive *time.Time `json:"last_live"`
+}
+
+// SortFollowingChannels - living channels first, the most watched first, then offline
+// channels, the latest live first and never seen last. Ties are sorted by username.
+func SortFollowingChannels(channels []*FollowingChannel) {
+	sort.Slice(channels, func(i, j int) bool {
+		a, b := channels[i], channels[j]
+		if a.Living != b.Living {
+			return a.Living
+		}
+		if a.Living && a.Watching != b.Watching {
+			return a.Watching > b.Watching
+		}
+		if !a.Living && (a.LastLive == nil) != (b.LastLive == nil) {
+			return a.LastLive != nil
+		}
+		if !a.Living && a.LastLive != nil && !a.LastLive.Equal(*b.LastLive) {
+			return a.LastLive.After(*b.LastLive)
+		}
+		return a.Username < b.Username
+	})
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Identity - user's account at an OpenID Connect provider, user signs in with it
+type Identity struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"index;not null"`
+	Provider  string `gorm:"type:varchar(32);unique_index:idx_identity_provider_subject;not null"`
+	// Subject - user's id at provider, never changes unlike email
+	Subject string  `gorm:"type:varchar(255);unique_index:idx_identity_provider_subject;not null"`
+	Email   *string `gorm:"type:varchar(255)"`
+}
+
+// OIDCLogin - a sign in started at provider, kept until the provider redirects back
+type OIDCLogin struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// JWTKey - private key signing jwts, shared by all instances
+type JWTKey struct {
+	// ID - kid of tokens signed by the key
+	ID        string `gorm:"type:varchar(32);primary_key"`
+	CreatedAt time.Time
+	Algorithm string `gorm:"type:varchar(16);not null"`
+	// PrivateKey - PKCS #8 PEM sealed with JWT_KEY_ENCRYPTION_KEY, in base64
+	PrivateKey string    `gorm:"type:text;not null"`
+	NotBefore  time.Time `gorm:"not null"`
+	// NotAfter - key is retired after, nil until a newer key replaces it
+	NotAfter *time.Time `gorm:"index"`
+}
@@ -1,9 +1,11 @@
 package models
 
 import (
+	"minitube/utils"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap/zapcore"
 )
 
 // RegisterModel - register request model
@@ -12,6 +14,16 @@ type RegisterModel struct {
 	Password string `form:"password" json:"password" binding:"required,hexadecimal,len=64"`
 	Email    string `form:"email"    json:"email"    binding:"omitempty,email,max=50"`
 	Phone    string `form:"phone"    json:"phone"    binding:"omitempty,e164"`
+	// PlainPassword - optional, sent over tls only, to be checked against breached passwords
+	PlainPassword string `form:"plain_password" json:"plain_password" binding:"omitempty,max=128"`
+}
+
+// MarshalLogObject - log register request without password
+func (m *RegisterModel) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("username", m.Username)
+	enc.AddString("password", utils.Redacted)
+	addMaskedContact(enc, m.Email, m.Phone)
+	return nil
 }
 
 // LoginModel - login request model
@@ -20,6 +32,17 @@ type LoginModel struct {
 	Password string `form:"password" json:"password" binding:"required,hexadecimal,len=64"`
 	Email    string `form:"email"    json:"email"    binding:"required_without_all=Username Phone,omitempty,email,max=50"`
 	Phone    string `form:"phone"    json:"phone"    binding:"required_without_all=Username Email,omitempty,e164"`
+	// proof of work, required after too many failed logins
+	Challenge string `form:"challenge" json:"challenge" binding:"omitempty,hexadecimal,len=32"`
+	Nonce     string `form:"nonce"     json:"nonce"     binding:"omitempty,max=64"`
+}
+
+// MarshalLogObject - log login request without password
+func (m *LoginModel) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("username", m.Username)
+	enc.AddString("password", utils.Redacted)
+	addMaskedContact(enc, m.Email, m.Phone)
+	return nil
 }
 
 // ChangeProfileModel - user change profile request model
@@ -28,6 +51,26 @@ type ChangeProfileModel struct {
 	Phone     string `form:"phone"      json:"phone"      binding:"omitempty,e164"`
 	LiveName  string `form:"live_name"  json:"live_name"  binding:"omitempty,max=30"`
 	LiveIntro string `form:"live_intro" json:"live_intro" binding:"omitempty,max=200"`
+
+	LiveCategory string `form:"live_category" json:"live_category" binding:"omitempty,oneof=gaming music talk sports creative education other"`
+}
+
+// MarshalLogObject - log profile with email and phone masked
+func (m *ChangeProfileModel) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	addMaskedContact(enc, m.Email, m.Phone)
+	enc.AddString("live_name", m.LiveName)
+	enc.AddString("live_intro", m.LiveIntro)
+	enc.AddString("live_category", m.LiveCategory)
+	return nil
+}
+
+func addMaskedContact(enc zapcore.ObjectEncoder, email, phone string) {
+	if email != "" {
+		enc.AddString("email", utils.MaskEmail(email))
+	}
+	if phone != "" {
+		enc.AddString("phone", utils.MaskPhone(phone))
+	}
 }
 
 // MapUser - get ChangeProfileModel in map
@@ -59,32 +102,47 @@ func (m *ChangeProfileModel) MapRoom() map[string]interface{} {
 	} else {
 		mp["intro"] = nil
 	}
+	if m.LiveCategory != "" {
+		mp["category"] = m.LiveCategory
+	} else {
+		mp["category"] = nil
+	}
 	return mp
 }
 
 // Me - getMe respone model
 type Me struct {
-	ID        uint      `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Username  string    `json:"username"`
-	Email     *string   `json:"email"`
-	Phone     *string   `json:"phone"`
-	LiveName  *string   `json:"live_name"`
-	LiveIntro *string   `json:"live_intro"`
+	ID           uint      `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Username     string    `json:"username"`
+	Email        *string   `json:"email"`
+	Phone        *string   `json:"phone"`
+	LiveName     *string   `json:"live_name"`
+	LiveIntro    *string   `json:"live_intro"`
+	LiveCategory *string   `json:"live_category"`
+	Roles        []string  `json:"roles"`
+
+	PasswordResetRequired bool    `json:"password_reset_required"`
+	Privacy               Privacy `json:"privacy"`
 }
 
 // GetMeFromUser - get Me from User
 func GetMeFromUser(user *User) *Me {
 	me := &Me{
-		ID:        user.ID,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
-		Username:  user.Username,
-		Email:     user.Email,
-		Phone:     user.Phone,
-		LiveName:  user.Room.Name,
-		LiveIntro: user.Room.Intro,
+		ID:           user.ID,
+		CreatedAt:    user.CreatedAt,
+		UpdatedAt:    user.UpdatedAt,
+		Username:     user.Username,
+		Email:        user.Email,
+		Phone:        user.Phone,
+		LiveName:     user.Room.Name,
+		LiveIntro:    user.Room.Intro,
+		LiveCategory: user.Room.Category,
+		Roles:        user.RoleNames(),
+
+		PasswordResetRequired: user.PasswordResetRequired,
+		Privacy:               *GetPrivacyFromUser(user),
 	}
 	if user.UpdatedAt.After(user.Room.UpdatedAt) {
 		me.UpdatedAt = user.UpdatedAt
@@ -94,10 +152,81 @@ func GetMeFromUser(user *User) *Me {
 	return me
 }
 
+// Privacy - user's privacy settings
+type Privacy struct {
+	HideFollowers  bool `json:"hide_followers"`
+	HideFollowings bool `json:"hide_followings"`
+	HistoryPaused  bool `json:"history_paused"`
+}
+
+// GetPrivacyFromUser - get Privacy from User
+func GetPrivacyFromUser(user *User) *Privacy {
+	return &Privacy{
+		HideFollowers:  user.HideFollowers,
+		HideFollowings: user.HideFollowings,
+		HistoryPaused:  user.HistoryPaused,
+	}
+}
+
+// ChangePrivacyModel - change privacy settings request model, settings not sent are kept
+type ChangePrivacyModel struct {
+	HideFollowers  *bool `json:"hide_followers"  form:"hide_followers"`
+	HideFollowings *bool `json:"hide_followings" form:"hide_followings"`
+	HistoryPaused  *bool `json:"history_paused"  form:"history_paused"`
+}
+
+// Apply - privacy with settings of request changed
+func (m *ChangePrivacyModel) Apply(privacy Privacy) Privacy {
+	if m.HideFollowers != nil {
+		privacy.HideFollowers = *m.HideFollowers
+	}
+	if m.HideFollowings != nil {
+		privacy.HideFollowings = *m.HideFollowings
+	}
+	if m.HistoryPaused != nil {
+		privacy.HistoryPaused = *m.HistoryPaused
+	}
+	return privacy
+}
+
 // ChangePasswordModel - change password request model
 type ChangePasswordModel struct {
-	OldPassword string `json:"old_password" form:"old_password" binding:"required,hexadecimal,len=64"`
+	// OldPassword - required unless user has no password yet, see User.HasPassword
+	OldPassword string `json:"old_password" form:"old_password" binding:"omitempty,hexadecimal,len=64"`
 	NewPassword string `json:"new_password" form:"new_password" binding:"required,hexadecimal,len=64"`
+	// NewPlainPassword - optional, as RegisterModel.PlainPassword
+	NewPlainPassword string `json:"new_plain_password" form:"new_plain_password" binding:"omitempty,max=128"`
+}
+
+// MarshalLogObject - never log old or new password
+func (m *ChangePasswordModel) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("old_password", utils.Redacted)
+	enc.AddString("new_password", utils.Redacted)
+	return nil
+}
+
+// ChangeUsernameModel - change username request model, password is confirmed again
+type ChangeUsernameModel struct {
+	Username string `json:"username" form:"username" binding:"required,alphanum,min=1,max=20"`
+	Password string `json:"password" form:"password" binding:"required,hexadecimal,len=64"`
+}
+
+// MarshalLogObject - never log password
+func (m *ChangeUsernameModel) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("username", m.Username)
+	enc.AddString("password", utils.Redacted)
+	return nil
+}
+
+// DeleteAccountModel - delete account request model, password is confirmed again
+type DeleteAccountModel struct {
+	Password string `json:"password" form:"password" binding:"required,hexadecimal,len=64"`
+}
+
+// MarshalLogObject - never log password
+func (m *DeleteAccountModel) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("password", utils.Redacted)
+	return nil
 }
 
 // PublicUser - public user don't have private info.
@@ -105,6 +234,7 @@ type PublicUser struct {
 	Username  string     `json:"username"`
 	RoomName  *string    `json:"live_name"`
 	RoomIntro *string    `json:"live_intro"`
+	Category  *string    `json:"live_category"`
 	Living    bool       `json:"living"`
 	StartTime *time.Time `json:"start_time"`
 	Watching  int        `json:"watching"`
@@ -123,10 +253,78 @@ type History struct {
 	TimeStamp int64  `json:"timestamp"`
 }
 
-// ZToHistory - parse redis.Z to History
-func ZToHistory(z *redis.Z) *History {
+// ZToHistory - parse redis.Z to History of the channel named username
+func ZToHistory(z *redis.Z, username string) *History {
 	return &History{
-		Username:  z.Member.(string),
+		Username:  username,
 		TimeStamp: int64(z.Score),
 	}
 }
+
+// Notification type
+const (
+	NotificationAccountLocked = "account_locked"
+)
+
+// Notification - message to user, e.g. account locked
+type Notification struct {
+	Type      string `json:"type"`
+	Message   string `json:"message"`
+	TimeStamp int64  `json:"timestamp"`
+}
+
+// AdminUser - user model returned to admin
+type AdminUser struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Username  string    `json:"username"`
+	Email     *string   `json:"email"`
+	Phone     *string   `json:"phone"`
+	Roles     []string  `json:"roles"`
+	Living    bool      `json:"living"`
+
+	PasswordResetRequired bool `json:"password_reset_required"`
+
+	Suspended      bool       `json:"suspended"`
+	SuspendedAt    *time.Time `json:"suspended_at"`
+	SuspendedUntil *time.Time `json:"suspended_until"`
+	SuspendReason  *string    `json:"suspend_reason"`
+	SuspendedBy    *uint      `json:"suspended_by"`
+
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+}
+
+// NewAdminUserFromUser - new admin user from user
+func NewAdminUserFromUser(user *User) *AdminUser {
+	return &AdminUser{
+		ID:        user.ID,
+		CreatedAt: user.CreatedAt,
+		Username:  user.Username,
+		Email:     user.Email,
+		Phone:     user.Phone,
+		Roles:     user.RoleNames(),
+
+		PasswordResetRequired: user.PasswordResetRequired,
+
+		Suspended:      user.IsSuspended(time.Now()),
+		SuspendedAt:    user.SuspendedAt,
+		SuspendedUntil: user.SuspendedUntil,
+		SuspendReason:  user.SuspendReason,
+		SuspendedBy:    user.SuspendedBy,
+
+		DeletionScheduledAt: user.DeletionScheduledAt,
+	}
+}
+
+// SearchUserModel - admin search users request model
+type SearchUserModel struct {
+	Query string `form:"q" binding:"max=50"`
+	Page  int    `form:"page" binding:"omitempty,min=1"`
+	Size  int    `form:"size" binding:"omitempty,min=1,max=100"`
+}
+
+// SuspendModel - admin suspend user request model
+type SuspendModel struct {
+	Reason string     `json:"reason" form:"reason" binding:"required,max=200"`
+	Until  *time.Time `json:"until" form:"until" time_format:"2006-01-02T15:04:05Z07:00" binding:"omitempty"`
+}
@@ -0,0 +1,194 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLogObjectsHideSecrets(t *testing.T) {
+	require := require.New(t)
+
+	password := "c96d84ba3b4a823c4fee088a7369a5c02f50ef40f9ca54bdec34843eba157132"
+	email, phone := "125@minitube.com", "+8612568686868"
+
+	user := NewUser("125", password)
+	user.Email, user.Phone = &email, &phone
+	objects := []zapcore.ObjectMarshaler{
+		user,
+		&RegisterModel{Username: "125", Password: password, Email: email, Phone: phone},
+		&LoginModel{Email: email, Password: password},
+		&ChangePasswordModel{OldPassword: password, NewPassword: password},
+		&ChangeProfileModel{Email: email, Phone: phone, LiveName: "125's room"},
+	}
+
+	for _, object := range objects {
+		enc := zapcore.NewMapObjectEncoder()
+		require.NoError(object.MarshalLogObject(enc))
+		logged := fmt.Sprint(enc.Fields)
+		for _, secret := range []string{password, email, phone} {
+			require.NotContainsf(logged, secret, "%T leaks secret in log: %v", object, logged)
+		}
+	}
+}
+
+func TestIsSuspended(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Now()
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+	user := NewUser("125", "")
+	require.False(user.IsSuspended(now))
+
+	user.SuspendedAt = &before
+	require.True(user.IsSuspended(now), "Suspension without until is permanent.")
+	user.SuspendedUntil = &after
+	require.True(user.IsSuspended(now))
+	require.False(user.IsSuspended(after), "Suspension ends at until.")
+}
+
+func TestCanManage(t *testing.T) {
+	require := require.New(t)
+
+	role := func(name string) Role {
+		perms := make([]Permission, len(RolePermissions[name]))
+		for i, perm := range RolePermissions[name] {
+			perms[i] = Permission{Name: perm}
+		}
+		return Role{Name: name, Permissions: perms}
+	}
+	user := func(id uint, roles ...Role) *User {
+		u := &User{Roles: roles}
+		u.ID = id
+		return u
+	}
+	admin, otherAdmin := user(1, role(RoleAdmin)), user(2, role(RoleAdmin))
+	moderator, plain := user(3, role(RoleModerator)), user(4)
+
+	require.True(admin.CanManage(moderator))
+	require.True(admin.CanManage(otherAdmin))
+	require.True(moderator.CanManage(plain))
+	require.False(moderator.CanManage(admin), "Moderator can't act on who has more permissions.")
+	require.False(admin.CanManage(admin), "Nobody can act on oneself.")
+}
+
+func TestAuditEventJSON(t *testing.T) {
+	require := require.New(t)
+
+	event := &AuditEvent{Action: AuditProfileUpdate, Before: `{"live_name":null}`, After: `{"live_name":"121"}`}
+	b, err := json.Marshal(event)
+	require.NoError(err)
+
+	var mp map[string]interface{}
+	require.NoError(json.Unmarshal(b, &mp))
+	require.Equal(AuditProfileUpdate, mp["action"])
+	require.Equal(map[string]interface{}{"live_name": "121"}, mp["after"], "Diff should be embedded as json.")
+
+	b, err = json.Marshal(&AuditEvent{Action: AuditLoginSuccess})
+	require.NoError(err)
+	require.NotContains(string(b), "before", "Empty diff should be omitted.")
+}
+
+func TestAPIToken(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Now()
+	token := &APIToken{Name: "bot", Hash: "hash", Scopes: "profile:write stream:key"}
+	require.False(token.IsExpired(now), "Token without expiry never expires.")
+	expires := now.Add(time.Hour)
+	token.ExpiresAt = &expires
+	require.False(token.IsExpired(now))
+	require.True(token.IsExpired(expires))
+
+	info := NewAPITokenInfoFromAPIToken(token)
+	require.Equal([]string{ScopeProfileWrite, ScopeStreamKey}, info.Scopes)
+	require.Equal([]string{ScopeProfileWrite, ScopeStreamKey, ScopeProfileRead}, token.GrantedScopes(), "Write should imply read.")
+	token.Scopes = "profile:read profile:write"
+	require.Equal([]string{ScopeProfileRead, ScopeProfileWrite}, token.GrantedScopes())
+	b, err := json.Marshal(info)
+	require.NoError(err)
+	require.NotContains(string(b), "hash", "Token hash should never be shown.")
+}
+
+func TestChangePrivacyModel(t *testing.T) {
+	require := require.New(t)
+
+	user := &User{HideFollowers: true}
+	yes, no := true, false
+	req := &ChangePrivacyModel{HideFollowings: &yes, HideFollowers: &no}
+	privacy := req.Apply(*GetPrivacyFromUser(user))
+	require.Equal(Privacy{HideFollowings: true}, privacy)
+	require.Equal(privacy, (&ChangePrivacyModel{}).Apply(privacy), "Settings not sent should be kept.")
+	require.True(GetMeFromUser(user).Privacy.HideFollowers)
+}
+
+func TestWatchSession(t *testing.T) {
+	require := require.New(t)
+
+	start := time.Date(2021, 5, 1, 20, 0, 0, 0, time.UTC)
+	now := start.Add(10 * time.Minute)
+	session := &WatchSession{BroadcastStart: start, StartedAt: now, EndedAt: now}
+	gap := 2 * time.Minute
+
+	now = now.Add(30 * time.Second)
+	require.True(session.Continues(start, now, gap))
+	session.Heartbeat(now, 630)
+	require.Equal(uint(30), session.Duration)
+	require.Equal(uint(630), session.Position)
+	require.Equal(now, session.EndedAt)
+
+	require.False(session.Continues(start, now.Add(gap+time.Second), gap), "A long gap should start a new session.")
+	require.False(session.Continues(start.Add(time.Hour), now.Add(time.Second), gap), "Another broadcast should start a new session.")
+	require.False(session.Continues(start, now.Add(-time.Second), gap))
+}
+
+func TestRankRecommendations(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Date(2021, 5, 1, 20, 0, 0, 0, time.UTC)
+	signals := []*RecommendSignals{
+		{Username: "stranger"},
+		{Username: "popular", Living: true, Watching: 100},
+		{Username: "quiet", Living: true},
+		{Username: "friend", FollowedBy: 3},
+		{Username: "watched", LastWatched: now.Add(-time.Hour), CategoryAffinity: 0.5},
+		{Username: "forgotten", LastWatched: now.Add(-60 * 24 * time.Hour)},
+		{Username: "also-quiet", Living: true},
+	}
+	list := RankRecommendations(signals, now, 10)
+	names := make([]string, len(list))
+	for i, r := range list {
+		names[i] = r.Username
+	}
+	require.Equal([]string{"friend", "popular", "watched", "also-quiet", "quiet", "forgotten"}, names,
+		"Channels without signals aren't recommended, ties are ranked by username.")
+	require.Equal([]string{ReasonLiving, ReasonPopular}, list[1].Reasons)
+	require.Equal([]string{ReasonWatched, ReasonCategory}, list[2].Reasons)
+	require.Equal(list, RankRecommendations(signals, now, 10), "Ranking should be deterministic.")
+	require.Len(RankRecommendations(signals, now, 2), 2)
+}
+
+func TestSortFollowingChannels(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+	channels := []*FollowingChannel{
+		{Username: "never"},
+		{Username: "earlier", LastLive: &earlier},
+		{Username: "quiet", Living: true, Watching: 1},
+		{Username: "just-now", LastLive: &now},
+		{Username: "popular", Living: true, Watching: 50},
+		{Username: "also-quiet", Living: true, Watching: 1},
+	}
+	SortFollowingChannels(channels)
+	names := make([]string, len(channels))
+	for i, channel := range channels {
+		names[i] = channel.Username
+	}
+	require.Equal([]string{"popular", "also-quiet", "quiet", "just-now", "earlier", "never"}, names)
+}
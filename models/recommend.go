@@ -0,0 +1,109 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Recommendation reasons
+const (
+	ReasonFollowedByFollowings = "followed_by_followings"
+	ReasonWatched              = "watched"
+	ReasonCategory             = "category"
+	ReasonLiving               = "living"
+	ReasonPopular              = "popular"
+)
+
+// Recommendation signal weights, a channel two of your followings follow
+// outweighs one you watched a week ago, which outweighs a popular stranger.
+const (
+	recommendFollowWeight   = 3.0
+	recommendWatchWeight    = 2.0
+	recommendCategoryWeight = 1.5
+	recommendLivingWeight   = 1.0
+	recommendViewerWeight   = 0.5
+	// recommendWatchHalfLife - a watch counts half as much after it
+	recommendWatchHalfLife = 7 * 24 * time.Hour
+	// recommendPopularViewers - viewers a living channel needs to be popular
+	recommendPopularViewers = 10
+)
+
+// RecommendSignals - what's known about a channel recommended to a viewer
+type RecommendSignals struct {
+	Username string
+	// FollowedBy - how many of the viewer's followings follow the channel
+	FollowedBy int
+	// LastWatched - when the viewer last watched the channel, zero if never
+	LastWatched time.Time
+	// CategoryAffinity - share of channels the viewer follows or watched in the channel's category, 0 to 1
+	CategoryAffinity float64
+	Living           bool
+	Watching         int
+}
+
+// Recommendation - a recommended channel, with why it's recommended
+type Recommendation struct {
+	Username string      `json:"username"`
+	Score    float64     `json:"score"`
+	Reasons  []string    `json:"reasons"`
+	User     *PublicUser `json:"user"`
+}
+
+// RecommendQueryModel - recommendations request model
+type RecommendQueryModel struct {
+	Num int `form:"num" binding:"omitempty,min=1,max=50"`
+}
+
+// Score - score of channel at now, the higher the better. Counts are
+// log scaled so a few huge signals can't drown the others.
+func (s *RecommendSignals) Score(now time.Time) (score float64, reasons []string) {
+	reasons = make([]string, 0, 4)
+	if s.FollowedBy > 0 {
+		score += recommendFollowWeight * math.Log2(1+float64(s.FollowedBy))
+		reasons = append(reasons, ReasonFollowedByFollowings)
+	}
+	if !s.LastWatched.IsZero() {
+		age := now.Sub(s.LastWatched)
+		if age < 0 {
+			age = 0
+		}
+		score += recommendWatchWeight * math.Exp2(-float64(age)/float64(recommendWatchHalfLife))
+		reasons = append(reasons, ReasonWatched)
+	}
+	if s.CategoryAffinity > 0 {
+		score += recommendCategoryWeight * s.CategoryAffinity
+		reasons = append(reasons, ReasonCategory)
+	}
+	if s.Living {
+		score += recommendLivingWeight + recommendViewerWeight*math.Log2(1+float64(s.Watching))
+		reasons = append(reasons, ReasonLiving)
+		if s.Watching >= recommendPopularViewers {
+			reasons = append(reasons, ReasonPopular)
+		}
+	}
+	return score, reasons
+}
+
+// RankRecommendations - the n best scored channels at now, best first, ties by username.
+// Channels without any signal aren't recommended.
+func RankRecommendations(signals []*RecommendSignals, now time.Time, n int) []*Recommendation {
+	list := make([]*Recommendation, 0, len(signals))
+	for _, s := range signals {
+		score, reasons := s.Score(now)
+		if score <= 0 {
+			continue
+		}
+		list = append(list, &Recommendation{Username: s.Username, Score: score, Reasons: reasons})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Score != list[j].Score {
+			return list[i].Score > list[j].Score
+		}
+		return list[i].Username < list[j].Username
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
@@ -0,0 +1,77 @@
+package models
+
+import "time"
+
+// Report target type
+const (
+	ReportTargetUser = "user"
+	ReportTargetRoom = "room"
+	ReportTargetChat = "chat"
+)
+
+// Report status
+const (
+	ReportOpen      = "open"
+	ReportActioned  = "actioned"
+	ReportDismissed = "dismissed"
+)
+
+// Report - a viewer reports a user, a room or a chat message for abuse
+type Report struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	ReporterID uint `gorm:"not null;unique_index:idx_report_open" json:"reporter_id"`
+	// TargetUserID - the reported user, owner of the room or sender of the message
+	TargetUserID   uint   `gorm:"not null;index;unique_index:idx_report_open" json:"target_user_id"`
+	TargetUsername string `gorm:"type:varchar(20);not null" json:"target_username"`
+	TargetType     string `gorm:"type:varchar(10);not null;unique_index:idx_report_open" json:"target_type"`
+	MessageID      string `gorm:"type:varchar(64);not null;default:'';unique_index:idx_report_open" json:"message_id"`
+	Category       string `gorm:"type:varchar(20);not null" json:"category"`
+	Detail         string `gorm:"type:varchar(500);not null;default:''" json:"detail"`
+
+	Status     string     `gorm:"type:varchar(10);not null;index" json:"status"`
+	AssigneeID *uint      `gorm:"index" json:"assignee_id"`
+	ResolvedBy *uint      `json:"resolved_by"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	Note       string     `gorm:"type:varchar(500);not null;default:''" json:"note"`
+	// SuspendedAt - links the suspension of target user issued for this report
+	SuspendedAt *time.Time `json:"suspended_at"`
+	// OpenKey - true while open and NULL once closed, NULLs don't collide in the unique
+	// index, so a reporter has at most one open report on a target
+	OpenKey *bool `gorm:"unique_index:idx_report_open" json:"-"`
+}
+
+// ReportModel - report request model
+type ReportModel struct {
+	TargetType string `json:"target_type" form:"target_type" binding:"required,oneof=user room chat"`
+	Target     string `json:"target" form:"target" binding:"required,max=20"`
+	MessageID  string `json:"message_id" form:"message_id" binding:"required_if=TargetType chat,max=64"`
+	Category   string `json:"category" form:"category" binding:"required,oneof=spam harassment hate violence sexual copyright other"`
+	Detail     string `json:"detail" form:"detail" binding:"max=500"`
+}
+
+// ReportQueryModel - admin list reports request model
+type ReportQueryModel struct {
+	Status   string `form:"status" binding:"omitempty,oneof=open actioned dismissed"`
+	Assignee string `form:"assignee" binding:"max=20"`
+	Target   string `form:"target" binding:"max=20"`
+	Page     int    `form:"page" binding:"omitempty,min=1"`
+	Size     int    `form:"size" binding:"omitempty,min=1,max=100"`
+}
+
+// AssignReportModel - admin assign report request model, assign to self if empty
+type AssignReportModel struct {
+	Assignee string `json:"assignee" form:"assignee" binding:"max=20"`
+}
+
+// ResolveReportModel - admin resolve report request model,
+// target user is suspended too if Suspend is set.
+type ResolveReportModel struct {
+	Status  string     `json:"status" form:"status" binding:"required,oneof=actioned dismissed"`
+	Note    string     `json:"note" form:"note" binding:"max=500"`
+	Suspend bool       `json:"suspend" form:"suspend"`
+	Reason  string     `json:"reason" form:"reason" binding:"required_if=Suspend true,max=200"`
+	Until   *time.Time `json:"until" form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+}
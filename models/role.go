@@ -0,0 +1,91 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+// Role name
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+)
+
+// Permission name
+const (
+	PermAdminAccess    = "admin:access"
+	PermLogLevel       = "log:level"
+	PermUserRead       = "user:read"
+	PermUserUnlock     = "user:unlock"
+	PermUserSuspend    = "user:suspend"
+	PermUserSession    = "user:session"
+	PermUserDelete     = "user:delete"
+	PermStreamEnd      = "stream:end"
+	PermReportModerate = "report:moderate"
+	PermAuditRead      = "audit:read"
+	PermRoleAssign     = "role:assign"
+)
+
+// RolePermissions - default permissions of roles, seeded into MySQL on startup
+var RolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermAdminAccess, PermLogLevel, PermUserRead, PermUserUnlock, PermUserSuspend,
+		PermUserSession, PermUserDelete, PermStreamEnd, PermReportModerate, PermAuditRead,
+		PermRoleAssign,
+	},
+	RoleModerator: {
+		PermAdminAccess, PermUserRead, PermUserUnlock, PermUserSuspend, PermStreamEnd, PermReportModerate,
+	},
+}
+
+// Role - a named set of permissions
+type Role struct {
+	gorm.Model
+	Name        string       `gorm:"type:varchar(20);unique_index;not null"`
+	Permissions []Permission `gorm:"many2many:role_permission"`
+}
+
+// Permission - allow to do something
+type Permission struct {
+	gorm.Model
+	Name string `gorm:"type:varchar(30);unique_index;not null"`
+}
+
+// RoleNames - names of user's roles
+func (u *User) RoleNames() []string {
+	names := make([]string, 0, len(u.Roles))
+	for _, role := range u.Roles {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+// PermissionNames - all permissions granted by user's roles, no duplicates
+func (u *User) PermissionNames() []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, role := range u.Roles {
+		for _, perm := range role.Permissions {
+			if !seen[perm.Name] {
+				seen[perm.Name] = true
+				names = append(names, perm.Name)
+			}
+		}
+	}
+	return names
+}
+
+// CanManage - whether u may act on other as admin, nobody manages oneself or
+// a user holding a permission u lacks, so a moderator can't suspend an admin
+func (u *User) CanManage(other *User) bool {
+	if u.ID == other.ID {
+		return false
+	}
+	own := make(map[string]bool)
+	for _, perm := range u.PermissionNames() {
+		own[perm] = true
+	}
+	for _, perm := range other.PermissionNames() {
+		if !own[perm] {
+			return false
+		}
+	}
+	return true
+}
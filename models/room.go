@@ -8,4 +8,6 @@ type Room struct {
 	UserID uint
 	Name   *string `gorm:"type:varchar(30)"`
 	Intro  *string `gorm:"type:varchar(200)"`
+	// Category - what the room streams, used to recommend it
+	Category *string `gorm:"type:varchar(20);index"`
 }
@@ -1,17 +1,57 @@
 package models
 
 import (
+	"minitube/utils"
+	"time"
+
 	"github.com/jinzhu/gorm"
+	"go.uber.org/zap/zapcore"
 )
 
 // User - minitube user
 type User struct {
 	gorm.Model
 	Username string  `gorm:"type:varchar(20);unique_index;not null"`
-	Password string  `gorm:"type:char(64);not null"`
+	Password string  `gorm:"type:varchar(255);not null"`
 	Email    *string `gorm:"type:varchar(50);unique_index"`
 	Phone    *string `gorm:"type:varchar(18);unique_index"`
 	Room     Room
+	Roles    []Role `gorm:"many2many:user_role"`
+
+	// TokenVersion - bumped to revoke all issued tokens
+	TokenVersion uint `gorm:"not null;default:0"`
+	// PasswordResetRequired - user must change password before using the account
+	PasswordResetRequired bool `gorm:"not null;default:false"`
+
+	SuspendedAt    *time.Time
+	SuspendedUntil *time.Time // nil means forever
+	SuspendReason  *string    `gorm:"type:varchar(200)"`
+	SuspendedBy    *uint
+
+	// UsernameChangedAt - when username is changed last time
+	UsernameChangedAt *time.Time
+
+	// DeletionScheduledAt - account is deleted at this time, unless user logins before
+	DeletionScheduledAt *time.Time `gorm:"index"`
+
+	// privacy settings
+	HideFollowers  bool `gorm:"not null;default:false"`
+	HideFollowings bool `gorm:"not null;default:false"`
+	HistoryPaused  bool `gorm:"not null;default:false"`
+}
+
+// MarshalLogObject - log user without password, email and phone are masked
+func (u *User) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddUint("id", u.ID)
+	enc.AddString("username", u.Username)
+	enc.AddString("password", utils.Redacted)
+	if u.Email != nil {
+		enc.AddString("email", utils.MaskEmail(*u.Email))
+	}
+	if u.Phone != nil {
+		enc.AddString("phone", utils.MaskPhone(*u.Phone))
+	}
+	return nil
 }
 
 // NewUser - return a user by username and password
@@ -22,6 +62,22 @@ func NewUser(username, password string) *User {
 	}
 }
 
+// HasPassword - whether user can login with password, users signed up with OIDC
+// have none until they set one
+func (u *User) HasPassword() bool {
+	return u.Password != ""
+}
+
+// IsSuspended - whether user is suspended at now
+func (u *User) IsSuspended(now time.Time) bool {
+	return u.SuspendedAt != nil && (u.SuspendedUntil == nil || now.Before(*u.SuspendedUntil))
+}
+
+// IsDeletionPending - whether user asked to delete the account
+func (u *User) IsDeletionPending() bool {
+	return u.DeletionScheduledAt != nil
+}
+
 // NewUserFromMap - return a user from map
 func NewUserFromMap(mp map[string]string) *User {
 	// utils.Sugar.Debugf("NewUserFromMap: <%v> <%v>", mp["username"], mp["password"])
@@ -41,6 +97,9 @@ func NewUserFromMap(mp map[string]string) *User {
 	if v, ok := mp["live_intro"]; ok {
 		user.Room.Intro = &v
 	}
+	if v, ok := mp["live_category"]; ok {
+		user.Room.Category = &v
+	}
 	return user
 }
 
@@ -56,6 +115,15 @@ func NewUserFromRegister(reg *RegisterModel) *User {
 	return user
 }
 
+// UsernameReservation - old username of user, redirects to user and can't be taken until expires
+type UsernameReservation struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	Username  string    `gorm:"type:varchar(20);unique_index;not null"`
+	UserID    uint      `gorm:"index;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
+
 // Follow - user's follow associations
 // type Follow struct {
 // 	gorm.Model
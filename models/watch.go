@@ -0,0 +1,81 @@
+package models
+
+import "time"
+
+// WatchSession - a viewer watching a broadcast of a channel, from the first heartbeat
+// to the last. Heartbeats further apart than a gap start a new session.
+type WatchSession struct {
+	ID        uint `gorm:"primary_key"`
+	UserID    uint `gorm:"index:idx_watch_session_user_channel;not null"`
+	ChannelID uint `gorm:"index:idx_watch_session_user_channel;index;not null"`
+	// BroadcastStart - when the watched broadcast started, identifies it
+	BroadcastStart time.Time `gorm:"not null"`
+	StartedAt      time.Time `gorm:"index;not null"`
+	EndedAt        time.Time `gorm:"not null"`
+	// Duration - seconds watched
+	Duration uint `gorm:"not null;default:0"`
+	// Position - seconds into the broadcast at the last heartbeat, to resume from
+	Position uint `gorm:"not null;default:0"`
+}
+
+// Continues - whether a heartbeat for broadcast at now continues session,
+// it does if it's the same broadcast and the last heartbeat isn't older than gap
+func (s *WatchSession) Continues(broadcastStart, now time.Time, gap time.Duration) bool {
+	return s.BroadcastStart.Equal(broadcastStart) && !now.Before(s.EndedAt) && now.Sub(s.EndedAt) <= gap
+}
+
+// Heartbeat - extend session to now, at position of the broadcast
+func (s *WatchSession) Heartbeat(now time.Time, position uint) {
+	s.Duration += uint(now.Sub(s.EndedAt) / time.Second)
+	s.EndedAt = now
+	s.Position = position
+}
+
+// WatchSessionInfo - watch session shown to its viewer
+type WatchSessionInfo struct {
+	ID uint `json:"id"`
+	// Channel - username of the watched channel
+	Channel        string    `json:"channel"`
+	BroadcastStart time.Time `json:"broadcast_start"`
+	StartedAt      time.Time `json:"started_at"`
+	EndedAt        time.Time `json:"ended_at"`
+	Duration       uint      `json:"duration"`
+	Position       uint      `json:"position"`
+}
+
+// NewWatchSessionInfo - new watch session info of session on channel
+func NewWatchSessionInfo(s *WatchSession, channel string) *WatchSessionInfo {
+	return &WatchSessionInfo{
+		ID:             s.ID,
+		Channel:        channel,
+		BroadcastStart: s.BroadcastStart,
+		StartedAt:      s.StartedAt,
+		EndedAt:        s.EndedAt,
+		Duration:       s.Duration,
+		Position:       s.Position,
+	}
+}
+
+// WatchDay - time watched in a day, by when sessions started
+type WatchDay struct {
+	// Day - 2006-01-02
+	Day      string `json:"day"`
+	Duration uint   `json:"duration"`
+	Sessions int    `json:"sessions"`
+}
+
+// WatchHeartbeatModel - watch heartbeat request model, sent by the player while playing
+type WatchHeartbeatModel struct {
+	Position uint `json:"position" form:"position"`
+}
+
+// WatchSessionQueryModel - list watch sessions request model
+type WatchSessionQueryModel struct {
+	Page int `form:"page" binding:"omitempty,min=1"`
+	Size int `form:"size" binding:"omitempty,min=1,max=100"`
+}
+
+// WatchStatsQueryModel - time watched request model
+type WatchStatsQueryModel struct {
+	Days int `form:"days" binding:"omitempty,min=1,max=366"`
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"minitube/models"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ScheduleUserDeletion - delete user's account at, and revoke user's sessions.
+func ScheduleUserDeletion(ctx context.Context, user *models.User, at time.Time) error {
+	return updateUser(ctx, "ScheduleUserDeletion", user, map[string]interface{}{
+		"deletion_scheduled_at": &at,
+		"token_version":         gorm.Expr("token_version + 1"),
+	})
+}
+
+// CancelUserDeletion - keep user's account, e.g. user logins in grace period.
+func CancelUserDeletion(ctx context.Context, user *models.User) error {
+	return updateUser(ctx, "CancelUserDeletion", user, map[string]interface{}{
+		"deletion_scheduled_at": nil,
+	})
+}
+
+// GetUsersDueForDeletion - at most limit users whose deletion is scheduled before now
+func GetUsersDueForDeletion(ctx context.Context, now time.Time, limit int) (users []*models.User, err error) {
+	_, span := startMySQLSpan(ctx, "getUsersDueForDeletion", attribute.Int("limit", limit))
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	users = make([]*models.User, 0, limit)
+	err = db.Where("deletion_scheduled_at <= ?", now).Order("deletion_scheduled_at").Limit(limit).Find(&users).Error
+	if err != nil {
+		logger(ctx).Warnw("Get users due for deletion from Mysql failed", "error", err)
+		return nil, ErrMySQLFailed
+	}
+	return users, nil
+}
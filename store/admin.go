@@ -0,0 +1,182 @@
+package store
+
+import (
+	"context"
+	"minitube/models"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SearchUsers - find users whose username, email or phone starts with query,
+// return one page of users ordered by id and the total number of matches.
+func SearchUsers(ctx context.Context, query string, page, size int) (users []*models.User, total int, err error) {
+	_, span := startMySQLSpan(ctx, "searchUsers", attribute.Int("page", page))
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	tx := db.Model(&models.User{})
+	if query != "" {
+		like := escapeLike(query) + "%"
+		tx = tx.Where("username LIKE ? OR email LIKE ? OR phone LIKE ?", like, like, like)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		logger(ctx).Warnw("Count users from Mysql failed", "query", query, "error", err)
+		return nil, 0, ErrMySQLFailed
+	}
+	users = make([]*models.User, 0, size)
+	err = tx.Preload("Roles").Order("id").Offset((page - 1) * size).Limit(size).Find(&users).Error
+	if err != nil {
+		logger(ctx).Warnw("Search users from Mysql failed", "query", query, "error", err)
+		return nil, 0, ErrMySQLFailed
+	}
+	return users, total, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// SuspendUser - suspend user until (nil means forever) and revoke user's sessions.
+func SuspendUser(ctx context.Context, user *models.User, reason string, until *time.Time, by uint) error {
+	now := time.Now()
+	return updateUser(ctx, "SuspendUser", user, map[string]interface{}{
+		"suspended_at":    &now,
+		"suspended_until": until,
+		"suspend_reason":  &reason,
+		"suspended_by":    &by,
+		"token_version":   gorm.Expr("token_version + 1"),
+	})
+}
+
+// UnsuspendUser - lift user's suspension.
+func UnsuspendUser(ctx context.Context, user *models.User) error {
+	return updateUser(ctx, "UnsuspendUser", user, map[string]interface{}{
+		"suspended_at":    nil,
+		"suspended_until": nil,
+		"suspend_reason":  nil,
+		"suspended_by":    nil,
+	})
+}
+
+// RequirePasswordReset - force user to change password and revoke user's sessions.
+func RequirePasswordReset(ctx context.Context, user *models.User) error {
+	return updateUser(ctx, "RequirePasswordReset", user, map[string]interface{}{
+		"password_reset_required": true,
+		"token_version":           gorm.Expr("token_version + 1"),
+	})
+}
+
+// RevokeSessions - invalidate all tokens issued to user.
+func RevokeSessions(ctx context.Context, user *models.User) error {
+	return updateUser(ctx, "RevokeSessions", user, map[string]interface{}{
+		"token_version": gorm.Expr("token_version + 1"),
+	})
+}
+
+// updateUser - update columns of user in mysql, then refresh user cached in redis
+func updateUser(ctx context.Context, name string, user *models.User, fields map[string]interface{}) (err error) {
+	ctx, span := startSpan(ctx, name)
+	defer func() { endSpan(span, err) }()
+
+	err = updateUserToMysql(ctx, user, fields)
+	if err != nil {
+		return err
+	}
+	// reload so redis caches exactly what mysql has, fields may be sql expressions
+	// like token_version + 1 whose result only mysql knows
+	fresh, err := getUserByIDFromMysql(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	*user = *fresh
+	return saveUserToRedis(ctx, user)
+}
+
+func updateUserToMysql(ctx context.Context, user *models.User, fields map[string]interface{}) (err error) {
+	_, span := startMySQLSpan(ctx, "updateUser")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	err = db.Model(user).Updates(fields).Error
+	if err != nil {
+		logger(ctx).Warnw("Update user to Mysql failed", "user", user, "error", err)
+		return ErrMySQLFailed
+	}
+	return nil
+}
+
+// DeleteUser - delete user, user's room and roles, and everything kept for user in redis.
+func DeleteUser(ctx context.Context, user *models.User) (err error) {
+	ctx, span := startSpan(ctx, "DeleteUser")
+	defer func() { endSpan(span, err) }()
+
+	err = deleteUserFromMysql(ctx, user)
+	if err != nil {
+		return err
+	}
+	return deleteUserFromRedis(ctx, user)
+}
+
+func deleteUserFromMysql(ctx context.Context, user *models.User) (err error) {
+	_, span := startMySQLSpan(ctx, "deleteUser")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	err = tx.Model(user).Association("Roles").Clear().Error
+	if err != nil {
+		tx.Rollback()
+		logger(ctx).Warnw("Delete user's roles from Mysql failed", "user", user, "error", err)
+		return ErrMySQLFailed
+	}
+	err = tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.Room{}).Error
+	if err != nil {
+		tx.Rollback()
+		logger(ctx).Warnw("Delete user's room from Mysql failed", "user", user, "error", err)
+		return ErrMySQLFailed
+	}
+	// old usernames are released, and no longer redirect
+	err = tx.Where("user_id = ?", user.ID).Delete(&models.UsernameReservation{}).Error
+	if err != nil {
+		tx.Rollback()
+		logger(ctx).Warnw("Delete user's username reservations from Mysql failed", "user", user, "error", err)
+		return ErrMySQLFailed
+	}
+	err = tx.Where("user_id = ?", user.ID).Delete(&models.APIToken{}).Error
+	if err != nil {
+		tx.Rollback()
+		logger(ctx).Warnw("Delete user's api tokens from Mysql failed", "user", user, "error", err)
+		return ErrMySQLFailed
+	}
+	err = tx.Where("user_id = ?", user.ID).Delete(&models.Identity{}).Error
+	if err != nil {
+		tx.Rollback()
+		logger(ctx).Warnw("Delete user's identities from Mysql failed", "user", user, "error", err)
+		return ErrMySQLFailed
+	}
+	// sessions on the user's channel too, they'd have no channel
+	err = tx.Where("user_id = ? OR channel_id = ?", user.ID, user.ID).Delete(&models.WatchSession{}).Error
+	if err != nil {
+		tx.Rollback()
+		logger(ctx).Warnw("Delete user's watch sessions from Mysql failed", "user", user, "error", err)
+		return ErrMySQLFailed
+	}
+	// hard delete, so username, email and phone can be used again
+	err = tx.Unscoped().Delete(user).Error
+	if err != nil {
+		tx.Rollback()
+		logger(ctx).Warnw("Delete user from Mysql failed", "user", user, "error", err)
+		return ErrMySQLFailed
+	}
+	return tx.Commit().Error
+}
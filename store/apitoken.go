@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"minitube/models"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrAPITokenNotExists - API token is unknown or revoked
+var ErrAPITokenNotExists = fmt.Errorf("%w api token not exists", ErrMySQLFailed)
+
+// SaveAPIToken - save a new API token
+func SaveAPIToken(ctx context.Context, token *models.APIToken) (err error) {
+	_, span := startMySQLSpan(ctx, "saveAPIToken")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	err = db.Create(token).Error
+	if err != nil {
+		logger(ctx).Warnw("Save api token to Mysql failed", "user", token.UserID, "error", err)
+		return ErrMySQLFailed
+	}
+	return nil
+}
+
+// GetAPITokenByHash - get API token by hash of the token
+func GetAPITokenByHash(ctx context.Context, hash string) (token *models.APIToken, err error) {
+	_, span := startMySQLSpan(ctx, "getAPITokenByHash")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	token = new(models.APIToken)
+	err = db.Where("hash = ?", hash).First(token).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, ErrAPITokenNotExists
+		}
+		logger(ctx).Warnw("Get api token from Mysql failed", "error", err)
+		return nil, ErrMySQLFailed
+	}
+	return token, nil
+}
+
+// ListAPITokens - user's API tokens, newest first
+func ListAPITokens(ctx context.Context, userID uint) (tokens []*models.APIToken, err error) {
+	_, span := startMySQLSpan(ctx, "listAPITokens")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tokens = make([]*models.APIToken, 0)
+	err = db.Where("user_id = ?", userID).Order("id DESC").Find(&tokens).Error
+	if err != nil {
+		logger(ctx).Warnw("List api tokens from Mysql failed", "user", userID, "error", err)
+		return nil, ErrMySQLFailed
+	}
+	return tokens, nil
+}
+
+// CountAPITokens - number of user's API tokens
+func CountAPITokens(ctx context.Context, userID uint) (n int, err error) {
+	_, span := startMySQLSpan(ctx, "countAPITokens")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	err = db.Model(&models.APIToken{}).Where("user_id = ?", userID).Count(&n).Error
+	if err != nil {
+		logger(ctx).Warnw("Count api tokens from Mysql failed", "user", userID, "error", err)
+		return 0, ErrMySQLFailed
+	}
+	return n, nil
+}
+
+// DeleteAPIToken - revoke user's API token of id
+func DeleteAPIToken(ctx context.Context, userID, id uint) (token *models.APIToken, err error) {
+	_, span := startMySQLSpan(ctx, "deleteAPIToken")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	token = new(models.APIToken)
+	err = db.Where("id = ? AND user_id = ?", id, userID).First(token).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, ErrAPITokenNotExists
+		}
+		logger(ctx).Warnw("Get api token from Mysql failed", "user", userID, "error", err)
+		return nil, ErrMySQLFailed
+	}
+	err = db.Delete(token).Error
+	if err != nil {
+		logger(ctx).Warnw("Delete api token from Mysql failed", "user", userID, "error", err)
+		return nil, ErrMySQLFailed
+	}
+	return token, nil
+}
+
+// TouchAPIToken - record API token is used at now from ip
+func TouchAPIToken(ctx context.Context, token *models.APIToken, now time.Time, ip string) (err error) {
+	_, span := startMySQLSpan(ctx, "touchAPIToken")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	err = db.Model(token).Updates(map[string]interface{}{
+		"last_used_at": &now,
+		"last_used_ip": ip,
+	}).Error
+	if err != nil {
+		logger(ctx).Warnw("Touch api token to Mysql failed", "user", token.UserID, "error", err)
+		return ErrMySQLFailed
+	}
+	return nil
+}
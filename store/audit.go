@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"minitube/models"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Audit events are append only, there is no update or delete.
+
+// AuditFilter - filter of ListAuditEvents, zero value fields match any
+type AuditFilter struct {
+	ActorID      uint
+	TargetUserID uint
+	// Action - match action or actions under it, e.g. "admin.user"
+	Action string
+	From   *time.Time
+	To     *time.Time
+	// OwnOnly - only events done by target user or anonymous, e.g. failed logins
+	OwnOnly bool
+}
+
+// SaveAuditEvent - append an audit event
+func SaveAuditEvent(ctx context.Context, event *models.AuditEvent) (err error) {
+	_, span := startMySQLSpan(ctx, "saveAuditEvent", attribute.String("action", event.Action))
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	err = db.Create(event).Error
+	if err != nil {
+		logger(ctx).Warnw("Save audit event to Mysql failed", "action", event.Action, "error", err)
+		return ErrMySQLFailed
+	}
+	return nil
+}
+
+// ListAuditEvents - one page of audit events newest first, and the total number of matches
+func ListAuditEvents(ctx context.Context, filter *AuditFilter, page, size int) (events []*models.AuditEvent, total int, err error) {
+	_, span := startMySQLSpan(ctx, "listAuditEvents", attribute.String("action", filter.Action))
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	tx := db.Model(&models.AuditEvent{})
+	if filter.ActorID != 0 {
+		tx = tx.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.TargetUserID != 0 {
+		tx = tx.Where("target_user_id = ?", filter.TargetUserID)
+	}
+	if filter.OwnOnly {
+		tx = tx.Where("actor_id = target_user_id OR actor_id IS NULL")
+	}
+	if filter.Action != "" {
+		tx = tx.Where("action = ? OR action LIKE ?", filter.Action, escapeLike(strings.TrimSuffix(filter.Action, "."))+".%")
+	}
+	if filter.From != nil {
+		tx = tx.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		tx = tx.Where("created_at < ?", *filter.To)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		logger(ctx).Warnw("Count audit events from Mysql failed", "error", err)
+		return nil, 0, ErrMySQLFailed
+	}
+	events = make([]*models.AuditEvent, 0, size)
+	err = tx.Order("id DESC").Offset((page - 1) * size).Limit(size).Find(&events).Error
+	if err != nil {
+		logger(ctx).Warnw("List audit events from Mysql failed", "error", err)
+		return nil, 0, ErrMySQLFailed
+	}
+	return events, total, nil
+}
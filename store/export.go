@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"minitube/models"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Export jobs and their zip files are kept in redis, so any instance can
+// report status and serve the download, and they expire by themselves.
+
+// ErrExportNotExists - export job not found or expired
+var ErrExportNotExists = fmt.Errorf("%w export not exists", ErrRedisFailed)
+
+// SaveExport - save export job, and make it user's latest one, both kept for ttl
+func SaveExport(ctx context.Context, export *models.Export, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	bytes, err := json.Marshal(export)
+	if err != nil {
+		return err
+	}
+	pipe := client.TxPipeline()
+	pipe.Set(ctx, wrapExportKey(export.ID), bytes, ttl)
+	pipe.Set(ctx, wrapUserExportKey(export.UserID), export.ID, ttl)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		logger(ctx).Warnw("Save export to redis failed", "export", export.ID, "error", err)
+	}
+	return err
+}
+
+// GetExport - get export job by id
+func GetExport(ctx context.Context, id string) (*models.Export, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	bytes, err := client.Get(ctx, wrapExportKey(id)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrExportNotExists
+		}
+		logger(ctx).Warn("GetExport: ", err)
+		return nil, err
+	}
+	export := new(models.Export)
+	err = json.Unmarshal(bytes, export)
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// GetUserExport - get user's latest export job
+func GetUserExport(ctx context.Context, userID uint) (*models.Export, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
+
+	id, err := client.Get(ctx, wrapUserExportKey(userID)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrExportNotExists
+		}
+		logger(ctx).Warn("GetUserExport: ", err)
+		return nil, err
+	}
+	return GetExport(ctx, id)
+}
+
+// SaveExportFile - save zip file of export job for ttl
+func SaveExportFile(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
+
+	err := client.Set(ctx, wrapExportFileKey(id), data, ttl).Err()
+	if err != nil {
+		logger(ctx).Warnw("Save export file to redis failed", "export", id, "error", err)
+	}
+	return err
+}
+
+// GetExportFile - get zip file of export job
+func GetExportFile(ctx context.Context, id string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
+
+	data, err := client.Get(ctx, wrapExportFileKey(id)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrExportNotExists
+		}
+		logger(ctx).Warn("GetExportFile: ", err)
+		return nil, err
+	}
+	return data, nil
+}
+
+func wrapExportKey(id string) string {
+	return "export:" + id
+}
+
+func wrapExportFileKey(id string) string {
+	return "export:file:" + id
+}
+
+func wrapUserExportKey(userID uint) string {
+	return wrapUserKey("export:" + strconv.Itoa(int(userID)))
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"minitube/models"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// lastLiveKey - zset of when channels were last seen living, in unix seconds.
+// Broadcasts are ended by the live server, so living channels are recorded
+// periodically by UpdateLastLive, and when EndLiving ends them.
+const lastLiveKey = "lastlive"
+
+// followingLiveScript - all living followings of a user and the latest offline ones, in one step.
+// Each is returned as {username, living, watching, start time or last live}.
+//
+// KEYS[1] - following zset, KEYS[2] - living set, KEYS[3] - last live zset
+// ARGV[1] - prefix of watching counters, ARGV[2] - prefix of living start times
+// ARGV[3] - most offline followings returned
+var followingLiveScript = redis.NewScript(`
+local result = {}
+local function add_living(username)
+	local watching = redis.call("GET", ARGV[1] .. username) or "0"
+	local start = redis.call("GET", ARGV[2] .. username) or ""
+	table.insert(result, {username, 1, watching, start})
+end
+
+-- intersect followings with living channels, walking the smaller one
+if redis.call("ZCARD", KEYS[1]) <= redis.call("SCARD", KEYS[2]) then
+	for _, username in ipairs(redis.call("ZRANGE", KEYS[1], 0, -1)) do
+		if redis.call("SISMEMBER", KEYS[2], username) == 1 then
+			add_living(username)
+		end
+	end
+else
+	for _, username in ipairs(redis.call("SMEMBERS", KEYS[2])) do
+		if redis.call("ZSCORE", KEYS[1], username) then
+			add_living(username)
+		end
+	end
+end
+
+for _, username in ipairs(redis.call("ZREVRANGE", KEYS[1], 0, tonumber(ARGV[3]) - 1)) do
+	if redis.call("SISMEMBER", KEYS[2], username) == 0 then
+		local last = redis.call("ZSCORE", KEYS[3], username) or ""
+		table.insert(result, {username, 0, "0", last})
+	end
+end
+return result
+`)
+
+// GetFollowingLive - channels username follows as GetFollowingLiveFromRedis,
+// suspended channels and accounts being deleted are hidden
+func GetFollowingLive(ctx context.Context, username string, num int64) ([]*models.FollowingChannel, error) {
+	ctx, span := startSpan(ctx, "GetFollowingLive")
+	defer span.End()
+
+	channels, err := GetFollowingLiveFromRedis(ctx, username, num)
+	if err != nil {
+		return channels, err
+	}
+	usernames := make([]string, len(channels))
+	for i, channel := range channels {
+		usernames[i] = channel.Username
+	}
+	users, err := getUsersByUsername(ctx, usernames)
+	if err != nil {
+		return []*models.FollowingChannel{}, err
+	}
+
+	now := time.Now()
+	visible := channels[:0]
+	for _, channel := range channels {
+		user, ok := users[channel.Username]
+		if !ok || user.IsSuspended(now) || user.IsDeletionPending() {
+			continue
+		}
+		visible = append(visible, channel)
+	}
+	return visible, nil
+}
+
+// GetFollowingLiveFromRedis - all living channels username follows with their viewers and
+// start time, and of the latest num followings the offline ones with when they were last seen living
+func GetFollowingLiveFromRedis(ctx context.Context, username string, num int64) ([]*models.FollowingChannel, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
+
+	result, err := followingLiveScript.Run(ctx, client,
+		[]string{wrapFollowingKey(username), "living", lastLiveKey},
+		"watching:", "living:", num,
+	).Slice()
+	if err != nil {
+		logger(ctx).Warn("GetFollowingLiveFromRedis: ", err)
+		return []*models.FollowingChannel{}, err
+	}
+
+	channels := make([]*models.FollowingChannel, 0, len(result))
+	for _, r := range result {
+		fields, ok := r.([]interface{})
+		if !ok || len(fields) != 4 {
+			continue
+		}
+		channel := new(models.FollowingChannel)
+		channel.Username, _ = fields[0].(string)
+		living, _ := fields[1].(int64)
+		channel.Living = living == 1
+		watching, _ := fields[2].(string)
+		channel.Watching, _ = strconv.Atoi(watching)
+		at, _ := fields[3].(string)
+		if channel.Living {
+			if t, err := time.Parse(time.RFC3339, at); err == nil {
+				channel.StartTime = &t
+			}
+		} else if unix, err := strconv.ParseFloat(at, 64); err == nil {
+			t := time.Unix(int64(unix), 0)
+			channel.LastLive = &t
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+// UpdateLastLive - record channels living at now
+func UpdateLastLive(ctx context.Context, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
+
+	living, err := client.SMembers(ctx, "living").Result()
+	if err != nil {
+		logger(ctx).Warn("UpdateLastLive: ", err)
+		return 0, err
+	}
+	if len(living) == 0 {
+		return 0, nil
+	}
+	members := make([]*redis.Z, len(living))
+	for i, username := range living {
+		members[i] = &redis.Z{Score: float64(now.Unix()), Member: username}
+	}
+	err = client.ZAdd(ctx, lastLiveKey, members...).Err()
+	if err != nil {
+		logger(ctx).Warn("UpdateLastLive: ", err)
+		return 0, err
+	}
+	return len(living), nil
+}
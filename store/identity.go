@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"minitube/models"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jinzhu/gorm"
+)
+
+// ErrOIDCLoginNotExists - sign in at provider not started, expired or already finished
+var ErrOIDCLoginNotExists = fmt.Errorf("%w oidc login not exists", ErrRedisFailed)
+
+// GetUserByIdentity - get user signing in with subject at provider
+func GetUserByIdentity(ctx context.Context, provider, subject string) (user *models.User, err error) {
+	ctx, span := startSpan(ctx, "GetUserByIdentity")
+	defer func() { endSpan(span, err) }()
+
+	identity, err := getIdentityFromMysql(ctx, provider, subject)
+	if err != nil {
+		return nil, err
+	}
+	return GetUserByID(ctx, identity.UserID)
+}
+
+func getIdentityFromMysql(ctx context.Context, provider, subject string) (identity *models.Identity, err error) {
+	_, span := startMySQLSpan(ctx, "getIdentity")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	identity = new(models.Identity)
+	err = db.Where("provider = ? AND subject = ?", provider, subject).First(identity).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, ErrMySQLUserNotExists
+		}
+		logger(ctx).Warnw("Get identity from Mysql failed", "provider", provider, "error", err)
+		return nil, ErrMySQLFailed
+	}
+	return identity, nil
+}
+
+// SaveUserWithIdentity - create user who signs in with identity
+func SaveUserWithIdentity(ctx context.Context, user *models.User, identity *models.Identity) (err error) {
+	ctx, span := startSpan(ctx, "SaveUserWithIdentity")
+	defer func() { endSpan(span, err) }()
+
+	err = saveUserWithIdentityToMysql(ctx, user, identity)
+	if err != nil {
+		return err
+	}
+	return saveUserToRedis(ctx, user)
+}
+
+func saveUserWithIdentityToMysql(ctx context.Context, user *models.User, identity *models.Identity) (err error) {
+	_, span := startMySQLSpan(ctx, "saveUserWithIdentity")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	err = tx.Create(user).Error
+	if err != nil {
+		tx.Rollback()
+		logger(ctx).Warnw("Save user to Mysql failed", "user", user, "error", err)
+		return err
+	}
+	identity.UserID = user.ID
+	err = tx.Create(identity).Error
+	if err != nil {
+		tx.Rollback()
+		logger(ctx).Warnw("Save identity to Mysql failed", "user", user, "provider", identity.Provider, "error", err)
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// SaveOIDCLogin - keep sign in started at provider for ttl, by its state
+func SaveOIDCLogin(ctx context.Context, state string, login *models.OIDCLogin, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	bytes, err := json.Marshal(login)
+	if err != nil {
+		return err
+	}
+	return client.Set(ctx, wrapOIDCLoginKey(state), bytes, ttl).Err()
+}
+
+// TakeOIDCLogin - consume sign in of state, so the provider's response can't be replayed
+func TakeOIDCLogin(ctx context.Context, state string) (*models.OIDCLogin, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	bytes, err := client.GetDel(ctx, wrapOIDCLoginKey(state)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrOIDCLoginNotExists
+		}
+		logger(ctx).Warn("TakeOIDCLogin: ", err)
+		return nil, err
+	}
+	login := new(models.OIDCLogin)
+	err = json.Unmarshal(bytes, login)
+	if err != nil {
+		return nil, err
+	}
+	return login, nil
+}
+
+func wrapOIDCLoginKey(state string) string {
+	return "oidc:login:" + state
+}
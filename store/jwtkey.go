@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"minitube/models"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// GetJWTKeys - jwt signing keys not retired at now
+func GetJWTKeys(ctx context.Context, now time.Time) (keys []*models.JWTKey, err error) {
+	_, span := startMySQLSpan(ctx, "getJWTKeys")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	keys = make([]*models.JWTKey, 0)
+	err = db.Where("not_after IS NULL OR not_after > ?", now).Order("not_before DESC").Find(&keys).Error
+	if err != nil {
+		logger(ctx).Warnw("Get jwt keys from Mysql failed", "error", err)
+		return nil, ErrMySQLFailed
+	}
+	return keys, nil
+}
+
+const (
+	// jwtKeyRotationLock - MySQL named lock held by the instance rotating keys
+	jwtKeyRotationLock = "minitube:jwt_key_rotation"
+	// jwtKeyRotationLockWait - seconds to wait for the lock held by another instance
+	jwtKeyRotationLockWait = 10
+)
+
+// RotateJWTKey - save the new key and retire keys it replaces at notAfter, if due says the
+// newest key (nil if there's none) is due for rotation, return whether key is saved.
+// Instances rotate one at a time under a named lock and check due again inside it,
+// so a key made by another instance meanwhile is never retired at once.
+func RotateJWTKey(ctx context.Context, due func(newest *models.JWTKey) bool, key *models.JWTKey, notAfter time.Time) (rotated bool, err error) {
+	_, span := startMySQLSpan(ctx, "rotateJWTKey")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return false, err
+	}
+
+	// the lock belongs to a connection, so hold one until it's released
+	conn, err := db.DB().Conn(ctx)
+	if err != nil {
+		logger(ctx).Warnw("Get Mysql connection failed", "error", err)
+		return false, ErrMySQLFailed
+	}
+	defer conn.Close()
+	var locked sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", jwtKeyRotationLock, jwtKeyRotationLockWait).Scan(&locked)
+	if err != nil || locked.Int64 != 1 {
+		logger(ctx).Warnw("Lock jwt key rotation in Mysql failed", "error", err)
+		return false, ErrMySQLFailed
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), "DO RELEASE_LOCK(?)", jwtKeyRotationLock); err != nil {
+			logger(ctx).Warnw("Unlock jwt key rotation in Mysql failed", "error", err)
+		}
+	}()
+
+	tx := db.Begin()
+	newest := new(models.JWTKey)
+	err = tx.Where("not_after IS NULL").Order("not_before DESC").First(newest).Error
+	if gorm.IsRecordNotFoundError(err) {
+		newest, err = nil, nil
+	}
+	if err != nil {
+		tx.Rollback()
+		logger(ctx).Warnw("Get newest jwt key from Mysql failed", "error", err)
+		return false, ErrMySQLFailed
+	}
+	if !due(newest) {
+		tx.Rollback()
+		return false, nil
+	}
+	err = tx.Model(&models.JWTKey{}).Where("not_after IS NULL").Update("not_after", notAfter).Error
+	if err != nil {
+		tx.Rollback()
+		logger(ctx).Warnw("Retire jwt keys in Mysql failed", "error", err)
+		return false, ErrMySQLFailed
+	}
+	err = tx.Create(key).Error
+	if err != nil {
+		tx.Rollback()
+		logger(ctx).Warnw("Save jwt key to Mysql failed", "key", key.ID, "error", err)
+		return false, ErrMySQLFailed
+	}
+	err = tx.Commit().Error
+	if err != nil {
+		logger(ctx).Warnw("Rotate jwt key in Mysql failed", "error", err)
+		return false, ErrMySQLFailed
+	}
+	return true, nil
+}
+
+// DeleteRetiredJWTKeys - delete keys retired before now, return how many are deleted
+func DeleteRetiredJWTKeys(ctx context.Context, now time.Time) (n int64, err error) {
+	_, span := startMySQLSpan(ctx, "deleteRetiredJWTKeys")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	result := db.Where("not_after <= ?", now).Delete(&models.JWTKey{})
+	if err = result.Error; err != nil {
+		logger(ctx).Warnw("Delete retired jwt keys from Mysql failed", "error", err)
+		return 0, ErrMySQLFailed
+	}
+	return result.RowsAffected, nil
+}
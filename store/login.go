@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Login failure tracking, subject is "user:<id>" or "ip:<ip>".
+// Counters live for window and are reset on successful login,
+// locks are keys with ttl so they expire by themselves.
+
+// IncrLoginFailure - count a failed login of subject, return failures within window
+func IncrLoginFailure(ctx context.Context, subject string, window time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pipe := client.TxPipeline()
+	incr := pipe.Incr(ctx, wrapLoginFailKey(subject))
+	pipe.Expire(ctx, wrapLoginFailKey(subject), window)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		logger(ctx).Warn("IncrLoginFailure: ", err)
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// GetLoginFailures - get failed logins of subject within window
+func GetLoginFailures(ctx context.Context, subject string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	failures, err := client.Get(ctx, wrapLoginFailKey(subject)).Int64()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, nil
+		}
+		logger(ctx).Warn("GetLoginFailures: ", err)
+		return 0, err
+	}
+	return failures, nil
+}
+
+// LockLogin - refuse login of subject for d
+func LockLogin(ctx context.Context, subject string, d time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := client.Set(ctx, wrapLoginLockKey(subject), time.Now().Add(d).Unix(), d).Err()
+	if err != nil {
+		logger(ctx).Warn("LockLogin: ", err)
+	}
+	return err
+}
+
+// GetLoginLock - how long subject is still locked, 0 means not locked
+func GetLoginLock(ctx context.Context, subject string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ttl, err := client.PTTL(ctx, wrapLoginLockKey(subject)).Result()
+	if err != nil {
+		logger(ctx).Warn("GetLoginLock: ", err)
+		return 0, err
+	}
+	// -2 not exists, -1 no expire which we never set
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// ResetLoginFailures - clear failures and lock of subject
+func ResetLoginFailures(ctx context.Context, subject string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := client.Del(ctx, wrapLoginFailKey(subject), wrapLoginLockKey(subject)).Err()
+	if err != nil {
+		logger(ctx).Warn("ResetLoginFailures: ", err)
+	}
+	return err
+}
+
+// SaveLoginChallenge - save a one-time login challenge
+func SaveLoginChallenge(ctx context.Context, challenge string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return client.Set(ctx, wrapLoginChallengeKey(challenge), 1, ttl).Err()
+}
+
+// TakeLoginChallenge - consume a challenge, return false if not exists or used
+func TakeLoginChallenge(ctx context.Context, challenge string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	n, err := client.Del(ctx, wrapLoginChallengeKey(challenge)).Result()
+	if err != nil {
+		logger(ctx).Warn("TakeLoginChallenge: ", err)
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func wrapLoginFailKey(subject string) string {
+	return "login:fail:" + subject
+}
+
+func wrapLoginLockKey(subject string) string {
+	return "login:lock:" + subject
+}
+
+func wrapLoginChallengeKey(challenge string) string {
+	return "login:challenge:" + challenge
+}
@@ -2,14 +2,17 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"minitube/models"
 	"os"
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
-	_ "github.com/go-sql-driver/mysql" // mysql driver
+	"github.com/go-sql-driver/mysql"
 )
 
 var db *gorm.DB
@@ -44,6 +47,22 @@ func init() {
 
 	db.AutoMigrate(&models.User{})
 	db.AutoMigrate(&models.Room{})
+	db.AutoMigrate(&models.Role{})
+	db.AutoMigrate(&models.Permission{})
+	db.AutoMigrate(&models.Report{})
+	db.AutoMigrate(&models.AuditEvent{})
+	db.AutoMigrate(&models.UsernameReservation{})
+	db.AutoMigrate(&models.Identity{})
+	db.AutoMigrate(&models.APIToken{})
+	db.AutoMigrate(&models.JWTKey{})
+	db.AutoMigrate(&models.WatchSession{})
+	if err := migratePasswordColumn(); err != nil {
+		log.Fatal("Migrate password column failed: ", err)
+	}
+
+	if err := seedRoles(); err != nil {
+		log.Fatal("Seed roles failed: ", err)
+	}
 
 	if debug := os.Getenv("DEBUG"); debug == "true" {
 		db = db.Debug()
@@ -52,38 +71,82 @@ func init() {
 	log.Info("MySQL is OK.")
 }
 
+// migratePasswordColumn - password was char(64), too short for argon2id hashes,
+// AutoMigrate never alters existing columns so it's widened here.
+func migratePasswordColumn() error {
+	var size int
+	row := db.Raw("SELECT CHARACTER_MAXIMUM_LENGTH FROM information_schema.COLUMNS "+
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?", "user", "password").Row()
+	if err := row.Scan(&size); err != nil {
+		return err
+	}
+	if size >= 255 {
+		return nil
+	}
+	log.Infof("Widen password column from %v to 255.", size)
+	return db.Model(&models.User{}).ModifyColumn("password", "varchar(255) NOT NULL").Error
+}
+
 func pingMySQL() error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	return db.DB().PingContext(ctx)
 }
 
-func getUserByUsernameFromMysql(username string) (*models.User, error) {
-	return getUserFromMysqlBy(byUsername, username)
+// startMySQLSpan - gorm v1 has no context support, so spans are started by hand,
+// and callers check ctx.Err() before querying to respect request cancellation.
+func startMySQLSpan(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs,
+		attribute.String("db.system", "mysql"),
+		attribute.String("db.operation", operation),
+	)
+	return tracer.Start(ctx, "mysql."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// isDuplicateKey - whether err is mysql refusing a row that breaks a unique index
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
 }
 
-func getUserByEmailFromMysql(email string) (*models.User, error) {
-	return getUserFromMysqlBy(byEmail, email)
+func getUserByUsernameFromMysql(ctx context.Context, username string) (*models.User, error) {
+	return getUserFromMysqlBy(ctx, byUsername, username)
 }
 
-func getUserByPhoneFromMysql(phone string) (*models.User, error) {
-	return getUserFromMysqlBy(byPhone, phone)
+func getUserByEmailFromMysql(ctx context.Context, email string) (*models.User, error) {
+	return getUserFromMysqlBy(ctx, byEmail, email)
 }
 
-func getUserByIDFromMysql(id uint) (*models.User, error) {
-	return getUserFromMysqlBy(byID, id)
+func getUserByPhoneFromMysql(ctx context.Context, phone string) (*models.User, error) {
+	return getUserFromMysqlBy(ctx, byPhone, phone)
 }
 
-func getUserFromMysqlBy(by string, value interface{}) (*models.User, error) {
+func getUserByIDFromMysql(ctx context.Context, id uint) (*models.User, error) {
+	return getUserFromMysqlBy(ctx, byID, id)
+}
+
+func getUserFromMysqlBy(ctx context.Context, by string, value interface{}) (user *models.User, err error) {
+	_, span := startMySQLSpan(ctx, "getUserBy", attribute.String("by", by))
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	tx := db.Begin()
-	user := new(models.User)
-	err := tx.Where(by+" = ?", value).Take(user).Error
+	user = new(models.User)
+	err = tx.Where(by+" = ?", value).Take(user).Error
 	if err != nil {
 		tx.Rollback()
 		if gorm.IsRecordNotFoundError(err) {
 			return nil, ErrMySQLUserNotExists
 		}
-		log.Warnf("Get user %v from Mysql failed: %v", value, err)
+		logger(ctx).Warnw("Get user from Mysql failed", by, value, "error", err)
+		return nil, ErrMySQLFailed
+	}
+	user.Roles, err = getUserRolesFromMysql(user)
+	if err != nil {
+		tx.Rollback()
+		logger(ctx).Warnw("Get user's roles from Mysql failed", by, value, "error", err)
 		return nil, ErrMySQLFailed
 	}
 	room := new(models.Room)
@@ -93,28 +156,43 @@ func getUserFromMysqlBy(by string, value interface{}) (*models.User, error) {
 		if gorm.IsRecordNotFoundError(err) {
 			return user, nil
 		}
-		log.Warnf("Get user %v's room from Mysql failed: %v", value, err)
+		logger(ctx).Warnw("Get user's room from Mysql failed", by, value, "error", err)
 		return nil, ErrMySQLFailed
 	}
 	user.Room = *room
 	return user, tx.Commit().Error
 }
 
+func changePasswordToMysql(ctx context.Context, user *models.User, password string) (err error) {
+	_, span := startMySQLSpan(ctx, "changePassword")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 
-func changePasswordToMysql(user *models.User, password string) error {
-	err := db.Model(user).Update("password", password).Error
+	// a forced password reset is done once password changed
+	err = db.Model(user).Updates(map[string]interface{}{
+		"password":                password,
+		"password_reset_required": false,
+	}).Error
 	if err != nil {
-		log.Warnf("Change user %v password to %v failed.", user.Username, password)
+		logger(ctx).Warnw("Change user password to Mysql failed", "user", user, "error", err)
 	}
 	return err
 }
 
-func saveUserToMysql(user *models.User) error {
+func saveUserToMysql(ctx context.Context, user *models.User) (err error) {
+	_, span := startMySQLSpan(ctx, "saveUser")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
 	if db.NewRecord(user) {
 		// log.Debugf("%#v", user)
-		err := db.Create(user).Error
+		err = db.Create(user).Error
 		if err != nil {
-			log.Warnf("Save user %#v to Mysql failed: %v", user, err)
+			logger(ctx).Warnw("Save user to Mysql failed", "user", user, "error", err)
 			return err
 		}
 		return nil
@@ -122,21 +200,27 @@ func saveUserToMysql(user *models.User) error {
 	return nil
 }
 
-func updateUserProfileToMysql(user *models.User, profile *models.ChangeProfileModel) error {
+func updateUserProfileToMysql(ctx context.Context, user *models.User, profile *models.ChangeProfileModel) (err error) {
+	_, span := startMySQLSpan(ctx, "updateUserProfile")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
 	tx := db.Begin()
-	err := tx.Model(user).Updates(profile.MapUser()).Error
+	err = tx.Model(user).Updates(profile.MapUser()).Error
 	if err != nil {
 		tx.Rollback()
-		log.Warnf("Update user<%v> profile to %#v Mysql failed: %v", user.ID, profile, err)
+		logger(ctx).Warnw("Update user profile to Mysql failed", "user", user, "profile", profile, "error", err)
 		return err
 	}
 
 	user.Room.UserID = user.ID
 	if tx.NewRecord(&user.Room) {
-		err := tx.Create(&user.Room).Error
+		err = tx.Create(&user.Room).Error
 		if err != nil {
 			tx.Rollback()
-			log.Warnf("Create user %#v's room to Mysql failed: %v", user, err)
+			logger(ctx).Warnw("Create user's room to Mysql failed", "user", user, "error", err)
 			return err
 		}
 	}
@@ -144,7 +228,7 @@ func updateUserProfileToMysql(user *models.User, profile *models.ChangeProfileMo
 	err = tx.Model(&user.Room).Updates(profile.MapRoom()).Error
 	if err != nil {
 		tx.Rollback()
-		log.Warnf("Update user<%v> profile to %#v Mysql failed: %v", user.ID, profile, err)
+		logger(ctx).Warnw("Update user profile to Mysql failed", "user", user, "profile", profile, "error", err)
 		return err
 	}
 
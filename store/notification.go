@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"minitube/models"
+)
+
+// max notifications kept for each user
+const maxNotifications = 50
+
+// PushNotification - save a notification for user, only latest ones are kept
+func PushNotification(ctx context.Context, id uint, n *models.Notification) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	bytes, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	pipe := client.TxPipeline()
+	pipe.LPush(ctx, wrapNotificationKey(id), bytes)
+	pipe.LTrim(ctx, wrapNotificationKey(id), 0, maxNotifications-1)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		logger(ctx).Warn("PushNotification: ", err)
+	}
+	return err
+}
+
+// GetNotifications - get user's notifications, newest first
+func GetNotifications(ctx context.Context, id uint) ([]*models.Notification, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result, err := client.LRange(ctx, wrapNotificationKey(id), 0, -1).Result()
+	if err != nil {
+		logger(ctx).Warn("GetNotifications: ", err)
+		return []*models.Notification{}, err
+	}
+
+	list := make([]*models.Notification, 0, len(result))
+	for _, s := range result {
+		n := new(models.Notification)
+		if err := json.Unmarshal([]byte(s), n); err != nil {
+			logger(ctx).Warn("GetNotifications: ", err)
+			continue
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// gcraScript - generic cell rate algorithm, see https://brandur.org/rate-limiting
+// Only the theoretical arrival time (tat) is stored, so the check is one atomic
+// script call and works when several minitube instances share redis.
+//
+// KEYS[1] - limiter key
+// ARGV[1] - burst, ARGV[2] - rate, ARGV[3] - period in seconds
+// return {allowed, retry_after_seconds}
+var gcraScript = redis.NewScript(`
+redis.replicate_commands()
+
+local key = KEYS[1]
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local period = tonumber(ARGV[3])
+
+local emission_interval = period / rate
+local burst_offset = emission_interval * burst
+
+-- use redis time, so instances with clock skew agree
+local now = redis.call("TIME")
+now = (now[1] - 1600000000) + (now[2] / 1000000)
+
+local tat = redis.call("GET", key)
+if not tat then
+	tat = now
+else
+	tat = tonumber(tat)
+end
+tat = math.max(tat, now)
+
+local new_tat = tat + emission_interval
+local diff = now - (new_tat - burst_offset)
+if diff < 0 then
+	return {0, tostring(-diff)}
+end
+
+redis.call("SET", key, new_tat, "EX", math.ceil(new_tat - now))
+return {1, "0"}
+`)
+
+// AllowRate - whether one more request for key is allowed by limit of
+// rate requests per period with burst, when not allowed return how long to wait.
+func AllowRate(ctx context.Context, key string, rate, burst int, period time.Duration) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result, err := gcraScript.Run(ctx, client, []string{wrapRateLimitKey(key)}, burst, rate, period.Seconds()).Slice()
+	if err != nil {
+		logger(ctx).Warn("AllowRate: ", err)
+		return false, 0, err
+	}
+
+	allowed := result[0].(int64) == 1
+	seconds, err := strconv.ParseFloat(result[1].(string), 64)
+	if err != nil {
+		return false, 0, err
+	}
+	return allowed, time.Duration(seconds * float64(time.Second)), nil
+}
+
+func wrapRateLimitKey(key string) string {
+	return "ratelimit:" + key
+}
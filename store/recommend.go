@@ -0,0 +1,327 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"minitube/models"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Channels are recommended from the follow graph, watch history, categories of
+// channels the viewer likes and living channels. Anonymous viewers and viewers
+// without follows or history only get living channels, the popular ones first.
+const (
+	// recommendFollowingsLimit - viewer's latest followings whose followings are candidates
+	recommendFollowingsLimit = 50
+	// recommendFanoutLimit - latest followings of each following looked at
+	recommendFanoutLimit = 100
+	// recommendGraphLimit - candidates from the follow graph, the most followed ones
+	recommendGraphLimit = 200
+	// recommendLivingSample - living channels sampled as candidates
+	recommendLivingSample = 100
+)
+
+// GetRecommendations - the num best channels to recommend to viewer, nil for anonymous
+func GetRecommendations(ctx context.Context, viewer *models.User, num int) ([]*models.Recommendation, error) {
+	ctx, span := startSpan(ctx, "GetRecommendations")
+	defer span.End()
+
+	signals := make(map[string]*models.RecommendSignals)
+	signal := func(username string) *models.RecommendSignals {
+		s, ok := signals[username]
+		if !ok {
+			s = &models.RecommendSignals{Username: username}
+			signals[username] = s
+		}
+		return s
+	}
+	// channels already followed, blocked or the viewer's own aren't recommended
+	exclude := make(map[string]bool)
+	// categories of channels the viewer follows or watched
+	categories := make(map[string]int)
+	likedCategories := 0
+
+	viewerName := ""
+	if viewer != nil {
+		viewerName = viewer.Username
+		exclude[viewer.Username] = true
+		blocks, err := getBlockRelationsFromRedis(ctx, viewer.Username)
+		if err != nil {
+			return nil, err
+		}
+		for _, username := range blocks {
+			exclude[username] = true
+		}
+		followings, err := GetFollowingsFromRedis(ctx, viewer.Username)
+		if err != nil {
+			return nil, err
+		}
+		for _, username := range followings {
+			exclude[username] = true
+		}
+		if len(followings) > recommendFollowingsLimit {
+			followings = followings[:recommendFollowingsLimit]
+		}
+		history, err := GetWatchHistory(ctx, viewer.ID)
+		if err != nil {
+			return nil, err
+		}
+		liking := append([]string(nil), followings...)
+		for _, h := range history {
+			liking = append(liking, h.Username)
+			if !exclude[h.Username] {
+				signal(h.Username).LastWatched = time.Unix(h.TimeStamp, 0)
+			}
+		}
+		liked, err := getUsersByUsername(ctx, liking)
+		if err != nil {
+			return nil, err
+		}
+		for _, username := range liking {
+			if user, ok := liked[username]; ok && user.Room.Category != nil {
+				categories[*user.Room.Category]++
+				likedCategories++
+			}
+		}
+
+		// who a user follows is only seen if the user shows it
+		visible := make([]string, 0, len(followings))
+		for _, username := range followings {
+			if user, ok := liked[username]; ok && !user.HideFollowings {
+				visible = append(visible, username)
+			}
+		}
+		graph, err := getFollowingsOfFromRedis(ctx, visible, exclude)
+		if err != nil {
+			return nil, err
+		}
+		for username, n := range graph {
+			signal(username).FollowedBy = n
+		}
+	}
+
+	living, err := GetLivingUsernameList(ctx, recommendLivingSample)
+	if err != nil {
+		return nil, err
+	}
+	for _, username := range living {
+		if !exclude[username] {
+			signal(username)
+		}
+	}
+
+	candidates := make([]string, 0, len(signals))
+	for username := range signals {
+		candidates = append(candidates, username)
+	}
+	users, err := getUsersByUsername(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+	watching, err := getWatchingNumbersFromRedis(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*models.RecommendSignals, 0, len(signals))
+	now := time.Now()
+	for username, s := range signals {
+		user, ok := users[username]
+		if !ok {
+			continue
+		}
+		// suspended channels and accounts being deleted are hidden
+		if user.IsSuspended(now) || user.IsDeletionPending() {
+			continue
+		}
+		if likedCategories > 0 && user.Room.Category != nil {
+			s.CategoryAffinity = float64(categories[*user.Room.Category]) / float64(likedCategories)
+		}
+		if n, ok := watching[username]; ok {
+			s.Living, s.Watching = true, n
+		}
+		list = append(list, s)
+	}
+
+	recommendations := models.RankRecommendations(list, now, num)
+	for _, r := range recommendations {
+		r.User = NewPublicUserFromUser(ctx, viewerName, users[r.Username])
+	}
+	return recommendations, nil
+}
+
+// getFollowingsOfFromRedis - channels followed by usernames and not excluded, with how many of
+// usernames follow each, at most recommendGraphLimit of the most followed
+func getFollowingsOfFromRedis(ctx context.Context, usernames []string, exclude map[string]bool) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
+
+	pipe := client.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(usernames))
+	for i, username := range usernames {
+		cmds[i] = pipe.ZRevRange(ctx, wrapFollowingKey(username), 0, recommendFanoutLimit-1)
+	}
+	if len(usernames) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			logger(ctx).Warn("getFollowingsOfFromRedis: ", err)
+			return nil, err
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, cmd := range cmds {
+		followings, _ := cmd.Result()
+		for _, username := range followings {
+			if !exclude[username] {
+				counts[username]++
+			}
+		}
+	}
+	if len(counts) <= recommendGraphLimit {
+		return counts, nil
+	}
+	ranked := make([]string, 0, len(counts))
+	for username := range counts {
+		ranked = append(ranked, username)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if counts[ranked[i]] != counts[ranked[j]] {
+			return counts[ranked[i]] > counts[ranked[j]]
+		}
+		return ranked[i] < ranked[j]
+	})
+	for _, username := range ranked[recommendGraphLimit:] {
+		delete(counts, username)
+	}
+	return counts, nil
+}
+
+// getUsersByUsername - users of usernames by username, in two round trips to redis.
+// Users not cached in redis are loaded one by one, unknown ones are left out.
+func getUsersByUsername(ctx context.Context, usernames []string) (map[string]*models.User, error) {
+	users := make(map[string]*models.User, len(usernames))
+	if len(usernames) == 0 {
+		return users, nil
+	}
+	redisCtx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
+
+	pipe := client.Pipeline()
+	idCmds := make([]*redis.StringCmd, len(usernames))
+	for i, username := range usernames {
+		idCmds[i] = pipe.Get(redisCtx, wrapUsernameKey(username))
+	}
+	if _, err := pipe.Exec(redisCtx); err != nil && !errors.Is(err, redis.Nil) {
+		logger(ctx).Warn("getUsersByUsername: ", err)
+		return nil, err
+	}
+	userCmds := make([]*redis.StringCmd, len(usernames))
+	for i, cmd := range idCmds {
+		if id, err := cmd.Int(); err == nil {
+			userCmds[i] = pipe.Get(redisCtx, wrapIDKey(uint(id)))
+		}
+	}
+	if _, err := pipe.Exec(redisCtx); err != nil && !errors.Is(err, redis.Nil) {
+		logger(ctx).Warn("getUsersByUsername: ", err)
+		return nil, err
+	}
+
+	for i, username := range usernames {
+		if _, ok := users[username]; ok {
+			continue
+		}
+		if cmd := userCmds[i]; cmd != nil {
+			user := new(models.User)
+			if data, err := cmd.Bytes(); err == nil && json.Unmarshal(data, user) == nil {
+				users[username] = user
+				continue
+			}
+		}
+		if user, err := GetUserByUsername(ctx, username); err == nil {
+			users[username] = user
+		}
+	}
+	return users, nil
+}
+
+// getUsersByID - users by id from redis in one round trip, users not cached are loaded one by one,
+// users that don't exist are left out
+func getUsersByID(ctx context.Context, ids []uint) (map[uint]*models.User, error) {
+	users := make(map[uint]*models.User, len(ids))
+	if len(ids) == 0 {
+		return users, nil
+	}
+	redisCtx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
+
+	pipe := client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.Get(redisCtx, wrapIDKey(id))
+	}
+	if _, err := pipe.Exec(redisCtx); err != nil && !errors.Is(err, redis.Nil) {
+		logger(ctx).Warn("getUsersByID: ", err)
+		return nil, err
+	}
+
+	for i, id := range ids {
+		if _, ok := users[id]; ok || id == 0 {
+			continue
+		}
+		user := new(models.User)
+		if data, err := cmds[i].Bytes(); err == nil && json.Unmarshal(data, user) == nil {
+			users[id] = user
+			continue
+		}
+		if user, err := GetUserByID(ctx, id); err == nil {
+			users[id] = user
+		}
+	}
+	return users, nil
+}
+
+// getWatchingNumbersFromRedis - viewers of those of usernames living, in one round trip
+func getWatchingNumbersFromRedis(ctx context.Context, usernames []string) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
+
+	watching := make(map[string]int)
+	if len(usernames) == 0 {
+		return watching, nil
+	}
+	pipe := client.Pipeline()
+	livingCmds := make([]*redis.BoolCmd, len(usernames))
+	watchingCmds := make([]*redis.StringCmd, len(usernames))
+	for i, username := range usernames {
+		livingCmds[i] = pipe.SIsMember(ctx, "living", username)
+		watchingCmds[i] = pipe.Get(ctx, "watching:"+username)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		logger(ctx).Warn("getWatchingNumbersFromRedis: ", err)
+		return nil, err
+	}
+	for i, username := range usernames {
+		if livingCmds[i].Val() {
+			watching[username], _ = watchingCmds[i].Int()
+		}
+	}
+	return watching, nil
+}
+
+// getBlockRelationsFromRedis - users username blocks or is blocked by
+func getBlockRelationsFromRedis(ctx context.Context, username string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
+
+	pipe := client.Pipeline()
+	blocking := pipe.ZRange(ctx, wrapBlockingKey(username), 0, -1)
+	blockedBy := pipe.ZRange(ctx, wrapBlockedByKey(username), 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger(ctx).Warn("getBlockRelationsFromRedis: ", err)
+		return nil, err
+	}
+	return append(blocking.Val(), blockedBy.Val()...), nil
+}
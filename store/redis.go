@@ -4,19 +4,27 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"minitube/models"
 	"os"
 	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var client *redis.Client
 
+// watchHistoryLimit - channels kept in watch history, long-term history is kept as watch sessions
+const watchHistoryLimit = 32
+
 func init() {
 	log.Info("Initialize redis client...")
 	client = NewRedisClient()
+	client.AddHook(tracingHook{})
 
 	log.Info("Checking redis service...")
 	err := pingRedis()
@@ -42,8 +50,8 @@ func pingRedis() error {
 	return client.Ping(ctx).Err()
 }
 
-func getUserByIDFromRedis(id uint) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+func getUserByIDFromRedis(ctx context.Context, id uint) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	result := client.Get(ctx, wrapIDKey(id))
 	jsonStr, err := result.Result()
@@ -51,44 +59,44 @@ func getUserByIDFromRedis(id uint) (*models.User, error) {
 		if err == redis.Nil {
 			return nil, ErrRedisUserNotExists
 		}
-		log.Warnf("Get user from redis failed: %v", err)
+		logger(ctx).Warnf("Get user from redis failed: %v", err)
 		return nil, ErrRedisFailed
 	}
 	user := new(models.User)
 	err = json.Unmarshal([]byte(jsonStr), user)
 	if err != nil {
-		log.Warnf("Unmarshal <%v> to user err: %v", jsonStr, err)
+		logger(ctx).Warnw("Unmarshal user from redis failed", "id", id, "error", err)
 		return nil, err
 	}
 	return user, nil
 }
 
-func getUserByUsernameFromRedis(username string) (*models.User, error) {
-	return getUserFromRedisBy(byUsername, username)
+func getUserByUsernameFromRedis(ctx context.Context, username string) (*models.User, error) {
+	return getUserFromRedisBy(ctx, byUsername, username)
 }
 
-func getUserByEmailFromRedis(email string) (*models.User, error) {
-	return getUserFromRedisBy(byEmail, email)
+func getUserByEmailFromRedis(ctx context.Context, email string) (*models.User, error) {
+	return getUserFromRedisBy(ctx, byEmail, email)
 }
 
-func getUserByPhoneFromRedis(phone string) (*models.User, error) {
-	return getUserFromRedisBy(byPhone, phone)
+func getUserByPhoneFromRedis(ctx context.Context, phone string) (*models.User, error) {
+	return getUserFromRedisBy(ctx, byPhone, phone)
 }
 
-func getUserFromRedisBy(by string, value interface{}) (*models.User, error) {
-	id, err := getIDFromRedisBy(by, value)
+func getUserFromRedisBy(ctx context.Context, by string, value interface{}) (*models.User, error) {
+	id, err := getIDFromRedisBy(ctx, by, value)
 	if err != nil {
 		if err == redis.Nil {
 			return nil, ErrRedisUserNotExists
 		}
-		log.Warnf("Get user from redis failed: %v", err)
+		logger(ctx).Warnf("Get user from redis failed: %v", err)
 		return nil, ErrRedisFailed
 	}
-	return getUserByIDFromRedis(id)
+	return getUserByIDFromRedis(ctx, id)
 }
 
-func getIDFromRedisBy(by string, value interface{}) (uint, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+func getIDFromRedisBy(ctx context.Context, by string, value interface{}) (uint, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	var key string
 	switch by {
@@ -115,14 +123,14 @@ func getIDFromRedisBy(by string, value interface{}) (uint, error) {
 	return uint(id), nil
 }
 
-func saveUserToRedis(user *models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout*2)
+func saveUserToRedis(ctx context.Context, user *models.User) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
 	defer cancel()
 
 	userBytes, err := json.Marshal(user)
-	log.Debug("Save user redis ", string(userBytes))
+	logger(ctx).Debugw("Save user redis", "user", user)
 	if err != nil {
-		log.Warnf("Marshal user %#v error: %v", user, err)
+		logger(ctx).Warnw("Marshal user failed", "user", user, "error", err)
 		return err
 	}
 
@@ -131,14 +139,14 @@ func saveUserToRedis(user *models.User) error {
 	// map userID -> user
 	err = pipe.Set(ctx, wrapIDKey(user.ID), userBytes, 0).Err()
 	if err != nil {
-		log.Warnf("Save user %#v to redis failed: %v", user, err)
+		logger(ctx).Warnw("Save user to redis failed", "user", user, "error", err)
 		return err
 	}
 
 	// map username -> userID
 	err = pipe.Set(ctx, wrapUsernameKey(user.Username), user.ID, 0).Err()
 	if err != nil {
-		log.Warnf("Create index username for user %#v to redis failed: %v", user, err)
+		logger(ctx).Warnw("Create index username to redis failed", "user", user, "error", err)
 		return err
 	}
 
@@ -146,7 +154,7 @@ func saveUserToRedis(user *models.User) error {
 	if user.Email != nil {
 		err = pipe.Set(ctx, wrapEmailKey(*user.Email), user.ID, 0).Err()
 		if err != nil {
-			log.Warnf("Create index email for user %#v to redis failed: %v", user, err)
+			logger(ctx).Warnw("Create index email to redis failed", "user", user, "error", err)
 			return err
 		}
 	}
@@ -155,7 +163,7 @@ func saveUserToRedis(user *models.User) error {
 	if user.Phone != nil {
 		err = pipe.Set(ctx, wrapPhoneKey(*user.Phone), user.ID, 0).Err()
 		if err != nil {
-			log.Warnf("Create index phone for user %#v to redis failed: %v", user, err)
+			logger(ctx).Warnw("Create index phone to redis failed", "user", user, "error", err)
 			return err
 		}
 	}
@@ -164,18 +172,18 @@ func saveUserToRedis(user *models.User) error {
 	return err
 }
 
-func updateUserProfileToRedis(user *models.User, profile *models.ChangeProfileModel) error {
+func updateUserProfileToRedis(ctx context.Context, user *models.User, profile *models.ChangeProfileModel) error {
 	// log.Debug("updateUserProfileToRedis")
-	err := setProfileRedis(user, profile)
+	err := setProfileRedis(ctx, user, profile)
 	if err != nil {
 		return err
 	}
 
-	return saveUserToRedis(user)
+	return saveUserToRedis(ctx, user)
 }
 
-func setProfileRedis(user *models.User, profile *models.ChangeProfileModel) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout*2)
+func setProfileRedis(ctx context.Context, user *models.User, profile *models.ChangeProfileModel) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
 	defer cancel()
 
 	pipe := client.TxPipeline()
@@ -215,19 +223,115 @@ func setProfileRedis(user *models.User, profile *models.ChangeProfileModel) erro
 	} else {
 		user.Room.Intro = &profile.LiveIntro
 	}
+	if profile.LiveCategory == "" {
+		user.Room.Category = nil
+	} else {
+		user.Room.Category = &profile.LiveCategory
+	}
 
 	_, err := pipe.Exec(ctx)
 	return err
 }
 
-func changePasswordToRedis(user *models.User, password string) error {
+func changePasswordToRedis(ctx context.Context, user *models.User, password string) error {
 	user.Password = password
-	return saveUserToRedis(user)
+	user.PasswordResetRequired = false
+	return saveUserToRedis(ctx, user)
+}
+
+func deleteUserFromRedis(ctx context.Context, user *models.User) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout*4)
+	defer cancel()
+
+	// other users' follow lists still have user, remove the reverse entries too
+	followers, err := client.ZRange(ctx, wrapFollowerKey(user.Username), 0, -1).Result()
+	if err != nil {
+		logger(ctx).Warnw("Get followers of deleted user failed", "user", user, "error", err)
+		return err
+	}
+	followings, err := client.ZRange(ctx, wrapFollowingKey(user.Username), 0, -1).Result()
+	if err != nil {
+		logger(ctx).Warnw("Get followings of deleted user failed", "user", user, "error", err)
+		return err
+	}
+	blockings, err := client.ZRange(ctx, wrapBlockingKey(user.Username), 0, -1).Result()
+	if err != nil {
+		logger(ctx).Warnw("Get blockings of deleted user failed", "user", user, "error", err)
+		return err
+	}
+	blockers, err := client.ZRange(ctx, wrapBlockedByKey(user.Username), 0, -1).Result()
+	if err != nil {
+		logger(ctx).Warnw("Get blockers of deleted user failed", "user", user, "error", err)
+		return err
+	}
+
+	// remove every key saveUserToRedis writes, and everything else kept for user
+	keys := []string{
+		wrapIDKey(user.ID), wrapUsernameKey(user.Username),
+		wrapHistoryKey(user.ID), wrapNotificationKey(user.ID),
+		wrapFollowerKey(user.Username), wrapFollowingKey(user.Username),
+		wrapBlockingKey(user.Username), wrapBlockedByKey(user.Username),
+		"living:" + user.Username, "watching:" + user.Username,
+	}
+	if user.Email != nil {
+		keys = append(keys, wrapEmailKey(*user.Email))
+	}
+	if user.Phone != nil {
+		keys = append(keys, wrapPhoneKey(*user.Phone))
+	}
+	// personal data export
+	exportID, err := client.Get(ctx, wrapUserExportKey(user.ID)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		logger(ctx).Warnw("Get export of deleted user failed", "user", user, "error", err)
+		return err
+	}
+	keys = append(keys, wrapUserExportKey(user.ID))
+	if exportID != "" {
+		keys = append(keys, wrapExportKey(exportID), wrapExportFileKey(exportID))
+	}
+
+	pipe := client.TxPipeline()
+	for _, follower := range followers {
+		pipe.ZRem(ctx, wrapFollowingKey(follower), user.Username)
+	}
+	for _, following := range followings {
+		pipe.ZRem(ctx, wrapFollowerKey(following), user.Username)
+	}
+	for _, blocking := range blockings {
+		pipe.ZRem(ctx, wrapBlockedByKey(blocking), user.Username)
+	}
+	for _, blocker := range blockers {
+		pipe.ZRem(ctx, wrapBlockingKey(blocker), user.Username)
+	}
+	pipe.Del(ctx, keys...)
+	pipe.SRem(ctx, "living", user.Username)
+	pipe.ZRem(ctx, lastLiveKey, user.Username)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		logger(ctx).Warnw("Delete user from redis failed", "user", user, "error", err)
+	}
+	return err
+}
+
+// EndLiving - mark user as not living
+func EndLiving(ctx context.Context, username string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pipe := client.TxPipeline()
+	pipe.SRem(ctx, "living", username)
+	pipe.Del(ctx, "living:"+username)
+	pipe.ZAdd(ctx, lastLiveKey, &redis.Z{Score: float64(time.Now().Unix()), Member: username})
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		logger(ctx).Warn("EndLiving: ", err)
+	}
+	return err
 }
 
 // GetLivingUsernameList - get who is living
-func GetLivingUsernameList(num int64) ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout*2)
+func GetLivingUsernameList(ctx context.Context, num int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
 	defer cancel()
 
 	result, err := client.SRandMemberN(ctx, "living", num).Result()
@@ -235,7 +339,7 @@ func GetLivingUsernameList(num int64) ([]string, error) {
 		if errors.Is(err, redis.Nil) {
 			return []string{}, nil
 		}
-		log.Warn("GetLivingUsernameList: ", err)
+		logger(ctx).Warn("GetLivingUsernameList: ", err)
 		return []string{}, err
 	}
 
@@ -243,20 +347,20 @@ func GetLivingUsernameList(num int64) ([]string, error) {
 }
 
 // GetUserIsLiving - whether user is living
-func GetUserIsLiving(username string) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout*2)
+func GetUserIsLiving(ctx context.Context, username string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
 	defer cancel()
 
 	living, err := client.SIsMember(ctx, "living", username).Result()
 	if err != nil {
-		log.Warn("GetUserIsLiving: ", err)
+		logger(ctx).Warn("GetUserIsLiving: ", err)
 	}
 	return living, err
 }
 
 // GetLivingTime - get when user start living
-func GetLivingTime(username string) (*time.Time, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout*2)
+func GetLivingTime(ctx context.Context, username string) (*time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
 	defer cancel()
 
 	result, err := client.Get(ctx, "living:"+username).Result()
@@ -264,74 +368,98 @@ func GetLivingTime(username string) (*time.Time, error) {
 		if errors.Is(err, redis.Nil) {
 			return nil, nil
 		}
-		log.Warn("GetLivingTime: ", err)
+		logger(ctx).Warn("GetLivingTime: ", err)
 		return nil, err
 	}
 
 	t, err := time.Parse(time.RFC3339, result)
 	if err != nil {
-		log.Warn("GetLivingTime: ", err)
+		logger(ctx).Warn("GetLivingTime: ", err)
 		return nil, err
 	}
 	return &t, nil
 }
 
-// UpdateWatchHistory - update watch history
-func UpdateWatchHistory(id uint, username string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+// UpdateWatchHistory - update watch history, only the latest watchHistoryLimit channels are kept.
+// Channels are kept by id so renames don't touch anyone's history, names are resolved when read.
+func UpdateWatchHistory(ctx context.Context, id uint, channelID uint) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	err := client.ZAdd(ctx, wrapHistoryKey(id), &redis.Z{
+	key := wrapHistoryKey(id)
+	pipe := client.TxPipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{
 		Score:  float64(time.Now().Unix()),
-		Member: username,
-	}).Err()
+		Member: strconv.Itoa(int(channelID)),
+	})
+	pipe.ZRemRangeByRank(ctx, key, 0, -watchHistoryLimit-1)
+	_, err := pipe.Exec(ctx)
 
 	if err != nil {
-		log.Warn("UpdateWatchHistory: ", err)
+		logger(ctx).Warn("UpdateWatchHistory: ", err)
 		return err
 	}
 
 	return nil
 }
 
-// GetWatchHistory - get watch history
-func GetWatchHistory(id uint) ([]*models.History, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+// DeleteWatchHistory - delete channel from watch history
+func DeleteWatchHistory(ctx context.Context, id uint, channelID uint) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	key := wrapHistoryKey(id)
-	result, err := client.ZRevRangeWithScores(ctx, key, 0, 31).Result()
+	n, err := client.ZRem(ctx, wrapHistoryKey(id), strconv.Itoa(int(channelID))).Result()
 	if err != nil {
-		log.Warn(err)
+		logger(ctx).Warn("DeleteWatchHistory: ", err)
+		return false, err
 	}
+	return n > 0, nil
+}
 
-	s := make([]*models.History, len(result))
-	for i := range result {
-		s[i] = models.ZToHistory(&result[i])
+// ClearWatchHistory - delete all watch history
+func ClearWatchHistory(ctx context.Context, id uint) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := client.Del(ctx, wrapHistoryKey(id)).Err()
+	if err != nil {
+		logger(ctx).Warn("ClearWatchHistory: ", err)
 	}
+	return err
+}
 
-	go func(key string) {
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
-		num, err := client.ZCard(ctx, key).Result()
-		if err != nil {
-			log.Warn(err)
-			return
-		}
-		if num > 64 {
-			err = client.ZPopMin(ctx, key, 32).Err()
-			if err != nil {
-				log.Warn(err)
-			}
-		}
-	}(key)
+// GetWatchHistory - get watch history, channels that no longer exist are left out
+func GetWatchHistory(ctx context.Context, id uint) ([]*models.History, error) {
+	redisCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result, err := client.ZRevRangeWithScores(redisCtx, wrapHistoryKey(id), 0, watchHistoryLimit-1).Result()
+	if err != nil {
+		logger(ctx).Warn("GetWatchHistory: ", err)
+		return nil, err
+	}
 
-	return s, err
+	ids := make([]uint, len(result))
+	for i := range result {
+		channelID, _ := strconv.Atoi(result[i].Member.(string))
+		ids[i] = uint(channelID)
+	}
+	channels, err := getUsersByID(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	s := make([]*models.History, 0, len(result))
+	for i := range result {
+		if channel, ok := channels[ids[i]]; ok {
+			s = append(s, models.ZToHistory(&result[i], channel.Username))
+		}
+	}
+	return s, nil
 }
 
 // GetWatchingNumber - get how many user are watching live
-func GetWatchingNumber(username string) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+func GetWatchingNumber(ctx context.Context, username string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	numStr, err := client.Get(ctx, "watching:"+username).Result()
@@ -339,36 +467,66 @@ func GetWatchingNumber(username string) (int, error) {
 		if errors.Is(err, redis.Nil) {
 			return 0, nil
 		}
-		log.Warn("GetWatchingNumber: ", err)
+		logger(ctx).Warn("GetWatchingNumber: ", err)
 		return 0, err
 	}
 
 	return strconv.Atoi(numStr)
 }
 
-// FollowUserInRedis - follow user
-func FollowUserInRedis(followerUsername string, followingUsername string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout*2)
+// ErrFollowBlocked - follower or the followed user blocks the other
+var ErrFollowBlocked = fmt.Errorf("%w follow blocked", ErrRedisFailed)
+
+// followScript - follow unless either user blocks the other, checked in the same step
+// so a block can't land between the check and the follow.
+//
+// KEYS[1], KEYS[2] - blocking zsets of follower and followed user
+// KEYS[3] - following zset of follower, KEYS[4] - follower zset of followed user
+// ARGV[1] - follower, ARGV[2] - followed user, ARGV[3] - timestamp
+var followScript = redis.NewScript(`
+if redis.call("ZSCORE", KEYS[1], ARGV[2]) or redis.call("ZSCORE", KEYS[2], ARGV[1]) then
+	return 0
+end
+redis.call("ZADD", KEYS[3], ARGV[3], ARGV[2])
+redis.call("ZADD", KEYS[4], ARGV[3], ARGV[1])
+return 1
+`)
+
+// FollowUserInRedis - follow user, ErrFollowBlocked if either blocks the other
+func FollowUserInRedis(ctx context.Context, followerUsername string, followingUsername string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
 	defer cancel()
 
-	pipe := client.TxPipeline()
-	timestamp := float64(time.Now().Unix())
+	keys := []string{
+		wrapBlockingKey(followerUsername), wrapBlockingKey(followingUsername),
+		wrapFollowingKey(followerUsername), wrapFollowerKey(followingUsername),
+	}
+	followed, err := followScript.Run(ctx, client, keys, followerUsername, followingUsername, time.Now().Unix()).Int()
+	if err != nil {
+		logger(ctx).Warn("FollowUserInRedis: ", err)
+		return err
+	}
+	if followed == 0 {
+		return ErrFollowBlocked
+	}
+	return nil
+}
 
-	pipe.ZAdd(ctx, wrapFollowingKey(followerUsername), &redis.Z{
-		Member: followingUsername,
-		Score:  timestamp,
-	})
+// UnFollowUserInRedis - unFollow user
+func UnFollowUserInRedis(ctx context.Context, followerUsername string, followingUsername string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
 
-	pipe.ZAdd(ctx, wrapFollowerKey(followingUsername), &redis.Z{
-		Member: followerUsername,
-		Score:  timestamp,
-	})
+	pipe := client.TxPipeline()
+
+	pipe.ZRem(ctx, wrapFollowingKey(followerUsername), followingUsername)
+	pipe.ZRem(ctx, wrapFollowerKey(followingUsername), followerUsername)
 
 	cmds, err := pipe.Exec(ctx)
 	if err == nil {
 		for _, cmd := range cmds {
 			if cmd.Err() != nil {
-				log.Warn("FollowUserInRedis: ", cmd.Err())
+				logger(ctx).Warn("UnFollowUserInRedis: ", cmd.Err())
 				return err
 			}
 		}
@@ -377,37 +535,94 @@ func FollowUserInRedis(followerUsername string, followingUsername string) error
 	return err
 }
 
-// UnFollowUserInRedis - unFollow user
-func UnFollowUserInRedis(followerUsername string, followingUsername string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout*2)
+// BlockUserInRedis - username blocks dstUsername, follows between them are removed both ways
+func BlockUserInRedis(ctx context.Context, username string, dstUsername string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
 	defer cancel()
 
 	pipe := client.TxPipeline()
+	timestamp := float64(time.Now().Unix())
 
-	pipe.ZRem(ctx, wrapFollowingKey(followerUsername), followingUsername)
-	pipe.ZRem(ctx, wrapFollowerKey(followingUsername), followerUsername)
+	pipe.ZAdd(ctx, wrapBlockingKey(username), &redis.Z{
+		Member: dstUsername,
+		Score:  timestamp,
+	})
+	pipe.ZAdd(ctx, wrapBlockedByKey(dstUsername), &redis.Z{
+		Member: username,
+		Score:  timestamp,
+	})
+	pipe.ZRem(ctx, wrapFollowingKey(username), dstUsername)
+	pipe.ZRem(ctx, wrapFollowerKey(dstUsername), username)
+	pipe.ZRem(ctx, wrapFollowingKey(dstUsername), username)
+	pipe.ZRem(ctx, wrapFollowerKey(username), dstUsername)
 
-	cmds, err := pipe.Exec(ctx)
-	if err == nil {
-		for _, cmd := range cmds {
-			if cmd.Err() != nil {
-				log.Warn("UnFollowUserInRedis: ", cmd.Err())
-				return err
-			}
-		}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		logger(ctx).Warn("BlockUserInRedis: ", err)
 	}
+	return err
+}
+
+// UnblockUserInRedis - username unblocks dstUsername, follows removed by the block aren't restored
+func UnblockUserInRedis(ctx context.Context, username string, dstUsername string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
+
+	pipe := client.TxPipeline()
+	pipe.ZRem(ctx, wrapBlockingKey(username), dstUsername)
+	pipe.ZRem(ctx, wrapBlockedByKey(dstUsername), username)
 
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		logger(ctx).Warn("UnblockUserInRedis: ", err)
+	}
 	return err
 }
 
+// IsBlockedInRedis - whether username or dstUsername blocks the other
+func IsBlockedInRedis(ctx context.Context, username string, dstUsername string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
+
+	pipe := client.Pipeline()
+	blocking := pipe.ZScore(ctx, wrapBlockingKey(username), dstUsername)
+	blocked := pipe.ZScore(ctx, wrapBlockingKey(dstUsername), username)
+	pipe.Exec(ctx)
+	for _, cmd := range []*redis.FloatCmd{blocking, blocked} {
+		if err := cmd.Err(); err != nil && !errors.Is(err, redis.Nil) {
+			logger(ctx).Warn("IsBlockedInRedis: ", err)
+			return false, err
+		}
+	}
+	return blocking.Err() == nil || blocked.Err() == nil, nil
+}
+
+// GetBlockingsFromRedis - get users blocked by username with when they are blocked, newest first
+func GetBlockingsFromRedis(ctx context.Context, username string) ([]*models.Follow, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
+
+	result, err := client.ZRevRangeWithScores(ctx, wrapBlockingKey(username), 0, -1).Result()
+	if err != nil {
+		logger(ctx).Warn("GetBlockingsFromRedis: ", err)
+		return []*models.Follow{}, err
+	}
+
+	blockings := make([]*models.Follow, len(result))
+	for i := range result {
+		blockings[i] = models.ZToFollow(&result[i])
+	}
+	return blockings, nil
+}
+
 // GetFollowersFromRedis - get followers
-func GetFollowersFromRedis(username string) ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout*2)
+func GetFollowersFromRedis(ctx context.Context, username string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
 	defer cancel()
 
 	followers, err := client.ZRevRange(ctx, wrapFollowerKey(username), 0, -1).Result()
 	if err != nil {
-		log.Warn()
+		logger(ctx).Warn()
 		return []string{}, err
 	}
 
@@ -415,26 +630,61 @@ func GetFollowersFromRedis(username string) ([]string, error) {
 }
 
 // GetFollowingsFromRedis - get followers
-func GetFollowingsFromRedis(username string) ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout*2)
+func GetFollowingsFromRedis(ctx context.Context, username string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
 	defer cancel()
 
 	followers, err := client.ZRevRange(ctx, wrapFollowingKey(username), 0, -1).Result()
 	if err != nil {
-		log.Warn()
+		logger(ctx).Warn()
 		return []string{}, err
 	}
 
 	return followers, nil
 }
 
-// GetFollowStatusFromRedis - get user follow status
-func GetFollowStatusFromRedis(username string, dstUsername string) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout*2)
+// GetFollowsWithTimeFromRedis - get followers or followings with when they are followed, newest first
+func GetFollowsWithTimeFromRedis(ctx context.Context, username string, followers bool) ([]*models.Follow, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
+	defer cancel()
+
+	key := wrapFollowingKey(username)
+	if followers {
+		key = wrapFollowerKey(username)
+	}
+	result, err := client.ZRevRangeWithScores(ctx, key, 0, -1).Result()
+	if err != nil {
+		logger(ctx).Warn("GetFollowsWithTimeFromRedis: ", err)
+		return []*models.Follow{}, err
+	}
+
+	follows := make([]*models.Follow, len(result))
+	for i := range result {
+		follows[i] = models.ZToFollow(&result[i])
+	}
+	return follows, nil
+}
+
+// GetFollowStatusFromRedis - get user follow status, or block status if either blocks the other
+func GetFollowStatusFromRedis(ctx context.Context, username string, dstUsername string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout*2)
 	defer cancel()
 
+	err := client.ZScore(ctx, wrapBlockingKey(username), dstUsername).Err()
+	if err == nil {
+		return Blocking, nil
+	} else if !errors.Is(err, redis.Nil) {
+		return -1, err
+	}
+	err = client.ZScore(ctx, wrapBlockingKey(dstUsername), username).Err()
+	if err == nil {
+		return Blocked, nil
+	} else if !errors.Is(err, redis.Nil) {
+		return -1, err
+	}
+
 	status := FollowNo
-	err := client.ZScore(ctx, wrapFollowingKey(username), dstUsername).Err()
+	err = client.ZScore(ctx, wrapFollowingKey(username), dstUsername).Err()
 	if err != nil {
 		if !errors.Is(err, redis.Nil) {
 			return -1, err
@@ -459,6 +709,61 @@ func GetFollowStatusFromRedis(username string, dstUsername string) (int, error)
 	return status, nil
 }
 
+// tracingHook - start a client span for every redis command or pipeline
+type tracingHook struct{}
+
+func (tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, _ = tracer.Start(ctx, "redis."+cmd.FullName(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation", cmd.FullName()),
+		),
+	)
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endRedisSpan(trace.SpanFromContext(ctx), cmd.Err())
+	return nil
+}
+
+func (tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		names[i] = cmd.FullName()
+	}
+	ctx, _ = tracer.Start(ctx, "redis.pipeline",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.StringSlice("db.operations", names),
+		),
+	)
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmd.Err() != nil && cmd.Err() != redis.Nil {
+			err = cmd.Err()
+			break
+		}
+	}
+	endRedisSpan(trace.SpanFromContext(ctx), err)
+	return nil
+}
+
+func endRedisSpan(span trace.Span, err error) {
+	// redis.Nil only means key not exists
+	if err != nil && err != redis.Nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
 func wrapUserKey(key string) string {
 	return "user:" + key
 }
@@ -490,3 +795,15 @@ func wrapFollowerKey(username string) string {
 func wrapFollowingKey(username string) string {
 	return wrapUserKey("following:" + username)
 }
+
+func wrapBlockingKey(username string) string {
+	return wrapUserKey("blocking:" + username)
+}
+
+func wrapBlockedByKey(username string) string {
+	return wrapUserKey("blockedby:" + username)
+}
+
+func wrapNotificationKey(id uint) string {
+	return wrapUserKey("notification:" + strconv.Itoa(int(id)))
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"minitube/models"
+
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrReportNotExists - report id is unknown
+var ErrReportNotExists = fmt.Errorf("%w report not exists", ErrMySQLFailed)
+
+// ReportFilter - filter of ListReports, zero value fields match any
+type ReportFilter struct {
+	Status       string
+	AssigneeID   uint
+	TargetUserID uint
+}
+
+// CreateReport - save report unless reporter has an open report on the same target,
+// return the saved or existing report and whether it's created.
+func CreateReport(ctx context.Context, report *models.Report) (saved *models.Report, created bool, err error) {
+	_, span := startMySQLSpan(ctx, "createReport", attribute.String("target_type", report.TargetType))
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	// the unique index on open reports refuses a duplicate, even of a concurrent request
+	open := true
+	report.Status = models.ReportOpen
+	report.OpenKey = &open
+	err = db.Create(report).Error
+	if err == nil {
+		return report, true, nil
+	}
+	if !isDuplicateKey(err) {
+		logger(ctx).Warnw("Save report to Mysql failed", "reporter", report.ReporterID, "error", err)
+		return nil, false, ErrMySQLFailed
+	}
+
+	existing := new(models.Report)
+	err = db.Where("reporter_id = ? AND target_user_id = ? AND target_type = ? AND message_id = ? AND open_key = ?",
+		report.ReporterID, report.TargetUserID, report.TargetType, report.MessageID, true).
+		Take(existing).Error
+	if err != nil {
+		logger(ctx).Warnw("Get report from Mysql failed", "reporter", report.ReporterID, "error", err)
+		return nil, false, ErrMySQLFailed
+	}
+	return existing, false, nil
+}
+
+// GetReport - get report by id
+func GetReport(ctx context.Context, id uint) (report *models.Report, err error) {
+	_, span := startMySQLSpan(ctx, "getReport")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	report = new(models.Report)
+	err = db.Where("id = ?", id).Take(report).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, ErrReportNotExists
+		}
+		logger(ctx).Warnw("Get report from Mysql failed", "id", id, "error", err)
+		return nil, ErrMySQLFailed
+	}
+	return report, nil
+}
+
+// ListReports - one page of reports oldest first, and the total number of matches
+func ListReports(ctx context.Context, filter *ReportFilter, page, size int) (reports []*models.Report, total int, err error) {
+	_, span := startMySQLSpan(ctx, "listReports", attribute.String("status", filter.Status))
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	tx := db.Model(&models.Report{})
+	if filter.Status != "" {
+		tx = tx.Where("status = ?", filter.Status)
+	}
+	if filter.AssigneeID != 0 {
+		tx = tx.Where("assignee_id = ?", filter.AssigneeID)
+	}
+	if filter.TargetUserID != 0 {
+		tx = tx.Where("target_user_id = ?", filter.TargetUserID)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		logger(ctx).Warnw("Count reports from Mysql failed", "error", err)
+		return nil, 0, ErrMySQLFailed
+	}
+	reports = make([]*models.Report, 0, size)
+	err = tx.Order("id").Offset((page - 1) * size).Limit(size).Find(&reports).Error
+	if err != nil {
+		logger(ctx).Warnw("List reports from Mysql failed", "error", err)
+		return nil, 0, ErrMySQLFailed
+	}
+	return reports, total, nil
+}
+
+// UpdateReport - update columns of report, e.g. assignee or status, then reload it
+func UpdateReport(ctx context.Context, report *models.Report, fields map[string]interface{}) (err error) {
+	_, span := startMySQLSpan(ctx, "updateReport")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	// a closed report no longer counts in the unique index of open reports
+	if status, ok := fields["status"]; ok && status != models.ReportOpen {
+		fields["open_key"] = nil
+	}
+	err = db.Model(report).Updates(fields).Error
+	if err == nil {
+		err = db.Where("id = ?", report.ID).Take(report).Error
+	}
+	if err != nil {
+		logger(ctx).Warnw("Update report to Mysql failed", "id", report.ID, "error", err)
+		return ErrMySQLFailed
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"minitube/models"
+
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrRoleNotExists - role name is unknown
+var ErrRoleNotExists = fmt.Errorf("%w role not exists", ErrMySQLFailed)
+
+// seedRoles - make sure default roles and their permissions exist, called at startup
+func seedRoles() error {
+	for name, permissions := range models.RolePermissions {
+		role := new(models.Role)
+		if err := db.Where(models.Role{Name: name}).FirstOrCreate(role).Error; err != nil {
+			return err
+		}
+		perms := make([]models.Permission, len(permissions))
+		for i, permName := range permissions {
+			if err := db.Where(models.Permission{Name: permName}).FirstOrCreate(&perms[i]).Error; err != nil {
+				return err
+			}
+		}
+		if err := db.Model(role).Association("Permissions").Replace(perms).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AssignRole - grant role to user and revoke user's sessions, takes effect on user's next login.
+func AssignRole(ctx context.Context, user *models.User, roleName string) error {
+	return changeRole(ctx, user, roleName, true)
+}
+
+// RevokeRole - take role from user and revoke user's sessions, takes effect on user's next login.
+func RevokeRole(ctx context.Context, user *models.User, roleName string) error {
+	return changeRole(ctx, user, roleName, false)
+}
+
+func changeRole(ctx context.Context, user *models.User, roleName string, grant bool) (err error) {
+	ctx, span := startSpan(ctx, "ChangeRole", attribute.String("role", roleName), attribute.Bool("grant", grant))
+	defer func() { endSpan(span, err) }()
+
+	err = changeRoleToMysql(ctx, user, roleName, grant)
+	if err != nil {
+		return err
+	}
+	// tokens carry roles and permissions, so they must not outlive a role change,
+	// this also caches the reloaded roles with user in redis
+	return RevokeSessions(ctx, user)
+}
+
+func changeRoleToMysql(ctx context.Context, user *models.User, roleName string, grant bool) (err error) {
+	_, span := startMySQLSpan(ctx, "changeRole", attribute.String("role", roleName))
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	role := new(models.Role)
+	err = db.Where("name = ?", roleName).Take(role).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return ErrRoleNotExists
+		}
+		logger(ctx).Warnw("Get role from Mysql failed", "role", roleName, "error", err)
+		return ErrMySQLFailed
+	}
+
+	association := db.Model(user).Association("Roles")
+	if grant {
+		err = association.Append(role).Error
+	} else {
+		err = association.Delete(role).Error
+	}
+	if err != nil {
+		logger(ctx).Warnw("Change user role to Mysql failed", "user", user, "role", roleName, "error", err)
+		return ErrMySQLFailed
+	}
+
+	user.Roles, err = getUserRolesFromMysql(user)
+	return err
+}
+
+// getUserRolesFromMysql - user's roles with permissions loaded, nil if no role
+func getUserRolesFromMysql(user *models.User) ([]models.Role, error) {
+	var roles []models.Role
+	err := db.Preload("Permissions").
+		Select("role.*").
+		Joins("JOIN user_role ON user_role.role_id = role.id").
+		Where("user_role.user_id = ?", user.ID).
+		Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return nil, nil
+	}
+	return roles, nil
+}
@@ -1,15 +1,23 @@
 package store
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"minitube/models"
 	"minitube/utils"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
 var log = utils.Sugar
 
+var tracer = utils.Tracer
+
 var timeout = 600 * time.Millisecond
 
 // store's error
@@ -27,6 +35,8 @@ const (
 	Following        // you follow he/she, but he/she not follow you
 	Followed         // he/she follow you, but you not follow he/she
 	FollowAll        // you follow each other
+	Blocking         // you block he/she, there's no follow between you
+	Blocked          // he/she blocks you, there's no follow between you
 )
 
 var (
@@ -37,37 +47,40 @@ var (
 )
 
 // GetUserByID - get user from store by id.
-func GetUserByID(id uint) (*models.User, error) {
-	return getUserBy(byID, id)
+func GetUserByID(ctx context.Context, id uint) (*models.User, error) {
+	return getUserBy(ctx, byID, id)
 }
 
 // GetUserByUsername - get user from store by username.
-func GetUserByUsername(username string) (*models.User, error) {
-	return getUserBy(byUsername, username)
+func GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	return getUserBy(ctx, byUsername, username)
 }
 
 // GetUserByEmail - get user from store by email.
-func GetUserByEmail(email string) (*models.User, error) {
-	return getUserBy(byEmail, email)
+func GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return getUserBy(ctx, byEmail, email)
 }
 
 // GetUserByPhone - get user from store by phone number.
-func GetUserByPhone(phone string) (*models.User, error) {
-	return getUserBy(byPhone, phone)
+func GetUserByPhone(ctx context.Context, phone string) (*models.User, error) {
+	return getUserBy(ctx, byPhone, phone)
 }
 
-func getUserBy(by string, value interface{}) (*models.User, error) {
+func getUserBy(ctx context.Context, by string, value interface{}) (*models.User, error) {
+	ctx, span := startSpan(ctx, "GetUserBy", attribute.String("by", by))
+	defer span.End()
+
 	var user *models.User
 	var errRedis, errMysql error
 	switch by {
 	case byID:
-		user, errRedis = getUserByIDFromRedis(value.(uint))
+		user, errRedis = getUserByIDFromRedis(ctx, value.(uint))
 	case byUsername:
-		user, errRedis = getUserByUsernameFromRedis(value.(string))
+		user, errRedis = getUserByUsernameFromRedis(ctx, value.(string))
 	case byEmail:
-		user, errRedis = getUserByEmailFromRedis(value.(string))
+		user, errRedis = getUserByEmailFromRedis(ctx, value.(string))
 	case byPhone:
-		user, errRedis = getUserByPhoneFromRedis(value.(string))
+		user, errRedis = getUserByPhoneFromRedis(ctx, value.(string))
 	default:
 		return nil, errors.New("Get user by " + by + " not support")
 	}
@@ -76,21 +89,21 @@ func getUserBy(by string, value interface{}) (*models.User, error) {
 	}
 	switch by {
 	case byID:
-		user, errMysql = getUserByIDFromMysql(value.(uint))
+		user, errMysql = getUserByIDFromMysql(ctx, value.(uint))
 	case byUsername:
-		user, errMysql = getUserByUsernameFromMysql(value.(string))
+		user, errMysql = getUserByUsernameFromMysql(ctx, value.(string))
 	case byEmail:
-		user, errMysql = getUserByEmailFromMysql(value.(string))
+		user, errMysql = getUserByEmailFromMysql(ctx, value.(string))
 	case byPhone:
-		user, errMysql = getUserByPhoneFromMysql(value.(string))
+		user, errMysql = getUserByPhoneFromMysql(ctx, value.(string))
 	default:
 		return nil, errors.New("Get user by " + by + " not support")
 	}
 	if errMysql == nil {
 		if errors.Is(errRedis, ErrRedisUserNotExists) {
-			err := saveUserToRedis(user)
+			err := saveUserToRedis(ctx, user)
 			if err != nil {
-				log.Warnf("User %#v found in mysql, but store to redis failed: ", err)
+				logger(ctx).Warnw("User found in mysql, but store to redis failed", "user", user, "error", err)
 			}
 		}
 		return user, nil
@@ -99,12 +112,15 @@ func getUserBy(by string, value interface{}) (*models.User, error) {
 }
 
 // SaveUser - store user to mysql and redis
-func SaveUser(user *models.User) error {
-	err := saveUserToMysql(user)
+func SaveUser(ctx context.Context, user *models.User) error {
+	ctx, span := startSpan(ctx, "SaveUser")
+	defer span.End()
+
+	err := saveUserToMysql(ctx, user)
 	if err != nil {
 		return err
 	}
-	err = saveUserToRedis(user)
+	err = saveUserToRedis(ctx, user)
 	if err != nil {
 		return err
 	}
@@ -112,69 +128,101 @@ func SaveUser(user *models.User) error {
 }
 
 // UpdateUserProfile - update user profile
-func UpdateUserProfile(id uint, profile *models.ChangeProfileModel) error {
-	user, err := GetUserByID(id)
+func UpdateUserProfile(ctx context.Context, id uint, profile *models.ChangeProfileModel) error {
+	ctx, span := startSpan(ctx, "UpdateUserProfile")
+	defer span.End()
+
+	user, err := GetUserByID(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	err = updateUserProfileToMysql(user, profile)
+	err = updateUserProfileToMysql(ctx, user, profile)
 	if err != nil {
 		return err
 	}
 
-	return updateUserProfileToRedis(user, profile)
+	return updateUserProfileToRedis(ctx, user, profile)
 }
 
 // ChangePassword - user change password to store
-func ChangePassword(user *models.User, password string) error {
-	err := changePasswordToMysql(user, password)
+func ChangePassword(ctx context.Context, user *models.User, password string) error {
+	ctx, span := startSpan(ctx, "ChangePassword")
+	defer span.End()
+
+	err := changePasswordToMysql(ctx, user, password)
 	if err != nil {
 		return err
 	}
-	return changePasswordToRedis(user, password)
+	return changePasswordToRedis(ctx, user, password)
+}
+
+// UpdatePasswordHash - replace user's password hash with a rehash of the same password,
+// unlike ChangePassword it keeps a forced password reset.
+func UpdatePasswordHash(ctx context.Context, user *models.User, hash string) error {
+	return updateUser(ctx, "UpdatePasswordHash", user, map[string]interface{}{"password": hash})
+}
+
+// UpdatePrivacy - save user's privacy settings
+func UpdatePrivacy(ctx context.Context, user *models.User, privacy *models.Privacy) error {
+	return updateUser(ctx, "UpdatePrivacy", user, map[string]interface{}{
+		"hide_followers":  privacy.HideFollowers,
+		"hide_followings": privacy.HideFollowings,
+		"history_paused":  privacy.HistoryPaused,
+	})
 }
 
 // NewPublicUserFromUser - new public user from user
-func NewPublicUserFromUser(username string, user *models.User) *models.PublicUser {
+func NewPublicUserFromUser(ctx context.Context, username string, user *models.User) *models.PublicUser {
+	ctx, span := startSpan(ctx, "NewPublicUserFromUser")
+	defer span.End()
+
 	public := &models.PublicUser{
 		Username:  user.Username,
 		RoomName:  user.Room.Name,
 		RoomIntro: user.Room.Intro,
+		Category:  user.Room.Category,
 	}
-	public.Living, _ = GetUserIsLiving(user.Username)
-	public.StartTime, _ = GetLivingTime(user.Username)
-	public.Watching, _ = GetWatchingNumber(user.Username)
+	public.Living, _ = GetUserIsLiving(ctx, user.Username)
+	public.StartTime, _ = GetLivingTime(ctx, user.Username)
+	public.Watching, _ = GetWatchingNumber(ctx, user.Username)
 	if username != "" {
-		public.Follow, _ = GetFollowStatusFromRedis(username, user.Username)
+		public.Follow, _ = GetFollowStatusFromRedis(ctx, username, user.Username)
 	}
 	return public
 }
 
 // NewLivingListModelFromUserList - new living list model from user list
-func NewLivingListModelFromUserList(username string, users []*models.User) *models.LivingListModel {
+func NewLivingListModelFromUserList(ctx context.Context, username string, users []*models.User) *models.LivingListModel {
 	list := new(models.LivingListModel)
 	list.Total = len(users)
 
 	for _, user := range users {
-		list.Users = append(list.Users, NewPublicUserFromUser(username, user))
+		list.Users = append(list.Users, NewPublicUserFromUser(ctx, username, user))
 	}
 
 	return list
 }
 
 // GetLivingUserList - get living user info list
-func GetLivingUserList(num int64) ([]*models.User, error) {
-	usernameList, err := GetLivingUsernameList(num)
+func GetLivingUserList(ctx context.Context, num int64) ([]*models.User, error) {
+	ctx, span := startSpan(ctx, "GetLivingUserList")
+	defer span.End()
+
+	usernameList, err := GetLivingUsernameList(ctx, num)
 	if err != nil {
 		return []*models.User{}, err
 	}
 
 	userList := make([]*models.User, 0, 16)
 	for _, username := range usernameList {
-		user, err := GetUserByUsername(username)
+		user, err := GetUserByUsername(ctx, username)
 		if err != nil {
-			log.Warnf("GetLivingUserList: username<%v> error : %v", username, err)
+			logger(ctx).Warnf("GetLivingUserList: username<%v> error : %v", username, err)
+			continue
+		}
+		// suspended channels and accounts being deleted are hidden
+		if user.IsSuspended(time.Now()) || user.IsDeletionPending() {
 			continue
 		}
 		userList = append(userList, user)
@@ -183,6 +231,25 @@ func GetLivingUserList(num int64) ([]*models.User, error) {
 	return userList, nil
 }
 
+// logger - get request-scoped logger from ctx, so store logs carry request id
+func logger(ctx context.Context) *zap.SugaredLogger {
+	return utils.SugarFrom(ctx)
+}
+
+// startSpan - start a span for store operation, it's a child of span in ctx.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "store."+name, trace.WithAttributes(attrs...))
+}
+
+// endSpan - record err to span then end it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
 // CloseAll - close redis client and mysql connection.
 func CloseAll() {
 	client.Close()
@@ -29,7 +29,7 @@ func TestMySQLConnection(t *testing.T) {
 func TestMySQLInsertUser(t *testing.T) {
 	// Add user 0-9 to mysql.
 	for i := 0; i < 10; i++ {
-		err := saveUserToMysql(users[i])
+		err := saveUserToMysql(context.Background(), users[i])
 		require.NoErrorf(t, err, "User[%v] should be inserted to mysql.", i)
 	}
 
@@ -49,7 +49,7 @@ func TestRedisSaveUser(t *testing.T) {
 	// Save user 10-19 to redis.
 	for i := 10; i < 20; i++ {
 		users[i].ID = uint(i + 1)
-		err := saveUserToRedis(users[i])
+		err := saveUserToRedis(context.Background(), users[i])
 		require.NoErrorf(t, err, "Save user[%v] to redis should success.", i)
 	}
 
@@ -82,7 +82,7 @@ func TestGetUserOnlyInMysql(t *testing.T) {
 
 	// User 0-9 in mysql, so get should success.
 	for i := 0; i < 10; i++ {
-		user, err := GetUserByUsername(strconv.Itoa(i))
+		user, err := GetUserByUsername(context.Background(), strconv.Itoa(i))
 		require.NoErrorf(err, "Get user[%v] from mysql should success.", i)
 		user.CreatedAt = users[i].CreatedAt
 		user.UpdatedAt = users[i].UpdatedAt
@@ -97,14 +97,14 @@ func TestStoreUser(t *testing.T) {
 	// Add user 10-19 to mysql.
 	for i := 10; i < 20; i++ {
 		users[i].ID = 0
-		err := saveUserToMysql(users[i])
+		err := saveUserToMysql(context.Background(), users[i])
 		require.NoErrorf(t, err, "User[%v] should be inserted to mysql.", i)
 	}
 	// User 10-19 has inserted.
 	checkUserInMySQL(t, 10, 20)
 
 	for i := 20; i < 30; i++ {
-		err := SaveUser(users[i])
+		err := SaveUser(context.Background(), users[i])
 		require.NoError(t, err, "Save user[%v] should success.", i)
 	}
 	checkUserInRedis(t, 20, 30)
@@ -119,7 +119,7 @@ func TestUpdateUserProfileToMysql(t *testing.T) {
 		profile.Phone = "+11370000000" + strconv.Itoa(i)
 		profile.LiveName = strconv.Itoa(i) + "'s live room"
 		profile.LiveIntro = strconv.Itoa(i) + " welcome to my live room"
-		err := updateUserProfileToMysql(users[i], profile)
+		err := updateUserProfileToMysql(context.Background(), users[i], profile)
 		require.NoError(err, "update shouldn't error")
 		users[i].Email = &profile.Email
 		users[i].Phone = &profile.Phone
@@ -129,7 +129,7 @@ func TestUpdateUserProfileToMysql(t *testing.T) {
 	t.Log("Test clear profile")
 	for i := 0; i < 10; i++ {
 		profile := new(models.ChangeProfileModel)
-		err := updateUserProfileToMysql(users[i], profile)
+		err := updateUserProfileToMysql(context.Background(), users[i], profile)
 		require.NoError(err, "update shouldn't error")
 		users[i].Email = nil
 		users[i].Phone = nil
@@ -147,7 +147,7 @@ func TestUpdateUserProfileToRedis(t *testing.T) {
 		profile.Phone = "+11370000000" + strconv.Itoa(i)
 		profile.LiveName = strconv.Itoa(i) + "'s live room"
 		profile.LiveIntro = strconv.Itoa(i) + " welcome to my room"
-		err := updateUserProfileToRedis(users[i], profile)
+		err := updateUserProfileToRedis(context.Background(), users[i], profile)
 		require.NoError(err, "update shouldn't error")
 		users[i].Email = &profile.Email
 		users[i].Phone = &profile.Phone
@@ -158,7 +158,7 @@ func TestUpdateUserProfileToRedis(t *testing.T) {
 	t.Log("Test clear profile")
 	for i := 0; i < 10; i++ {
 		profile := new(models.ChangeProfileModel)
-		err := updateUserProfileToRedis(users[i], profile)
+		err := updateUserProfileToRedis(context.Background(), users[i], profile)
 		require.NoError(err, "update shouldn't error")
 		users[i].Email = nil
 		users[i].Phone = nil
@@ -175,7 +175,7 @@ func TestUpdateUserProfile(t *testing.T) {
 		profile.Phone = "+11370000000" + strconv.Itoa(i)
 		profile.LiveName = strconv.Itoa(i) + "'s living room"
 		profile.LiveIntro = strconv.Itoa(i) + " welcome to my live room"
-		err := UpdateUserProfile(users[i].ID, profile)
+		err := UpdateUserProfile(context.Background(), users[i].ID, profile)
 		require.NoError(err, "update shouldn't error")
 		users[i].Email = &profile.Email
 		users[i].Phone = &profile.Phone
@@ -187,7 +187,7 @@ func TestUpdateUserProfile(t *testing.T) {
 	t.Log("Test clear profile")
 	for i := 0; i < 10; i++ {
 		profile := new(models.ChangeProfileModel)
-		err := UpdateUserProfile(users[i].ID, profile)
+		err := UpdateUserProfile(context.Background(), users[i].ID, profile)
 		require.NoError(err, "update shouldn't error")
 		users[i].Email = nil
 		users[i].Phone = nil
@@ -201,19 +201,19 @@ func TestUpdateUserProfile(t *testing.T) {
 func TestChangePassword(t *testing.T) {
 	require := require.New(t)
 	for i := 0; i < 10; i++ {
-		err := changePasswordToMysql(users[i], "mysql")
+		err := changePasswordToMysql(context.Background(), users[i], "mysql")
 		require.NoError(err, "update shouldn't error")
 		users[i].Password = "mysql"
 	}
 	checkUserInMySQL(t, 0, 10)
 	for i := 0; i < 10; i++ {
-		err := changePasswordToRedis(users[i], "redis")
+		err := changePasswordToRedis(context.Background(), users[i], "redis")
 		require.NoError(err, "update shouldn't error")
 		users[i].Password = "redis"
 	}
 	checkUserInRedis(t, 0, 10)
 	for i := 0; i < 10; i++ {
-		err := ChangePassword(users[i], "store")
+		err := ChangePassword(context.Background(), users[i], "store")
 		require.NoError(err, "update shouldn't error")
 		users[i].Password = "store"
 	}
@@ -224,23 +224,23 @@ func TestChangePassword(t *testing.T) {
 func TestGetLivingList(t *testing.T) {
 	require := require.New(t)
 
-	userList, err := GetLivingUserList(16)
+	userList, err := GetLivingUserList(context.Background(), 16)
 	require.NoError(err, "Get living list shouldn't error")
 	require.Empty(userList, "User list should empty")
 
 	streamForTest(0, 5, true)
 
-	userList, err = GetLivingUserList(6)
+	userList, err = GetLivingUserList(context.Background(), 6)
 	require.NoError(err, "Get living list shouldn't error")
 	require.Len(userList, 5, "5 users are living ! [0-4]")
 
-	userList, err = GetLivingUserList(3)
+	userList, err = GetLivingUserList(context.Background(), 3)
 	require.NoError(err, "Get living list shouldn't error")
 	require.Len(userList, 3, "Only return 3 users")
 
 	streamForTest(0, 2, false)
 
-	userList, err = GetLivingUserList(5)
+	userList, err = GetLivingUserList(context.Background(), 5)
 	require.NoError(err, "Get living list shouldn't error")
 	require.Len(userList, 3, "Only return 3 users [2-4]")
 
@@ -266,19 +266,211 @@ func TestWatch(t *testing.T) {
 	require := require.New(t)
 
 	for i := 0; i < 10; i++ {
-		err := UpdateWatchHistory(1, strconv.Itoa(i))
+		err := UpdateWatchHistory(context.Background(), 1, users[i].ID)
 		require.NoError(err, "Update watch shouldn't error")
 	}
+	// channels that don't exist are left out
+	require.NoError(UpdateWatchHistory(context.Background(), 1, 1<<30))
 
-	result, err := GetWatchHistory(1)
+	result, err := GetWatchHistory(context.Background(), 1)
 	require.NoError(err, "Get watch history shouldn't error")
 	require.Len(result, 10, "watch history record len 10")
+	watched := make([]string, 0, len(result))
+	for _, h := range result {
+		watched = append(watched, h.Username)
+	}
+	require.ElementsMatch(usernamesOf(users[:10]), watched, "history should show channels' usernames")
 
-	result, err = GetWatchHistory(2)
+	result, err = GetWatchHistory(context.Background(), 2)
 	require.NoError(err, "Get watch history shouldn't error")
 	require.Empty(result, "watch history record empty")
 }
 
+func TestAllowRate(t *testing.T) {
+	require := require.New(t)
+
+	key := "test:" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	for i := 0; i < 3; i++ {
+		allowed, _, err := AllowRate(context.Background(), key, 3, 3, time.Minute)
+		require.NoError(err)
+		require.Truef(allowed, "Request %v should be allowed within burst.", i)
+	}
+
+	allowed, retryAfter, err := AllowRate(context.Background(), key, 3, 3, time.Minute)
+	require.NoError(err)
+	require.False(allowed, "Request over burst should be limited.")
+	require.InDelta(20*time.Second, retryAfter, float64(time.Second), "Should retry after one emission interval.")
+
+	// another instance shares the same budget through redis
+	other := NewRedisClient()
+	defer other.Close()
+	ttl, err := other.TTL(context.Background(), wrapRateLimitKey(key)).Result()
+	require.NoError(err)
+	require.Greater(ttl, time.Duration(0), "Limiter key should expire.")
+}
+
+func TestAdminUpdateAndDeleteUser(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	name := "d" + strconv.FormatInt(time.Now().UnixNano()%1e15, 36)
+	email, phone := name+"@minitube.com", "+86"+strconv.FormatInt(time.Now().UnixNano()%1e11, 10)
+	user := models.NewUserFromMap(map[string]string{"username": name, "password": name, "email": email, "phone": phone})
+	require.NoError(SaveUser(ctx, user))
+
+	until := time.Now().Add(time.Hour)
+	require.NoError(SuspendUser(ctx, user, "spam", &until, 1))
+	cached, err := getUserByIDFromRedis(ctx, user.ID)
+	require.NoError(err)
+	require.True(cached.IsSuspended(time.Now()), "Suspension should be cached in redis.")
+	require.Equal(uint(1), cached.TokenVersion, "Suspend should revoke sessions.")
+
+	require.NoError(UnsuspendUser(ctx, user))
+	require.NoError(RequirePasswordReset(ctx, user))
+	cached, err = getUserByIDFromRedis(ctx, user.ID)
+	require.NoError(err)
+	require.False(cached.IsSuspended(time.Now()))
+	require.True(cached.PasswordResetRequired)
+	require.Equal(uint(2), cached.TokenVersion)
+
+	users, total, err := SearchUsers(ctx, name, 1, 10)
+	require.NoError(err)
+	require.Equal(1, total)
+	require.Equal(name, users[0].Username)
+
+	require.NoError(DeleteUser(ctx, user))
+	n, err := client.Exists(ctx, wrapIDKey(user.ID), wrapUsernameKey(name), wrapEmailKey(email), wrapPhoneKey(phone)).Result()
+	require.NoError(err)
+	require.Zero(n, "No redis index of deleted user should remain.")
+	_, err = getUserByIDFromMysql(ctx, user.ID)
+	require.ErrorIs(err, ErrMySQLUserNotExists)
+}
+
+func TestLivingListHidesSuspended(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	name := "s" + strconv.FormatInt(time.Now().UnixNano()%1e15, 36)
+	user := models.NewUserFromMap(map[string]string{"username": name, "password": name})
+	require.NoError(SaveUser(ctx, user))
+	defer DeleteUser(ctx, user)
+	require.NoError(client.SAdd(ctx, "living", name).Err())
+
+	living := func() bool {
+		list, err := GetLivingUserList(ctx, 100)
+		require.NoError(err)
+		for _, u := range list {
+			if u.Username == name {
+				return true
+			}
+		}
+		return false
+	}
+	require.True(living())
+
+	require.NoError(SuspendUser(ctx, user, "spam", nil, 1))
+	require.False(living(), "Suspended channel should be hidden.")
+
+	until := time.Now().Add(-time.Second)
+	user.SuspendedUntil = &until
+	require.False(user.IsSuspended(time.Now()), "Suspension should end after until.")
+}
+
+func TestFollowingLiveShowsAllLiving(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	suffix := strconv.FormatInt(time.Now().UnixNano()%1e15, 36)
+	fan, old, recent := "f"+suffix, "l"+suffix, "o"+suffix
+	defer client.Del(ctx, wrapFollowingKey(fan), wrapFollowerKey(old), wrapFollowerKey(recent))
+	require.NoError(FollowUserInRedis(ctx, fan, old))
+	time.Sleep(time.Second)
+	require.NoError(FollowUserInRedis(ctx, fan, recent))
+	require.NoError(client.SAdd(ctx, "living", old).Err())
+	defer client.SRem(ctx, "living", old)
+
+	// Only the latest following is in the limit, but living ones are never cut.
+	channels, err := GetFollowingLiveFromRedis(ctx, fan, 1)
+	require.NoError(err)
+	require.Len(channels, 2)
+	require.Equal(old, channels[0].Username)
+	require.True(channels[0].Living)
+	require.Equal(recent, channels[1].Username)
+	require.False(channels[1].Living)
+}
+
+func TestDeleteUserLeavesNoKeys(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	newUser := func(prefix string) *models.User {
+		name := prefix + strconv.FormatInt(time.Now().UnixNano()%1e15, 36)
+		user := models.NewUserFromMap(map[string]string{"username": name, "password": name, "email": name + "@minitube.com"})
+		require.NoError(SaveUser(ctx, user))
+		return user
+	}
+	user, fan, idol, other := newUser("x"), newUser("f"), newUser("i"), newUser("o")
+	defer DeleteUser(ctx, fan)
+	defer DeleteUser(ctx, idol)
+	defer DeleteUser(ctx, other)
+
+	require.NoError(FollowUserInRedis(ctx, fan.Username, user.Username))
+	require.NoError(FollowUserInRedis(ctx, user.Username, idol.Username))
+	require.NoError(UpdateWatchHistory(ctx, user.ID, idol.ID))
+	require.NoError(BlockUserInRedis(ctx, user.Username, other.Username))
+	require.NoError(BlockUserInRedis(ctx, other.Username, user.Username))
+	require.ErrorIs(FollowUserInRedis(ctx, user.Username, other.Username), ErrFollowBlocked)
+	require.ErrorIs(FollowUserInRedis(ctx, other.Username, user.Username), ErrFollowBlocked)
+	require.NoError(PushNotification(ctx, user.ID, &models.Notification{Type: models.NotificationAccountLocked}))
+	require.NoError(client.SAdd(ctx, "living", user.Username).Err())
+	require.NoError(client.Set(ctx, "living:"+user.Username, time.Now().Format(time.RFC3339), 0).Err())
+	require.NoError(client.Set(ctx, "watching:"+user.Username, 1, 0).Err())
+
+	at := time.Now().Add(-time.Minute)
+	require.NoError(ScheduleUserDeletion(ctx, user, at))
+	require.Equal(uint(1), user.TokenVersion, "Scheduling deletion should revoke sessions.")
+	due, err := GetUsersDueForDeletion(ctx, time.Now(), 100)
+	require.NoError(err)
+	require.Contains(usernamesOf(due), user.Username)
+	require.NotContains(usernamesOf(due), fan.Username)
+
+	require.NoError(DeleteUser(ctx, user))
+	n, err := client.Exists(ctx,
+		wrapIDKey(user.ID), wrapUsernameKey(user.Username), wrapEmailKey(*user.Email),
+		wrapHistoryKey(user.ID), wrapNotificationKey(user.ID),
+		wrapFollowerKey(user.Username), wrapFollowingKey(user.Username),
+		wrapBlockingKey(user.Username), wrapBlockedByKey(user.Username),
+		"living:"+user.Username, "watching:"+user.Username,
+	).Result()
+	require.NoError(err)
+	require.Zero(n, "No redis key of deleted user should remain.")
+	living, err := GetUserIsLiving(ctx, user.Username)
+	require.NoError(err)
+	require.False(living)
+
+	followings, err := GetFollowingsFromRedis(ctx, fan.Username)
+	require.NoError(err)
+	require.NotContains(followings, user.Username, "Deleted user should be removed from followers' followings.")
+	followers, err := GetFollowersFromRedis(ctx, idol.Username)
+	require.NoError(err)
+	require.NotContains(followers, user.Username, "Deleted user should be removed from followings' followers.")
+	for _, key := range []string{wrapBlockingKey(other.Username), wrapBlockedByKey(other.Username)} {
+		n, err := client.ZCard(ctx, key).Result()
+		require.NoError(err)
+		require.Zero(n, "Deleted user should be removed from blocks.")
+	}
+	_, err = getUserByIDFromMysql(ctx, user.ID)
+	require.ErrorIs(err, ErrMySQLUserNotExists)
+}
+
+func usernamesOf(users []*models.User) []string {
+	names := make([]string, len(users))
+	for i, user := range users {
+		names[i] = user.Username
+	}
+	return names
+}
+
 func createUserForTest() {
 	users = make([]*models.User, 0, 50)
 	phone := int64(13688866600)
@@ -314,13 +506,13 @@ func checkUserInRedis(t *testing.T, from, to int) {
 		require.Equalf(users[i], user, "User %v should equal to user[%v].", i, i)
 	}
 	for i := from; i < to; i++ {
-		user, err := getUserByIDFromRedis(uint(i + 1))
+		user, err := getUserByIDFromRedis(context.Background(), uint(i + 1))
 		check(i, user, err)
-		user, err = getUserByUsernameFromRedis(strconv.Itoa(i))
+		user, err = getUserByUsernameFromRedis(context.Background(), strconv.Itoa(i))
 		check(i, user, err)
-		// user, err = getUserByEmailFromRedis(strconv.Itoa(i))
+		// user, err = getUserByEmailFromRedis(context.Background(), strconv.Itoa(i))
 		// check(i, user, err)
-		// user, err = getUserByPhoneFromRedis(strconv.Itoa(i))
+		// user, err = getUserByPhoneFromRedis(context.Background(), strconv.Itoa(i))
 		// check(i, user, err)
 	}
 }
@@ -332,9 +524,9 @@ func checkUserNotInRedis(t *testing.T, from, to int) {
 		require.EqualErrorf(err, ErrRedisUserNotExists.Error(), "Get user[%v] has an unexpected error.", i)
 	}
 	for i := from; i < to; i++ {
-		user, err := getUserByIDFromRedis(uint(i + 1))
+		user, err := getUserByIDFromRedis(context.Background(), uint(i + 1))
 		check(i, user, err)
-		user, err = getUserByUsernameFromRedis(strconv.Itoa(i))
+		user, err = getUserByUsernameFromRedis(context.Background(), strconv.Itoa(i))
 		check(i, user, err)
 	}
 }
@@ -346,8 +538,8 @@ func checkUserNotInMySQL(t *testing.T, from, to int) {
 		require.EqualErrorf(err, ErrMySQLUserNotExists.Error(), "Get user[%v] has an unexpected error.", i)
 	}
 	for i := from; i < to; i++ {
-		user, err := getUserByIDFromMysql(uint(i + 1))
-		user, err = getUserByUsernameFromMysql(strconv.Itoa(i))
+		user, err := getUserByIDFromMysql(context.Background(), uint(i + 1))
+		user, err = getUserByUsernameFromMysql(context.Background(), strconv.Itoa(i))
 		check(i, user, err)
 	}
 }
@@ -363,8 +555,8 @@ func checkUserInMySQL(t *testing.T, from, to int) {
 		require.Equalf(users[i], user, "User %v should equal to user[%v].", i, i)
 	}
 	for i := from; i < to; i++ {
-		user, err := getUserByIDFromMysql(uint(i + 1))
-		user, err = getUserByUsernameFromMysql(strconv.Itoa(i))
+		user, err := getUserByIDFromMysql(context.Background(), uint(i + 1))
+		user, err = getUserByUsernameFromMysql(context.Background(), strconv.Itoa(i))
 		check(i, user, err)
 	}
 }
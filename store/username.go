@@ -0,0 +1,202 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"minitube/models"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jinzhu/gorm"
+)
+
+// username errors
+var (
+	ErrUsernameTaken    = fmt.Errorf("%w username already exists", ErrMySQLFailed)
+	ErrUsernameReserved = fmt.Errorf("%w username is reserved", ErrMySQLFailed)
+)
+
+// renameScript - move everything keyed by or containing the old username in one step,
+// so no reader sees a half migrated user.
+//
+// KEYS[1] - old username index, KEYS[2] - new username index, KEYS[3] - user
+// KEYS[4], KEYS[5] - old and new follower zset, KEYS[6], KEYS[7] - old and new following zset
+// KEYS[8] - old watching counter, KEYS[9], KEYS[10] - old and new blocking zset
+// KEYS[11], KEYS[12] - old and new blocked by zset, KEYS[13] - last live zset
+// ARGV[1] - old username, ARGV[2] - new username, ARGV[3] - user id, ARGV[4] - user json
+// ARGV[5] - prefix of following zsets, ARGV[6] - prefix of follower zsets
+// ARGV[7] - prefix of blocking zsets, ARGV[8] - prefix of blocked by zsets
+var renameScript = redis.NewScript(`
+local old, new = ARGV[1], ARGV[2]
+
+local function rename_member(key)
+	local score = redis.call("ZSCORE", key, old)
+	if score then
+		redis.call("ZREM", key, old)
+		redis.call("ZADD", key, score, new)
+	end
+end
+
+-- reverse entries in other users' zsets
+for _, follower in ipairs(redis.call("ZRANGE", KEYS[4], 0, -1)) do
+	rename_member(ARGV[5] .. follower)
+end
+for _, following in ipairs(redis.call("ZRANGE", KEYS[6], 0, -1)) do
+	rename_member(ARGV[6] .. following)
+end
+for _, blocking in ipairs(redis.call("ZRANGE", KEYS[9], 0, -1)) do
+	rename_member(ARGV[8] .. blocking)
+end
+for _, blocker in ipairs(redis.call("ZRANGE", KEYS[11], 0, -1)) do
+	rename_member(ARGV[7] .. blocker)
+end
+rename_member(KEYS[13])
+
+-- user's own zsets
+for _, i in ipairs({4, 6, 9, 11}) do
+	if redis.call("EXISTS", KEYS[i]) == 1 then
+		redis.call("RENAME", KEYS[i], KEYS[i + 1])
+	end
+end
+
+redis.call("DEL", KEYS[1], KEYS[8])
+redis.call("SET", KEYS[2], ARGV[3])
+redis.call("SET", KEYS[3], ARGV[4])
+return 1
+`)
+
+// ChangeUsername - rename user, the old username is reserved for user until reserveUntil,
+// user's sessions are revoked since tokens carry the username.
+func ChangeUsername(ctx context.Context, user *models.User, username string, reserveUntil time.Time) (err error) {
+	ctx, span := startSpan(ctx, "ChangeUsername")
+	defer func() { endSpan(span, err) }()
+
+	old := user.Username
+	err = changeUsernameToMysql(ctx, user, username, reserveUntil)
+	if err != nil {
+		return err
+	}
+	fresh, err := getUserByIDFromMysql(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	*user = *fresh
+	return changeUsernameToRedis(ctx, user, old)
+}
+
+func changeUsernameToMysql(ctx context.Context, user *models.User, username string, reserveUntil time.Time) (err error) {
+	_, span := startMySQLSpan(ctx, "changeUsername")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	now, old := time.Now(), user.Username
+	tx := db.Begin()
+	fail := func(msg string, e error) error {
+		tx.Rollback()
+		logger(ctx).Warnw(msg, "user", user, "username", username, "error", e)
+		return ErrMySQLFailed
+	}
+
+	var n int
+	err = tx.Model(&models.User{}).Where("username = ?", username).Count(&n).Error
+	if err != nil {
+		return fail("Check username from Mysql failed", err)
+	}
+	if n > 0 {
+		tx.Rollback()
+		return ErrUsernameTaken
+	}
+	reservation := new(models.UsernameReservation)
+	err = tx.Set("gorm:query_option", "FOR UPDATE").Where("username = ?", username).First(reservation).Error
+	if err == nil && reservation.UserID != user.ID && reservation.ExpiresAt.After(now) {
+		tx.Rollback()
+		return ErrUsernameReserved
+	}
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return fail("Check username reservation from Mysql failed", err)
+	}
+	// user takes the name back, or the reservation is expired
+	err = tx.Where("username IN (?)", []string{username, old}).Delete(&models.UsernameReservation{}).Error
+	if err != nil {
+		return fail("Delete username reservation from Mysql failed", err)
+	}
+
+	err = tx.Model(user).Updates(map[string]interface{}{
+		"username":            username,
+		"username_changed_at": &now,
+		"token_version":       gorm.Expr("token_version + 1"),
+	}).Error
+	if err != nil {
+		return fail("Change username to Mysql failed", err)
+	}
+	err = tx.Create(&models.UsernameReservation{Username: old, UserID: user.ID, ExpiresAt: reserveUntil}).Error
+	if err != nil {
+		return fail("Reserve username to Mysql failed", err)
+	}
+	return tx.Commit().Error
+}
+
+func changeUsernameToRedis(ctx context.Context, user *models.User, old string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout*4)
+	defer cancel()
+
+	userBytes, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	// watch histories keep channels by id, so they need no change
+	keys := []string{
+		wrapUsernameKey(old), wrapUsernameKey(user.Username), wrapIDKey(user.ID),
+		wrapFollowerKey(old), wrapFollowerKey(user.Username),
+		wrapFollowingKey(old), wrapFollowingKey(user.Username),
+		"watching:" + old,
+		wrapBlockingKey(old), wrapBlockingKey(user.Username),
+		wrapBlockedByKey(old), wrapBlockedByKey(user.Username),
+		lastLiveKey,
+	}
+	err = renameScript.Run(ctx, client, keys,
+		old, user.Username, strconv.Itoa(int(user.ID)), userBytes,
+		wrapFollowingKey(""), wrapFollowerKey(""),
+		wrapBlockingKey(""), wrapBlockedByKey(""),
+	).Err()
+	if err != nil {
+		logger(ctx).Warnw("Change username in redis failed", "user", user, "old", old, "error", err)
+		// mysql is changed, at least drop the stale user so it's reloaded
+		client.Del(ctx, wrapIDKey(user.ID), wrapUsernameKey(old))
+	}
+	return err
+}
+
+// GetUsernameRedirect - id of user who used username before, while it's reserved
+func GetUsernameRedirect(ctx context.Context, username string) (id uint, err error) {
+	_, span := startMySQLSpan(ctx, "getUsernameRedirect")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	reservation := new(models.UsernameReservation)
+	err = db.Where("username = ? AND expires_at > ?", username, time.Now()).First(reservation).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return 0, ErrMySQLUserNotExists
+		}
+		logger(ctx).Warnw("Get username redirect from Mysql failed", "username", username, "error", err)
+		return 0, ErrMySQLFailed
+	}
+	return reservation.UserID, nil
+}
+
+// IsUsernameReserved - whether username is reserved by someone
+func IsUsernameReserved(ctx context.Context, username string) (bool, error) {
+	_, err := GetUsernameRedirect(ctx, username)
+	if errors.Is(err, ErrMySQLUserNotExists) {
+		return false, nil
+	}
+	return err == nil, err
+}
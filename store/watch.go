@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"minitube/models"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrWatchSessionNotExists - user never watched the channel, or the history is cleared
+var ErrWatchSessionNotExists = fmt.Errorf("%w watch session not exists", ErrMySQLFailed)
+
+// SaveWatchSession - save a new watch session
+func SaveWatchSession(ctx context.Context, session *models.WatchSession) (err error) {
+	_, span := startMySQLSpan(ctx, "saveWatchSession")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	err = db.Create(session).Error
+	if err != nil {
+		logger(ctx).Warnw("Save watch session to Mysql failed", "user", session.UserID, "error", err)
+		return ErrMySQLFailed
+	}
+	return nil
+}
+
+// UpdateWatchSession - save end, duration and position of watch session
+func UpdateWatchSession(ctx context.Context, session *models.WatchSession) (err error) {
+	_, span := startMySQLSpan(ctx, "updateWatchSession")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	err = db.Model(session).Updates(map[string]interface{}{
+		"ended_at": session.EndedAt,
+		"duration": session.Duration,
+		"position": session.Position,
+	}).Error
+	if err != nil {
+		logger(ctx).Warnw("Update watch session to Mysql failed", "user", session.UserID, "error", err)
+		return ErrMySQLFailed
+	}
+	return nil
+}
+
+// GetLastWatchSession - user's latest watch session on channel
+func GetLastWatchSession(ctx context.Context, userID, channelID uint) (session *models.WatchSession, err error) {
+	_, span := startMySQLSpan(ctx, "getLastWatchSession")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	session = new(models.WatchSession)
+	err = db.Where("user_id = ? AND channel_id = ?", userID, channelID).Order("id DESC").First(session).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, ErrWatchSessionNotExists
+		}
+		logger(ctx).Warnw("Get watch session from Mysql failed", "user", userID, "error", err)
+		return nil, ErrMySQLFailed
+	}
+	return session, nil
+}
+
+// ListWatchSessions - user's watch sessions with their channels, newest first
+func ListWatchSessions(ctx context.Context, userID uint, page, size int) (sessions []*models.WatchSessionInfo, total int, err error) {
+	_, span := startMySQLSpan(ctx, "listWatchSessions")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	err = db.Model(&models.WatchSession{}).Where("user_id = ?", userID).Count(&total).Error
+	if err != nil {
+		logger(ctx).Warnw("Count watch sessions from Mysql failed", "user", userID, "error", err)
+		return nil, 0, ErrMySQLFailed
+	}
+	sessions = make([]*models.WatchSessionInfo, 0, size)
+	err = db.Table("watch_session AS s").
+		Select("s.id, COALESCE(u.username, '') AS channel, s.broadcast_start, s.started_at, s.ended_at, s.duration, s.position").
+		Joins("LEFT JOIN `user` AS u ON u.id = s.channel_id").
+		Where("s.user_id = ?", userID).
+		Order("s.id DESC").Offset((page - 1) * size).Limit(size).
+		Scan(&sessions).Error
+	if err != nil {
+		logger(ctx).Warnw("List watch sessions from Mysql failed", "user", userID, "error", err)
+		return nil, 0, ErrMySQLFailed
+	}
+	return sessions, total, nil
+}
+
+// GetWatchStats - time user watched per day since, oldest day first
+func GetWatchStats(ctx context.Context, userID uint, since time.Time) (days []*models.WatchDay, err error) {
+	_, span := startMySQLSpan(ctx, "getWatchStats")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	days = make([]*models.WatchDay, 0)
+	err = db.Model(&models.WatchSession{}).
+		Select("DATE_FORMAT(started_at, '%Y-%m-%d') AS day, SUM(duration) AS duration, COUNT(*) AS sessions").
+		Where("user_id = ? AND started_at >= ?", userID, since).
+		Group("day").Order("day").
+		Scan(&days).Error
+	if err != nil {
+		logger(ctx).Warnw("Get watch stats from Mysql failed", "user", userID, "error", err)
+		return nil, ErrMySQLFailed
+	}
+	return days, nil
+}
+
+// DeleteWatchSessions - delete user's watch sessions on channel, or all of them if channelID is 0
+func DeleteWatchSessions(ctx context.Context, userID, channelID uint) (n int64, err error) {
+	_, span := startMySQLSpan(ctx, "deleteWatchSessions")
+	defer func() { endSpan(span, err) }()
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	tx := db.Where("user_id = ?", userID)
+	if channelID != 0 {
+		tx = tx.Where("channel_id = ?", channelID)
+	}
+	result := tx.Delete(&models.WatchSession{})
+	if result.Error != nil {
+		logger(ctx).Warnw("Delete watch sessions from Mysql failed", "user", userID, "error", result.Error)
+		return 0, ErrMySQLFailed
+	}
+	return result.RowsAffected, nil
+}
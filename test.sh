@@ -27,6 +27,12 @@ export MYSQL_ADDR=localhost:3306
 export REDIS_ADDR=localhost:6379
 export LIVE_ADDR=localhost:8090
 export DEBUG=true
+export RATE_LIMIT_REGISTER=1000
+export RATE_LIMIT_LOGIN=1000
+export RATE_LIMIT_PUBLIC=1000
+export RATE_LIMIT_FOLLOW=1000
+export RATE_LIMIT_REPORT=1000
+export RATE_LIMIT_EXPORT=1000
 
 # wait for mysql container initialize.
 sleep 15s
@@ -45,6 +51,12 @@ unset MYSQL_ADDR
 unset REDIS_ADDR
 unset LIVE_ADDR
 unset DEBUG
+unset RATE_LIMIT_REGISTER
+unset RATE_LIMIT_LOGIN
+unset RATE_LIMIT_PUBLIC
+unset RATE_LIMIT_FOLLOW
+unset RATE_LIMIT_REPORT
+unset RATE_LIMIT_EXPORT
 
 echo 'Stopping docker container...'
 docker stop minitube-live-test
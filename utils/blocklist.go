@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// PasswordBlocklist - sorted sha1 hashes of breached or common passwords, kept in memory
+// for binary search. It's loaded from a file of one password per line, each line is either
+// a sha1 hex as in haveibeenpwned's "SHA1:COUNT" dumps, or a plain password as in common
+// password lists.
+type PasswordBlocklist struct {
+	hashes [][sha1.Size]byte
+}
+
+// LoadPasswordBlocklist - load blocklist from file at path
+func LoadPasswordBlocklist(path string) (*PasswordBlocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadPasswordBlocklist(f)
+}
+
+// ReadPasswordBlocklist - read blocklist from r, empty lines are skipped
+func ReadPasswordBlocklist(r io.Reader) (*PasswordBlocklist, error) {
+	b := new(PasswordBlocklist)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		var sum [sha1.Size]byte
+		hash := line
+		if i := strings.IndexByte(line, ':'); i == sha1.Size*2 {
+			hash = line[:i]
+		}
+		if n, err := hex.Decode(sum[:], []byte(hash)); err != nil || n != sha1.Size {
+			sum = sha1.Sum([]byte(line))
+		}
+		b.hashes = append(b.hashes, sum)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(b.hashes, func(i, j int) bool {
+		return bytes.Compare(b.hashes[i][:], b.hashes[j][:]) < 0
+	})
+	// drop duplicates
+	n := 0
+	for i := range b.hashes {
+		if n == 0 || b.hashes[i] != b.hashes[n-1] {
+			b.hashes[n] = b.hashes[i]
+			n++
+		}
+	}
+	b.hashes = b.hashes[:n]
+	return b, nil
+}
+
+// Len - number of blocked passwords
+func (b *PasswordBlocklist) Len() int {
+	return len(b.hashes)
+}
+
+// Contains - whether plain password is blocked
+func (b *PasswordBlocklist) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	i := b.search(sum[:])
+	return i < len(b.hashes) && b.hashes[i] == sum
+}
+
+// Range - upper case hex suffixes of blocked sha1 hashes starting with prefix,
+// so clients can check a password without sending it (k-anonymity).
+// prefix must be 5 hex chars, or nil is returned.
+func (b *PasswordBlocklist) Range(prefix string) []string {
+	if len(prefix) != 5 {
+		return nil
+	}
+	// 5 hex chars are 2.5 bytes, search by 3 bytes with the half byte padded
+	start, err := hex.DecodeString(prefix + "0")
+	if err != nil {
+		return nil
+	}
+	suffixes := make([]string, 0)
+	prefix = strings.ToUpper(prefix)
+	for i := b.search(start); i < len(b.hashes); i++ {
+		h := strings.ToUpper(hex.EncodeToString(b.hashes[i][:]))
+		if !strings.HasPrefix(h, prefix) {
+			break
+		}
+		suffixes = append(suffixes, h[len(prefix):])
+	}
+	return suffixes
+}
+
+// search - index of the first hash not less than key
+func (b *PasswordBlocklist) search(key []byte) int {
+	return sort.Search(len(b.hashes), func(i int) bool {
+		return bytes.Compare(b.hashes[i][:], key) >= 0
+	})
+}
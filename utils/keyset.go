@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// jwt signing key errors
+var (
+	ErrNoSigningKey      = errors.New("no active signing key")
+	ErrUnknownSigningKey = errors.New("unknown signing key")
+)
+
+// asymmetric jwt signing algorithms
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// rsaKeyBits - size of generated RSA keys
+const rsaKeyBits = 2048
+
+// SigningKey - private key signing jwts, identified by kid. It signs from NotBefore
+// until a newer key does, and verifies until NotAfter, zero if it's not retired yet.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Key       crypto.Signer
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// GenerateSigningKey - new random key of algorithm, signing from notBefore
+func GenerateSigningKey(algorithm string, notBefore time.Time) (*SigningKey, error) {
+	var key crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgRS256:
+		key, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	id, err := RandomToken(12)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{ID: id, Algorithm: algorithm, Key: key, NotBefore: notBefore}, nil
+}
+
+// ParseSigningKey - key of algorithm from its PKCS #8 PEM
+func ParseSigningKey(id, algorithm string, data []byte, notBefore, notAfter time.Time) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid signing key %q", id)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key %q: %w", id, err)
+	}
+	k := &SigningKey{ID: id, Algorithm: algorithm, NotBefore: notBefore, NotAfter: notAfter}
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		k.Key = key
+	case ed25519.PrivateKey:
+		k.Key = key
+	}
+	if k.Key == nil || k.Method() == nil {
+		return nil, fmt.Errorf("signing key %q doesn't match algorithm %q", id, algorithm)
+	}
+	return k, nil
+}
+
+// MarshalPEM - private key in PKCS #8 PEM
+func (k *SigningKey) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// SealPEM - PKCS #8 PEM of key encrypted with AES-GCM under kek, 32 bytes for AES-256,
+// the random nonce is prepended. Key id is authenticated too, so a sealed key can't be
+// passed off as another.
+func (k *SigningKey) SealPEM(kek []byte) ([]byte, error) {
+	data, err := k.MarshalPEM()
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, data, []byte(k.ID)), nil
+}
+
+// OpenSigningKey - key of algorithm from its PEM sealed by SealPEM under kek
+func OpenSigningKey(id, algorithm string, sealed, kek []byte, notBefore, notAfter time.Time) (*SigningKey, error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid sealed signing key %q", id)
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	data, err := gcm.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sealed signing key %q: %w", id, err)
+	}
+	return ParseSigningKey(id, algorithm, data, notBefore, notAfter)
+}
+
+func newGCM(kek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Method - jwt signing method of key, nil if key doesn't match its algorithm
+func (k *SigningKey) Method() jwt.SigningMethod {
+	switch k.Key.(type) {
+	case *rsa.PrivateKey:
+		if k.Algorithm == AlgRS256 {
+			return jwt.SigningMethodRS256
+		}
+	case ed25519.PrivateKey:
+		if k.Algorithm == AlgEdDSA {
+			return jwt.SigningMethodEdDSA
+		}
+	}
+	return nil
+}
+
+// Active - whether key may sign or verify at now
+func (k *SigningKey) Active(now time.Time) bool {
+	return k.NotAfter.IsZero() || now.Before(k.NotAfter)
+}
+
+// jwk - public key in JWK format
+func (k *SigningKey) jwk() jsonWebKey {
+	enc := base64.RawURLEncoding
+	jwk := jsonWebKey{Kid: k.ID, Use: "sig", Alg: k.Algorithm}
+	switch key := k.Key.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = enc.EncodeToString(key.N.Bytes())
+		jwk.E = enc.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+		jwk.X = enc.EncodeToString(key)
+	}
+	return jwk
+}
+
+// KeySet - jwt signing keys. Tokens are signed by the newest key that has started
+// signing, and verified by the key of their kid if it's not retired. A key is
+// published before it starts signing, so verifiers caching the set know it in time.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []*SigningKey
+}
+
+// Set - replace keys of set
+func (s *KeySet) Set(keys []*SigningKey) {
+	keys = append([]*SigningKey(nil), keys...)
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].NotBefore.After(keys[j].NotBefore)
+	})
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+}
+
+// Keys - keys of set, newest first
+func (s *KeySet) Keys() []*SigningKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*SigningKey(nil), s.keys...)
+}
+
+// Signing - key signing tokens at now
+func (s *KeySet) Signing(now time.Time) (*SigningKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.keys {
+		if !k.NotBefore.After(now) && k.Active(now) {
+			return k, nil
+		}
+	}
+	return nil, ErrNoSigningKey
+}
+
+// Verifying - public key of kid verifying tokens at now
+func (s *KeySet) Verifying(kid string, now time.Time) (*SigningKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.keys {
+		if k.ID == kid && k.Active(now) {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownSigningKey, kid)
+}
+
+// JWKS - public keys not retired at now as a JSON Web Key Set, including keys not signing yet
+func (s *KeySet) JWKS(now time.Time) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]jsonWebKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		if k.Active(now) {
+			keys = append(keys, k.jwk())
+		}
+	}
+	return struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{keys}
+}
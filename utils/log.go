@@ -1,10 +1,14 @@
 package utils
 
 import (
+	"context"
 	"os"
+	"strconv"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 // Logger - used to make log faster
@@ -13,16 +17,146 @@ var Logger *zap.Logger
 // Sugar - used to make log easily
 var Sugar *zap.SugaredLogger
 
+// AccessLogger - used by Ginzap, sampled so busy routes don't flood the output
+var AccessLogger *zap.Logger
+
+// Level - log level of Logger and AccessLogger, can be changed at runtime
+var Level = zap.NewAtomicLevel()
+
+// LogConfig - logging options, read from environment by LogConfigFromEnv
+type LogConfig struct {
+	Debug bool
+	// json or console
+	Format string
+	// stdout, stderr or file
+	Output string
+	// rotating file options, size in megabytes and age in days
+	File       string
+	MaxSize    int
+	MaxAge     int
+	MaxBackups int
+	// access log sampling per second, log the first N entries with the same
+	// message, then every Mth. Zero disables sampling.
+	SampleFirst      int
+	SampleThereafter int
+}
+
+// LogConfigFromEnv - read LogConfig from LOG_* environment variables
+func LogConfigFromEnv() *LogConfig {
+	conf := &LogConfig{
+		Debug:            os.Getenv("DEBUG") == "true",
+		Format:           os.Getenv("LOG_FORMAT"),
+		Output:           os.Getenv("LOG_OUTPUT"),
+		File:             os.Getenv("LOG_FILE"),
+		MaxSize:          envInt("LOG_MAX_SIZE", 100),
+		MaxAge:           envInt("LOG_MAX_AGE", 7),
+		MaxBackups:       envInt("LOG_MAX_BACKUPS", 10),
+		SampleFirst:      envInt("LOG_SAMPLE_FIRST", 100),
+		SampleThereafter: envInt("LOG_SAMPLE_THEREAFTER", 100),
+	}
+	if conf.Format == "" {
+		if conf.Debug {
+			conf.Format = "console"
+		} else {
+			conf.Format = "json"
+		}
+	}
+	if conf.Output == "" {
+		conf.Output = "stdout"
+	}
+	if conf.File == "" {
+		conf.File = "./log/minitube.log"
+	}
+	return conf
+}
+
 func init() {
-	config := zap.NewDevelopmentConfig()
-	config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	if debug, ok := os.LookupEnv("DEBUG"); ok && debug == "true" {
-		config.Development = true
-		config.Level.SetLevel(zap.DebugLevel)
+	conf := LogConfigFromEnv()
+	if conf.Debug {
+		Level.SetLevel(zap.DebugLevel)
 	} else {
-		config.Development = false
-		config.Level.SetLevel(zap.InfoLevel)
+		Level.SetLevel(zap.InfoLevel)
 	}
-	Logger, _ = config.Build()
+	Logger, AccessLogger = NewLogger(conf, Level)
 	Sugar = Logger.Sugar()
 }
+
+// NewLogger - build application logger and sampled access logger sharing level
+func NewLogger(conf *LogConfig, level zap.AtomicLevel) (*zap.Logger, *zap.Logger) {
+	var encoder zapcore.Encoder
+	if conf.Format == "console" {
+		encoderConfig := zap.NewDevelopmentEncoderConfig()
+		encoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		if conf.Output == "file" {
+			// no color codes in files
+			encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		}
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	} else {
+		encoderConfig := zap.NewProductionEncoderConfig()
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	}
+
+	var sink zapcore.WriteSyncer
+	switch conf.Output {
+	case "file":
+		sink = zapcore.AddSync(&lumberjack.Logger{
+			Filename:   conf.File,
+			MaxSize:    conf.MaxSize,
+			MaxAge:     conf.MaxAge,
+			MaxBackups: conf.MaxBackups,
+			Compress:   true,
+		})
+	case "stderr":
+		sink = zapcore.Lock(os.Stderr)
+	default:
+		sink = zapcore.Lock(os.Stdout)
+	}
+
+	// every entry is scrubbed by RedactCore, see redact.go
+	core := RedactCore(zapcore.NewCore(encoder, sink, level))
+	opts := []zap.Option{zap.AddCaller(), zap.ErrorOutput(zapcore.Lock(os.Stderr))}
+	if conf.Debug {
+		opts = append(opts, zap.Development(), zap.AddStacktrace(zap.WarnLevel))
+	} else {
+		opts = append(opts, zap.AddStacktrace(zap.ErrorLevel))
+	}
+	logger := zap.New(core, opts...)
+
+	accessCore := core
+	if conf.SampleFirst > 0 {
+		accessCore = zapcore.NewSamplerWithOptions(core, time.Second, conf.SampleFirst, conf.SampleThereafter)
+	}
+	return logger, zap.New(accessCore, opts...).Named("access")
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+type loggerKey struct{}
+
+// WithLogger - return a copy of ctx carrying logger
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFrom - get request-scoped logger from ctx, fallback to Logger
+func LoggerFrom(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return Logger
+}
+
+// SugarFrom - get request-scoped sugared logger from ctx, fallback to Sugar
+func SugarFrom(ctx context.Context) *zap.SugaredLogger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return logger.Sugar()
+	}
+	return Sugar
+}
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// password hasher errors
+var (
+	ErrPasswordMismatch = errors.New("password mismatch")
+	ErrUnknownHash      = errors.New("unknown password hash")
+)
+
+// PasswordHasher - hash passwords into PHC strings, e.g. $argon2id$v=19$m=65536,t=3,p=2$salt$hash
+type PasswordHasher interface {
+	// Hash - hash password with a random salt
+	Hash(password string) (string, error)
+	// Verify - ErrPasswordMismatch if password doesn't match hash
+	Verify(hash, password string) error
+	// Identify - whether hash is made by this algorithm
+	Identify(hash string) bool
+	// NeedsRehash - whether hash isn't made with current algorithm and parameters
+	NeedsRehash(hash string) bool
+}
+
+// NewPasswordHasherFromEnv - hash with PASSWORD_HASH (bcrypt or argon2id, default bcrypt),
+// verify hashes of both, parameters are read from BCRYPT_COST and ARGON2_*.
+func NewPasswordHasherFromEnv() PasswordHasher {
+	bc := &BcryptHasher{Cost: envInt("BCRYPT_COST", bcrypt.DefaultCost)}
+	if bc.Cost < bcrypt.MinCost || bc.Cost > bcrypt.MaxCost {
+		// bcrypt would use another cost silently, and every login would rehash
+		bc.Cost = bcrypt.DefaultCost
+	}
+	a2 := &Argon2idHasher{Time: 3, Memory: 64 * 1024, Threads: 2}
+	// argon2 panics on zero time or threads, and out of range values would wrap
+	if t := envInt("ARGON2_TIME", 3); t >= 1 && int64(t) <= math.MaxUint32 {
+		a2.Time = uint32(t)
+	}
+	if p := envInt("ARGON2_THREADS", 2); p >= 1 && p <= math.MaxUint8 {
+		a2.Threads = uint8(p)
+	}
+	// argon2 would use 8 KiB per thread silently if there's less, and every login would rehash
+	if m := envInt("ARGON2_MEMORY", 64*1024); m >= 8*int(a2.Threads) && int64(m) <= math.MaxUint32 {
+		a2.Memory = uint32(m)
+	}
+	if strings.ToLower(os.Getenv("PASSWORD_HASH")) == "argon2id" {
+		return MultiHasher{a2, bc}
+	}
+	return MultiHasher{bc, a2}
+}
+
+// MultiHasher - hash with the first hasher, verify with the one made the hash,
+// so algorithm or parameters can change and old hashes are rehashed on login.
+type MultiHasher []PasswordHasher
+
+// Hash - hash with the first hasher
+func (m MultiHasher) Hash(password string) (string, error) {
+	return m[0].Hash(password)
+}
+
+// Verify - verify with the hasher made hash
+func (m MultiHasher) Verify(hash, password string) error {
+	for _, h := range m {
+		if h.Identify(hash) {
+			return h.Verify(hash, password)
+		}
+	}
+	return ErrUnknownHash
+}
+
+// Identify - whether any hasher made hash
+func (m MultiHasher) Identify(hash string) bool {
+	for _, h := range m {
+		if h.Identify(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsRehash - whether hash isn't made by the first hasher with its parameters
+func (m MultiHasher) NeedsRehash(hash string) bool {
+	return m[0].NeedsRehash(hash)
+}
+
+// BcryptHasher - bcrypt, its modular crypt format $2a$10$... is what PHC strings derive from
+type BcryptHasher struct {
+	Cost int
+}
+
+// Hash - bcrypt password
+func (b *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	return string(hash), err
+}
+
+// Verify - compare password with bcrypt hash
+func (b *BcryptHasher) Verify(hash, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+// Identify - $2a$, $2b$ or $2y$
+func (b *BcryptHasher) Identify(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// NeedsRehash - not bcrypt or cost changed
+func (b *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.Cost
+}
+
+// Argon2idHasher - argon2id, memory is in KiB
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// argon2id salt and key length in bytes
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+var b64 = base64.RawStdEncoding
+
+// Hash - argon2id password, in PHC string format
+func (a *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Time, a.Threads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// Verify - compare password with argon2id hash, with parameters in hash
+func (a *Argon2idHasher) Verify(hash, password string) error {
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// Identify - $argon2id$
+func (a *Argon2idHasher) Identify(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+// NeedsRehash - not argon2id or parameters changed
+func (a *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, _, _, err := parseArgon2id(hash)
+	return err != nil || *params != *a
+}
+
+func parseArgon2id(hash string) (params *Argon2idHasher, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrUnknownHash
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrUnknownHash
+	}
+	params = new(Argon2idHasher)
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return nil, nil, nil, ErrUnknownHash
+	}
+	if salt, err = b64.DecodeString(parts[4]); err != nil {
+		return nil, nil, nil, ErrUnknownHash
+	}
+	if key, err = b64.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrUnknownHash
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,273 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Redacted - placeholder written instead of sensitive values
+const Redacted = "[REDACTED]"
+
+// sensitiveWords - a field is sensitive if any word of its key is in this set,
+// e.g. "password", "new_password", "stream-key", "Authorization".
+var sensitiveWords = map[string]bool{
+	"password":      true,
+	"passwd":        true,
+	"secret":        true,
+	"token":         true,
+	"key":           true,
+	"authorization": true,
+	"cookie":        true,
+	"email":         true,
+	"phone":         true,
+}
+
+// sensitivePattern - catch `password:"xxx"`, `"token": "xxx"` or `email=xxx`
+// in free-form messages such as fmt's %#v output.
+var sensitivePattern = regexp.MustCompile(
+	`(?i)("?[\w-]*(?:password|passwd|secret|token|key|authorization|cookie|email|phone)"?\s*[:=]\s*)("[^"]*"|[^\s,}&)]+)`)
+
+// IsSensitiveKey - whether a log field key should be redacted,
+// key is split to words by separators and camel case.
+func IsSensitiveKey(key string) bool {
+	var word strings.Builder
+	check := func() bool {
+		sensitive := sensitiveWords[strings.ToLower(word.String())]
+		word.Reset()
+		return sensitive
+	}
+	prevLower := false
+	for _, r := range key {
+		isLower := r >= 'a' && r <= 'z'
+		isUpper := r >= 'A' && r <= 'Z'
+		if !isLower && !isUpper && !(r >= '0' && r <= '9') {
+			if check() {
+				return true
+			}
+			prevLower = false
+			continue
+		}
+		if isUpper && prevLower && check() {
+			return true
+		}
+		word.WriteRune(r)
+		prevLower = isLower
+	}
+	return check()
+}
+
+// RedactString - mask sensitive key/value pairs in a free-form string
+func RedactString(s string) string {
+	return sensitivePattern.ReplaceAllString(s, "${1}"+Redacted)
+}
+
+// MaskEmail - keep the first char and domain, e.g. a***@minitube.com
+func MaskEmail(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 {
+		return Redacted
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+// MaskPhone - keep the last 4 digits, e.g. ***6868
+func MaskPhone(phone string) string {
+	if len(phone) <= 4 {
+		return Redacted
+	}
+	return "***" + phone[len(phone)-4:]
+}
+
+// RedactCore - wrap core so every entry is scrubbed before written,
+// use it with zap.WrapCore.
+func RedactCore(core zapcore.Core) zapcore.Core {
+	return &redactCore{Core: core}
+}
+
+type redactCore struct {
+	zapcore.Core
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(redactFields(fields))}
+}
+
+func (c *redactCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return ce.AddCore(entry, c)
+	}
+	return ce
+}
+
+func (c *redactCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = RedactString(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		switch {
+		case IsSensitiveKey(field.Key):
+			redacted[i] = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: Redacted}
+		case field.Type == zapcore.StringType:
+			field.String = RedactString(field.String)
+			redacted[i] = field
+		case field.Type == zapcore.ErrorType:
+			// errors may quote the value which failed, e.g. duplicate email
+			err, _ := field.Interface.(error)
+			if err == nil {
+				redacted[i] = field
+				break
+			}
+			redacted[i] = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: RedactString(err.Error())}
+		case field.Type == zapcore.StringerType:
+			redacted[i] = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: RedactString(fmt.Sprint(field.Interface))}
+		case field.Type == zapcore.ObjectMarshalerType || field.Type == zapcore.InlineMarshalerType:
+			// nested keys are checked as the object encodes itself
+			field.Interface = redactObject{field.Interface.(zapcore.ObjectMarshaler)}
+			redacted[i] = field
+		case field.Type == zapcore.ArrayMarshalerType:
+			field.Interface = redactArray{field.Interface.(zapcore.ArrayMarshaler)}
+			redacted[i] = field
+		case field.Type == zapcore.ReflectType:
+			field.Interface = redactReflected(field.Interface)
+			redacted[i] = field
+		default:
+			redacted[i] = field
+		}
+	}
+	return redacted
+}
+
+// redactReflected - v as its json form with sensitive keys and values masked at any depth,
+// so maps and structs logged with zap.Any are scrubbed like fields are
+func redactReflected(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return RedactString(fmt.Sprintf("%+v", v))
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return Redacted
+	}
+	return redactValue(generic)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if IsSensitiveKey(key) {
+				v[key] = Redacted
+			} else {
+				v[key] = redactValue(value)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactValue(value)
+		}
+		return v
+	case string:
+		return RedactString(v)
+	default:
+		return v
+	}
+}
+
+// redactObject - object marshaler encoding through redactEncoder
+type redactObject struct {
+	zapcore.ObjectMarshaler
+}
+
+func (o redactObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	return o.ObjectMarshaler.MarshalLogObject(redactEncoder{enc})
+}
+
+// redactArray - array marshaler encoding through redactArrayEncoder
+type redactArray struct {
+	zapcore.ArrayMarshaler
+}
+
+func (a redactArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	return a.ArrayMarshaler.MarshalLogArray(redactArrayEncoder{enc})
+}
+
+// redactEncoder - object encoder masking sensitive keys and values of nested objects
+type redactEncoder struct {
+	zapcore.ObjectEncoder
+}
+
+func (e redactEncoder) AddString(key, value string) {
+	if IsSensitiveKey(key) {
+		value = Redacted
+	}
+	e.ObjectEncoder.AddString(key, RedactString(value))
+}
+
+func (e redactEncoder) AddByteString(key string, value []byte) {
+	e.AddString(key, string(value))
+}
+
+func (e redactEncoder) AddBinary(key string, value []byte) {
+	if IsSensitiveKey(key) {
+		e.ObjectEncoder.AddString(key, Redacted)
+		return
+	}
+	e.ObjectEncoder.AddBinary(key, value)
+}
+
+func (e redactEncoder) AddObject(key string, marshaler zapcore.ObjectMarshaler) error {
+	if IsSensitiveKey(key) {
+		e.ObjectEncoder.AddString(key, Redacted)
+		return nil
+	}
+	return e.ObjectEncoder.AddObject(key, redactObject{marshaler})
+}
+
+func (e redactEncoder) AddArray(key string, marshaler zapcore.ArrayMarshaler) error {
+	if IsSensitiveKey(key) {
+		e.ObjectEncoder.AddString(key, Redacted)
+		return nil
+	}
+	return e.ObjectEncoder.AddArray(key, redactArray{marshaler})
+}
+
+func (e redactEncoder) AddReflected(key string, value interface{}) error {
+	if IsSensitiveKey(key) {
+		e.ObjectEncoder.AddString(key, Redacted)
+		return nil
+	}
+	return e.ObjectEncoder.AddReflected(key, redactReflected(value))
+}
+
+// redactArrayEncoder - array encoder masking sensitive values of nested objects
+type redactArrayEncoder struct {
+	zapcore.ArrayEncoder
+}
+
+func (e redactArrayEncoder) AppendString(value string) {
+	e.ArrayEncoder.AppendString(RedactString(value))
+}
+
+func (e redactArrayEncoder) AppendByteString(value []byte) {
+	e.ArrayEncoder.AppendString(RedactString(string(value)))
+}
+
+func (e redactArrayEncoder) AppendObject(marshaler zapcore.ObjectMarshaler) error {
+	return e.ArrayEncoder.AppendObject(redactObject{marshaler})
+}
+
+func (e redactArrayEncoder) AppendArray(marshaler zapcore.ArrayMarshaler) error {
+	return e.ArrayEncoder.AppendArray(redactArray{marshaler})
+}
+
+func (e redactArrayEncoder) AppendReflected(value interface{}) error {
+	return e.ArrayEncoder.AppendReflected(redactReflected(value))
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName - service name reported to tracing backend
+const ServiceName = "minitube"
+
+// TracerProvider - global tracer provider, flushed by ShutdownTracer
+var TracerProvider *sdktrace.TracerProvider
+
+// Tracer - used to start spans
+var Tracer trace.Tracer
+
+func init() {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", ServiceName),
+		)),
+	}
+
+	// OTEL_TRACES_EXPORTER selects where spans go: otlp, stdout or none.
+	// Without exporter spans are still created, so trace id can be logged.
+	switch os.Getenv("OTEL_TRACES_EXPORTER") {
+	case "otlp":
+		// endpoint is configured by OTEL_EXPORTER_OTLP_ENDPOINT
+		exporter, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			Sugar.Warn("Create otlp trace exporter failed: ", err)
+			break
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			Sugar.Warn("Create stdout trace exporter failed: ", err)
+			break
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	TracerProvider = sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(TracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	Tracer = TracerProvider.Tracer(ServiceName)
+}
+
+// ShutdownTracer - flush and stop the tracer provider
+func ShutdownTracer() {
+	if err := TracerProvider.Shutdown(context.Background()); err != nil {
+		Sugar.Warn("Shutdown tracer provider failed: ", err)
+	}
+}
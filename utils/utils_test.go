@@ -1 +1,509 @@
 package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const secret = "c96d84ba3b4a823c4fee088a7369a5c02f50ef40f9ca54bdec34843eba157132"
+
+func newRedactLogger() (*zap.Logger, *observer.ObservedLogs) {
+	core, logs := observer.New(zap.DebugLevel)
+	return zap.New(RedactCore(core)), logs
+}
+
+func requireNoSecret(t *testing.T, logs *observer.ObservedLogs, secrets ...string) {
+	for _, entry := range logs.All() {
+		line := entry.Message
+		for k, v := range entry.ContextMap() {
+			line += " " + k + "=" + toString(v)
+		}
+		for _, s := range secrets {
+			require.NotContainsf(t, line, s, "Log line <%v> leaks secret.", line)
+		}
+	}
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		var b strings.Builder
+		for k, vv := range v {
+			b.WriteString(k + ":" + toString(vv) + " ")
+		}
+		return b.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func TestIsSensitiveKey(t *testing.T) {
+	require := require.New(t)
+
+	for _, key := range []string{"password", "old_password", "NewPassword", "token", "stream-key",
+		"Authorization", "Cookie", "email", "phone", "JWT_SECRET_KEY"} {
+		require.Truef(IsSensitiveKey(key), "%v should be sensitive.", key)
+	}
+	for _, key := range []string{"username", "request_id", "trace_id", "path", "status", "monkey"} {
+		require.Falsef(IsSensitiveKey(key), "%v shouldn't be sensitive.", key)
+	}
+}
+
+func TestRedactFields(t *testing.T) {
+	logger, logs := newRedactLogger()
+
+	logger.Info("login",
+		zap.String("password", secret),
+		zap.String("token", secret),
+		zap.String("email", "121@minitube.com"),
+		zap.String("phone", "+8612468686868"),
+		zap.String("username", "121"),
+	)
+	logger.With(zap.String("stream_key", secret)).Info("with fields")
+	logger.Sugar().Infow("sugar", "new_password", secret)
+
+	requireNoSecret(t, logs, secret, "121@minitube.com", "+8612468686868")
+	require.Equal(t, "121", logs.All()[0].ContextMap()["username"], "Username isn't sensitive.")
+}
+
+func TestRedactMessage(t *testing.T) {
+	logger, logs := newRedactLogger()
+	sugar := logger.Sugar()
+
+	type register struct {
+		Username string
+		Password string
+		Email    string
+	}
+	sugar.Debugf("User register <%#v>", &register{"121", secret, "121@minitube.com"})
+	sugar.Infof(`{"username":"121","password":"%v","email":"121@minitube.com"}`, secret)
+	logger.Info("/login", zap.String("query", "token="+secret+"&next=/"))
+	logger.Error("save failed", zap.Error(errors.New("duplicate email=121@minitube.com")))
+
+	requireNoSecret(t, logs, secret, "121@minitube.com")
+}
+
+func TestRedactObject(t *testing.T) {
+	logger, logs := newRedactLogger()
+
+	logger.Info("object", zap.Object("user", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("username", "121")
+		enc.AddString("email", MaskEmail("121@minitube.com"))
+		enc.AddString("phone", MaskPhone("+8612468686868"))
+		return nil
+	})))
+
+	requireNoSecret(t, logs, "121@minitube.com", "+8612468686868")
+}
+
+func TestRedactNested(t *testing.T) {
+	logger, logs := newRedactLogger()
+
+	type login struct {
+		Username string
+		Password string
+	}
+	logger.Info("any", zap.Any("request", map[string]interface{}{
+		"username": "121",
+		"password": secret,
+		"nested":   map[string]string{"stream_key": secret, "query": "token=" + secret},
+		"list":     []interface{}{map[string]string{"token": secret}},
+	}))
+	logger.Info("reflect", zap.Reflect("login", &login{"121", secret}))
+	logger.Sugar().Infow("sugar", "form", map[string][]string{"new_password": {secret}})
+	logger.Info("object", zap.Object("user", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("password", secret)
+		return enc.AddObject("session", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("token", secret)
+			return enc.AddReflected("cookies", map[string]string{"cookie": secret})
+		}))
+	})))
+	logger.Info("array", zap.Array("users", zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+		return enc.AppendObject(zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("secret", secret)
+			return nil
+		}))
+	})))
+
+	requireNoSecret(t, logs, secret)
+	request, _ := logs.All()[0].ContextMap()["request"].(map[string]interface{})
+	require.Equal(t, "121", request["username"], "Username isn't sensitive.")
+}
+
+func TestMask(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal("1***@minitube.com", MaskEmail("121@minitube.com"))
+	require.Equal(Redacted, MaskEmail("minitube"))
+	require.Equal("***6868", MaskPhone("+8612468686868"))
+	require.Equal(Redacted, MaskPhone("123"))
+}
+
+func TestNewLoggerJSONFile(t *testing.T) {
+	require := require.New(t)
+
+	file := t.TempDir() + "/minitube.log"
+	conf := &LogConfig{Format: "json", Output: "file", File: file, MaxSize: 1, MaxAge: 1, MaxBackups: 1}
+	logger, _ := NewLogger(conf, zap.NewAtomicLevelAt(zap.InfoLevel))
+	logger.Info("hello", zap.String("password", secret))
+	logger.Debug("not enabled")
+	require.NoError(logger.Sync())
+
+	content, err := os.ReadFile(file)
+	require.NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(lines, 1, "Debug entry shouldn't be written at info level.")
+
+	var entry map[string]interface{}
+	require.NoError(json.Unmarshal([]byte(lines[0]), &entry), "Log line should be json.")
+	require.Equal("hello", entry["msg"])
+	require.Equal(Redacted, entry["password"])
+}
+
+func TestNewLoggerLevelAndSampling(t *testing.T) {
+	require := require.New(t)
+
+	file := t.TempDir() + "/access.log"
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	conf := &LogConfig{Format: "json", Output: "file", File: file, SampleFirst: 2, SampleThereafter: 100}
+	logger, access := NewLogger(conf, level)
+
+	for i := 0; i < 10; i++ {
+		access.Info("/living/8")
+	}
+	logger.Debug("hidden")
+	level.SetLevel(zap.DebugLevel)
+	logger.Debug("shown")
+	require.NoError(logger.Sync())
+
+	content, err := os.ReadFile(file)
+	require.NoError(err)
+	require.Equal(2, strings.Count(string(content), "/living/8"), "Access log should be sampled.")
+	require.NotContains(string(content), "hidden")
+	require.Contains(string(content), "shown", "Level should be adjustable at runtime.")
+}
+
+func TestPasswordHashers(t *testing.T) {
+	require := require.New(t)
+
+	hashers := []PasswordHasher{
+		&BcryptHasher{Cost: 4},
+		&Argon2idHasher{Time: 1, Memory: 1024, Threads: 1},
+	}
+	for _, h := range hashers {
+		hash, err := h.Hash(secret)
+		require.NoError(err)
+		require.True(h.Identify(hash))
+		require.LessOrEqual(len(hash), 255, "Hash should fit in password column.")
+		require.NoError(h.Verify(hash, secret))
+		require.ErrorIs(h.Verify(hash, "minitube"), ErrPasswordMismatch)
+		require.False(h.NeedsRehash(hash))
+
+		other, err := h.Hash(secret)
+		require.NoError(err)
+		require.NotEqual(hash, other, "Hash should be salted.")
+	}
+
+	hash, err := hashers[1].Hash(secret)
+	require.NoError(err)
+	require.True(strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), hash)
+	require.True((&Argon2idHasher{Time: 2, Memory: 1024, Threads: 1}).NeedsRehash(hash))
+	require.True((&BcryptHasher{Cost: 5}).NeedsRehash(hash))
+}
+
+func TestMultiHasher(t *testing.T) {
+	require := require.New(t)
+
+	bc := &BcryptHasher{Cost: 4}
+	a2 := &Argon2idHasher{Time: 1, Memory: 1024, Threads: 1}
+	old, err := bc.Hash(secret)
+	require.NoError(err)
+
+	// switched from bcrypt to argon2id, old hashes still verify and need rehash
+	m := MultiHasher{a2, bc}
+	require.NoError(m.Verify(old, secret))
+	require.ErrorIs(m.Verify(old, "minitube"), ErrPasswordMismatch)
+	require.True(m.NeedsRehash(old))
+
+	hash, err := m.Hash(secret)
+	require.NoError(err)
+	require.True(a2.Identify(hash))
+	require.False(m.NeedsRehash(hash))
+
+	require.ErrorIs(m.Verify(secret, secret), ErrUnknownHash, "Unhashed password should never verify.")
+	require.ErrorIs(m.Verify("$argon2id$v=19$m=1024,t=1,p=1$$", secret), ErrUnknownHash)
+}
+
+func TestNewPasswordHasherFromEnv(t *testing.T) {
+	require := require.New(t)
+
+	t.Setenv("PASSWORD_HASH", "argon2id")
+	t.Setenv("ARGON2_TIME", "1")
+	t.Setenv("ARGON2_MEMORY", "1024")
+	t.Setenv("ARGON2_THREADS", "1")
+	hash, err := NewPasswordHasherFromEnv().Hash(secret)
+	require.NoError(err)
+	require.True(strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), hash)
+
+	t.Setenv("PASSWORD_HASH", "")
+	t.Setenv("BCRYPT_COST", "5")
+	h := NewPasswordHasherFromEnv()
+	require.True(h.NeedsRehash(hash))
+	require.NoError(h.Verify(hash, secret))
+	hash, err = h.Hash(secret)
+	require.NoError(err)
+	require.True(strings.HasPrefix(hash, "$2a$05$"), hash)
+
+	// Invalid argon2 parameters fall back to defaults instead of panicking or wrapping.
+	t.Setenv("PASSWORD_HASH", "argon2id")
+	t.Setenv("ARGON2_TIME", "0")
+	t.Setenv("ARGON2_MEMORY", "-1")
+	t.Setenv("ARGON2_THREADS", "256")
+	h = NewPasswordHasherFromEnv()
+	require.Equal(&Argon2idHasher{Time: 3, Memory: 64 * 1024, Threads: 2}, h.(MultiHasher)[0])
+	t.Setenv("ARGON2_MEMORY", "8")
+	t.Setenv("ARGON2_THREADS", "4")
+	h = NewPasswordHasherFromEnv()
+	require.Equal(&Argon2idHasher{Time: 3, Memory: 64 * 1024, Threads: 4}, h.(MultiHasher)[0],
+		"Memory less than 8 KiB per thread should fall back.")
+}
+
+func TestPasswordBlocklist(t *testing.T) {
+	require := require.New(t)
+
+	// plain passwords and sha1 hashes as in haveibeenpwned dumps
+	file := t.TempDir() + "/breached.txt"
+	content := "123456\r\npassword\n\n123456\n5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3730471\n" +
+		"a94a8fe5ccb19ba61c4c0873d391e987982fbbd3\n"
+	require.NoError(os.WriteFile(file, []byte(content), 0644))
+	b, err := LoadPasswordBlocklist(file)
+	require.NoError(err)
+	require.Equal(3, b.Len(), "Duplicates should be dropped.")
+
+	require.True(b.Contains("123456"))
+	require.True(b.Contains("password"))
+	require.True(b.Contains("test"))
+	require.False(b.Contains("Password"))
+	require.False(b.Contains("correct horse battery staple"))
+
+	require.Equal([]string{"1E4C9B93F3F0682250B6CF8331B7EE68FD8"}, b.Range("5baa6"))
+	require.Equal([]string{"FE5CCB19BA61C4C0873D391E987982FBBD3"}, b.Range("A94A8"))
+	require.Empty(b.Range("00000"))
+	require.NotNil(b.Range("00000"))
+	require.Nil(b.Range("5baa"))
+	require.Nil(b.Range("zzzzz"))
+
+	_, err = LoadPasswordBlocklist(t.TempDir() + "/none.txt")
+	require.Error(err)
+}
+
+// fakeOIDCProvider - a local OpenID Connect provider, it signs in "alice" for any
+// authorization request, and checks the PKCE verifier on token exchange.
+type fakeOIDCProvider struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p := &fakeOIDCProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "minitube" || secret != "secret" || r.FormValue("code") != "code" ||
+			PKCEChallenge(r.FormValue("code_verifier")) != p.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss": p.URL, "aud": "minitube", "sub": "alice", "nonce": p.nonce,
+			"exp": time.Now().Add(time.Minute).Unix(), "email": "alice@minitube.com", "email_verified": true,
+		}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "id_token": signed})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestOIDCProvider(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	fake := newFakeOIDCProvider(t)
+	p := &OIDCProvider{Issuer: fake.URL, ClientID: "minitube", ClientSecret: "secret", RedirectURL: "http://minitube/callback"}
+	verifier, challenge, err := NewPKCEVerifier()
+	require.NoError(err)
+	fake.challenge, fake.nonce = challenge, "nonce"
+
+	uri, err := p.AuthCodeURL(ctx, "state", "nonce", challenge)
+	require.NoError(err)
+	u, err := url.Parse(uri)
+	require.NoError(err)
+	require.Equal("/authorize", u.Path)
+	require.Equal(challenge, u.Query().Get("code_challenge"))
+	require.Equal("S256", u.Query().Get("code_challenge_method"))
+	require.Equal("state", u.Query().Get("state"))
+	require.Equal("openid email profile", u.Query().Get("scope"))
+
+	identity, err := p.Exchange(ctx, "code", verifier, "nonce")
+	require.NoError(err)
+	require.Equal(&OIDCIdentity{Subject: "alice", Email: "alice@minitube.com", EmailVerified: true}, identity)
+
+	_, err = p.Exchange(ctx, "code", "wrong verifier", "nonce")
+	require.ErrorIs(err, ErrOIDCProvider, "Code must be exchanged with its verifier.")
+	_, err = p.Exchange(ctx, "code", verifier, "replayed")
+	require.ErrorIs(err, ErrOIDCInvalidToken)
+
+	fake.claims = jwt.MapClaims{"aud": "someone"}
+	_, err = p.Exchange(ctx, "code", verifier, "nonce")
+	require.ErrorIs(err, ErrOIDCInvalidToken)
+	fake.claims = jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}
+	_, err = p.Exchange(ctx, "code", verifier, "nonce")
+	require.ErrorIs(err, ErrOIDCInvalidToken)
+
+	// a token signed by another key
+	other := &OIDCProvider{Issuer: fake.URL, ClientID: "minitube", ClientSecret: "secret"}
+	other.config = &oidcConfig{Issuer: fake.URL, TokenEndpoint: fake.URL + "/token", JWKSURI: fake.URL + "/jwks"}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+	other.keys, other.keysFetchedAt = map[string]interface{}{"k1": &key.PublicKey}, time.Now()
+	fake.claims = nil
+	_, err = other.Exchange(ctx, "code", verifier, "nonce")
+	require.ErrorIs(err, ErrOIDCInvalidToken)
+}
+
+func TestKeySet(t *testing.T) {
+	require := require.New(t)
+	now := time.Now()
+
+	_, err := GenerateSigningKey("HS256", now)
+	require.Error(err)
+
+	set := new(KeySet)
+	_, err = set.Signing(now)
+	require.ErrorIs(err, ErrNoSigningKey)
+
+	old, err := GenerateSigningKey(AlgRS256, now.Add(-time.Hour))
+	require.NoError(err)
+	next, err := GenerateSigningKey(AlgEdDSA, now.Add(time.Minute))
+	require.NoError(err)
+	set.Set([]*SigningKey{old, next})
+
+	// The old key signs until the next one starts, and both are published.
+	key, err := set.Signing(now)
+	require.NoError(err)
+	require.Equal(old.ID, key.ID)
+	key, err = set.Signing(now.Add(2 * time.Minute))
+	require.NoError(err)
+	require.Equal(next.ID, key.ID)
+	_, err = set.Verifying("unknown", now)
+	require.ErrorIs(err, ErrUnknownSigningKey)
+
+	body, err := json.Marshal(set.JWKS(now))
+	require.NoError(err)
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	require.NoError(json.Unmarshal(body, &jwks))
+	require.Len(jwks.Keys, 2)
+
+	// Tokens signed by each key verify with its published public key.
+	for i, k := range []*SigningKey{next, old} {
+		require.Equal(k.ID, jwks.Keys[i].Kid)
+		require.Equal(k.Algorithm, jwks.Keys[i].Alg)
+		public, err := jwks.Keys[i].publicKey()
+		require.NoError(err)
+		signed, err := jwt.NewWithClaims(k.Method(), jwt.MapClaims{"sub": "121"}).SignedString(k.Key)
+		require.NoError(err)
+		_, err = jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return public, nil })
+		require.NoError(err)
+
+		// Keys survive a round trip through PEM.
+		data, err := k.MarshalPEM()
+		require.NoError(err)
+		parsed, err := ParseSigningKey(k.ID, k.Algorithm, data, k.NotBefore, time.Time{})
+		require.NoError(err)
+		require.Equal(k.Key, parsed.Key)
+	}
+	_, err = ParseSigningKey(old.ID, AlgEdDSA, mustPEM(t, old), old.NotBefore, time.Time{})
+	require.Error(err, "Key of another algorithm should be rejected.")
+
+	// Sealed keys open with the same kek and id only.
+	kek := make([]byte, 32)
+	_, err = rand.Read(kek)
+	require.NoError(err)
+	sealed, err := next.SealPEM(kek)
+	require.NoError(err)
+	require.NotContains(string(sealed), "PRIVATE KEY")
+	opened, err := OpenSigningKey(next.ID, next.Algorithm, sealed, kek, next.NotBefore, time.Time{})
+	require.NoError(err)
+	require.Equal(next.Key, opened.Key)
+	_, err = OpenSigningKey(old.ID, next.Algorithm, sealed, kek, next.NotBefore, time.Time{})
+	require.Error(err, "Sealed key can't be passed off as another.")
+	_, err = OpenSigningKey(next.ID, next.Algorithm, sealed, make([]byte, 32), next.NotBefore, time.Time{})
+	require.Error(err, "Wrong kek should be rejected.")
+	_, err = OpenSigningKey(next.ID, next.Algorithm, sealed[:4], kek, next.NotBefore, time.Time{})
+	require.Error(err)
+
+	// Retired keys neither sign nor verify.
+	old.NotAfter = now
+	set.Set([]*SigningKey{old})
+	_, err = set.Signing(now)
+	require.ErrorIs(err, ErrNoSigningKey)
+	_, err = set.Verifying(old.ID, now)
+	require.ErrorIs(err, ErrUnknownSigningKey)
+}
+
+func mustPEM(t *testing.T, k *SigningKey) []byte {
+	data, err := k.MarshalPEM()
+	require.NoError(t, err)
+	return data
+}